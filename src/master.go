@@ -2,16 +2,34 @@ package main
 
 import (
 	"crazys3/src/pkg"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"io/ioutil"
 	"net/rpc"
+	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // master is responsible for data collecting and distributing
 // author: jiateng.liang@nyu.edu
 
+var (
+	// closed by rpcClose when the job is finished
+	jobDone = make(chan struct{})
+	// held while refreshCredentials calls the workers, so rpcClose doesn't close connections in use
+	clientsMutex sync.Mutex
+)
+
 func main() {
 	pkg.BootStrap()
 	clients := make([]*rpc.Client, len(pkg.GConfig.Workers))
@@ -23,7 +41,7 @@ func main() {
 	task := ""
 	err = survey.AskOne(&survey.Select{
 		Message: "Select a task to execute:",
-		Options: []string{"S3 Bucket Migration", "S3 Bucket Restoration", "S3 Bucket Recovery(Glacier to Standard)"},
+		Options: []string{"S3 Bucket Migration", "S3 Bucket Restoration", "S3 Bucket Recovery(Glacier to Standard)", "S3 Bucket Verification", "S3 Bucket Archive", "S3 Bucket Undelete", "S3 Bucket Rollback", "S3 Bucket Scan", "S3 Bucket Export", "S3 Bucket Diff", "S3 Lifecycle Check", "S3 Restore Monitor"},
 	}, &task)
 	if err != nil {
 		pkg.GLogger.Error("Exception in selecting tasks, reason: %v", err)
@@ -36,12 +54,12 @@ func main() {
 		var qs = []*survey.Question{
 			{
 				Name:     "source",
-				Prompt:   &survey.Input{Message: "Source Bucket Name"},
+				Prompt:   &survey.Input{Message: "Source Bucket Name(or directory as file:///path)"},
 				Validate: survey.Required,
 			},
 			{
 				Name:     "target",
-				Prompt:   &survey.Input{Message: "Target Bucket Name"},
+				Prompt:   &survey.Input{Message: "Target Bucket Name(or directory as file:///path)"},
 				Validate: survey.Required,
 			},
 			{
@@ -53,24 +71,110 @@ func main() {
 				Prompt:   &survey.Input{Message: "AWS Profile"},
 				Validate: survey.Required,
 			},
+			objectListQuestion(),
+			{
+				Name:   "targetprofile",
+				Prompt: &survey.Input{Message: "Target AWS Profile(leave blank if the target is in the same account)"},
+			},
+			{
+				Name: "mode",
+				Prompt: &survey.Select{
+					Message: "Migration Mode",
+					Options: []string{"Copy", "Sync", "Mirror"},
+					Help:    "Copy: copy every object. Sync: only copy new or changed objects. Mirror: sync and delete target objects absent in source",
+				},
+			},
+			{
+				Name: "conflict",
+				Prompt: &survey.Select{
+					Message: "If Target File Exists",
+					Options: pkg.ConflictPolicies,
+				},
+			},
+			{
+				Name:   "rules",
+				Prompt: &survey.Input{Message: "Key Rewrite Rules File(leave blank to keep keys)"},
+			},
+			{
+				Name:   "versions",
+				Prompt: &survey.Confirm{Message: "Migrate All Versions(full history in chronological order)"},
+			},
+			{
+				Name:   "bucketconfig",
+				Prompt: &survey.Confirm{Message: "Migrate Bucket Configuration(policy, cors, lifecycle, tags, versioning, encryption, website, public access block)"},
+			},
+			{
+				Name:   "createregion",
+				Prompt: &survey.Input{Message: "Create Target Bucket In Region(leave blank if the target exists)"},
+			},
+			{
+				Name:   "dryrun",
+				Prompt: &survey.Confirm{Message: "Dry Run"},
+			},
 		}
 		answers := struct {
-			Source  string
-			Target  string
-			Profile string
-			Prefix  string
+			Source        string
+			Target        string
+			Profile       string
+			TargetProfile string
+			Prefix        string
+			Objects       string
+			Mode          string
+			Conflict      string
+			Rules         string
+			Versions      bool
+			BucketConfig  bool
+			CreateRegion  string
+			DryRun        bool
 		}{}
 		err = survey.Ask(qs, &answers)
 		if err != nil {
 			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
 			return
 		}
+		options := &MigrationOptions{
+			Sync:           answers.Mode == "Sync" || answers.Mode == "Mirror",
+			Mirror:         answers.Mode == "Mirror",
+			ConflictPolicy: answers.Conflict,
+			DryRun:         answers.DryRun,
+			AllVersions:    answers.Versions,
+			TargetProfile:  answers.TargetProfile,
+			ObjectList:     answers.Objects,
+			BucketConfig:   answers.BucketConfig,
+			CreateRegion:   answers.CreateRegion,
+		}
+		if answers.BucketConfig {
+			err = survey.AskOne(&survey.Confirm{
+				Message: "Migrate Notifications once Objects are Copied",
+				Help:    "Notifications target Lambda functions, queues and topics of the source account, which must allow the target bucket",
+			}, &options.Notifications)
+			if err != nil {
+				pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+				return
+			}
+		}
+		options.StorageClass, options.Metadata, options.Encryption, err = askCopyOptions()
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		if answers.Rules != "" {
+			options.Rewriter, err = pkg.LoadKeyRewriter(answers.Rules)
+			if err != nil {
+				pkg.GLogger.Error("Exception in loading key rewrite rules, reason: %v", err)
+				return
+			}
+		}
 		startTime = time.Now()
-		err = RunMigrationJob(answers.Source, answers.Target, answers.Prefix, clients, answers.Profile)
+		err = RunMigrationJob(answers.Source, answers.Target, answers.Prefix, clients, answers.Profile, options)
 		if err != nil {
 			pkg.GLogger.Error("Exception in running task [S3 Bucket Migration], reason: %v", err)
 			return
 		}
+		if options.DryRun {
+			rpcClose(clients)
+			return
+		}
 		break
 	case "S3 Bucket Restoration":
 		var qs = []*survey.Question{
@@ -97,13 +201,23 @@ func main() {
 				Prompt:   &survey.Input{Message: "AWS Profile"},
 				Validate: survey.Required,
 			},
+			objectListQuestion(),
+			{
+				Name: "versions",
+				Prompt: &survey.Input{
+					Message: "Versions(leave blank for current versions)",
+					Help:    "all: restore every version of a versioned bucket, or the id of a single version to restore",
+				},
+			},
 		}
 		answers := struct {
-			Bucket  string
-			Days    int64
-			Profile string
-			Prefix  string
-			Speed   string
+			Bucket   string
+			Days     int64
+			Profile  string
+			Prefix   string
+			Speed    string
+			Versions string
+			Objects  string
 		}{}
 		err = survey.Ask(qs, &answers)
 		if err != nil {
@@ -111,7 +225,7 @@ func main() {
 			return
 		}
 		startTime = time.Now()
-		err = RunRestorationJob(answers.Bucket, answers.Prefix, clients, answers.Profile, answers.Days, answers.Speed)
+		err = RunRestorationJob(answers.Bucket, answers.Prefix, clients, answers.Profile, answers.Days, answers.Speed, answers.Versions, answers.Objects)
 		if err != nil {
 			pkg.GLogger.Error("Exception in running task [S3 Bucket Restoration], reason: %v", err)
 			return
@@ -133,220 +247,2050 @@ func main() {
 				Prompt:   &survey.Input{Message: "AWS Profile"},
 				Validate: survey.Required,
 			},
+			objectListQuestion(),
+			{
+				Name: "versions",
+				Prompt: &survey.Input{
+					Message: "Versions(leave blank for current versions)",
+					Help:    "all: recover every archived version of a versioned bucket, or the id of a single version to recover. Versions must be restored first, newer versions of the key are copied again so the latest version stays current",
+				},
+			},
 		}
 		answers := struct {
-			Bucket  string
-			Profile string
-			Prefix  string
+			Bucket   string
+			Profile  string
+			Prefix   string
+			Versions string
+			Objects  string
 		}{}
 		err = survey.Ask(qs, &answers)
 		if err != nil {
 			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
 			return
 		}
+		deleteReplayed := false
+		if answers.Versions != "" {
+			err = survey.AskOne(&survey.Confirm{
+				Message: "Delete the Replayed Versions once Copied",
+				Help:    "The replayed versions aren't billed anymore and the history isn't doubled, but every version of the key gets a new id",
+			}, &deleteReplayed)
+			if err != nil {
+				pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+				return
+			}
+		}
+		storageClass, metadata, encryption, err := askCopyOptions()
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
 		startTime = time.Now()
-		err = RunRecoveryJob(answers.Bucket, answers.Prefix, clients, answers.Profile)
+		err = RunRecoveryJob(answers.Bucket, answers.Prefix, clients, answers.Profile, storageClass, metadata, encryption, answers.Versions, deleteReplayed, answers.Objects)
 		if err != nil {
 			pkg.GLogger.Error("Exception in running task [S3 Bucket Recovery], reason: %v", err)
 			return
 		}
 		break
-	}
-
-	timer := time.NewTimer(10 * time.Second)
-	for {
-		select {
-		case <-timer.C:
-			num := 0
-			for _, cli := range clients {
-				res := false
-				cli.Call("RpcHandler.HandleTaskStatus", "", &res)
-				if res {
-					num++
-				}
-			}
-			if num == len(clients) {
-				pkg.GLogger.Info("Task finished. Time spent: %v hours", time.Since(startTime).Hours())
-				goto EXIT
-			}
-			timer.Reset(10 * time.Second)
+	case "S3 Bucket Verification":
+		var qs = []*survey.Question{
+			{
+				Name:     "source",
+				Prompt:   &survey.Input{Message: "Source Bucket Name"},
+				Validate: survey.Required,
+			},
+			{
+				Name:     "target",
+				Prompt:   &survey.Input{Message: "Target Bucket Name"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "prefix",
+				Prompt: &survey.Input{Message: "Prefix(leave blank if no prefix)"},
+			},
+			{
+				Name:     "profile",
+				Prompt:   &survey.Input{Message: "AWS Profile"},
+				Validate: survey.Required,
+			},
+			objectListQuestion(),
+			{
+				Name:   "acl",
+				Prompt: &survey.Confirm{Message: "Compare ACLs of every object"},
+			},
+			{
+				Name:   "report",
+				Prompt: &survey.Input{Message: "Report File", Default: "../verification_report.txt"},
+			},
+			{
+				Name:   "fixup",
+				Prompt: &survey.Confirm{Message: "Migrate discrepancies after verification"},
+			},
 		}
-	}
-EXIT:
-	rpcClose(clients)
-}
-
-func rpcConnect(clients []*rpc.Client) error {
-	for i, addr := range pkg.GConfig.Workers {
-		cli, err := rpc.Dial("tcp", addr+":"+strconv.Itoa(pkg.GConfig.WorkerPort))
+		answers := struct {
+			Source  string
+			Target  string
+			Profile string
+			Prefix  string
+			Acl     bool
+			Report  string
+			Fixup   bool
+			Objects string
+		}{}
+		err = survey.Ask(qs, &answers)
 		if err != nil {
-			return err
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
 		}
-		clients[i] = cli
-		pkg.GLogger.Info("successfully connected with %v:%v", addr, pkg.GConfig.WorkerPort)
-	}
-	return nil
-}
-
-func rpcClose(clients []*rpc.Client) {
-	for i, client := range clients {
-		client.Close()
-		clients[i] = nil
-		pkg.GLogger.Info("successfully closed connection %v:%v", pkg.GConfig.Workers[i], pkg.GConfig.WorkerPort)
-	}
-}
-
-// Data migration job. Copy the whole bucket to the destination with acls preserved
-func RunMigrationJob(from string, to string, prefix string, clients []*rpc.Client, profile string) error {
-	// create s3 manager
-	manager, err := pkg.NewS3Manager("us-west-2", profile)
-	key, pwd := manager.GetCredential()
-	if key == "" || pwd == "" {
-		return errors.New("aws profile " + profile + " does not exist")
-	}
-	if err != nil {
-		return err
-	}
-	if !manager.BucketExists(from) {
-		return errors.New(from + " doesn't exist")
-	}
-	if !manager.BucketExists(to) {
-		return errors.New(to + " doesn't exist")
-	}
-	region1, err := manager.GetBucketRegion(from)
-	region2, err := manager.GetBucketRegion(to)
-	manager, err = pkg.NewS3Manager(region1, profile)
-	if err != nil {
-		return err
-	}
-
-	s3InfoReq := &pkg.S3InfoRequest{
-		Profile:   profile,
-		Region1:   region1,
-		Region2:   region2,
-		AwsKey:    key,
-		AwsSecret: pwd,
-	}
-	for _, cli := range clients {
-		err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
+		startTime = time.Now()
+		err = RunVerificationJob(answers.Source, answers.Target, answers.Prefix, clients, answers.Profile, answers.Acl, answers.Report, answers.Fixup, answers.Objects)
 		if err != nil {
-			return err
+			pkg.GLogger.Error("Exception in running task [S3 Bucket Verification], reason: %v", err)
+			return
 		}
-		cli.Call("RpcHandler.StartMigraJob", "", nil)
-	}
-	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data migration job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
-	buffers := make([][]*pkg.MigrationRequest, len(pkg.GConfig.Workers))
-	err = manager.HandleFiles(from, prefix, func(file *pkg.S3File) error {
-		idx := file.Id % int64(len(pkg.GConfig.Workers))
-		req := &pkg.MigrationRequest{
-			File:         file,
-			SourceBucket: from,
-			DestBucket:   to,
-			DestFileName: file.Name,
+		break
+	case "S3 Bucket Archive":
+		var qs = []*survey.Question{
+			{
+				Name:     "bucket",
+				Prompt:   &survey.Input{Message: "Bucket Name"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "prefix",
+				Prompt: &survey.Input{Message: "Prefix(leave blank if no prefix)"},
+			},
+			{
+				Name:     "profile",
+				Prompt:   &survey.Input{Message: "AWS Profile"},
+				Validate: survey.Required,
+			},
+			objectListQuestion(),
+			{
+				Name: "class",
+				Prompt: &survey.Select{
+					Message: "Archive Storage Class",
+					Options: []string{"DEEP_ARCHIVE", "GLACIER", "GLACIER_IR"},
+				},
+			},
+			{
+				Name: "minsize",
+				Prompt: &survey.Input{
+					Message: "Minimum Object Size",
+					Default: "128KB",
+					Help:    "Archived objects carry a per object overhead, smaller objects cost more archived than they save",
+				},
+			},
+			{
+				Name:   "olderthan",
+				Prompt: &survey.Input{Message: "Only Objects Older Than(days)", Default: "0"},
+			},
+			{
+				Name:   "pattern",
+				Prompt: &survey.Input{Message: "Key Pattern(regular expression, leave blank for all keys)"},
+			},
+			{
+				Name:   "manifest",
+				Prompt: &survey.Input{Message: "Manifest File", Default: "../archive_manifest.csv"},
+			},
+			{
+				Name:   "dryrun",
+				Prompt: &survey.Confirm{Message: "Dry Run"},
+			},
 		}
-		buffers[idx] = append(buffers[idx], req)
-		if len(buffers[idx]) >= 1000 {
-			clients[idx].Call("RpcHandler.HandleMigration", buffers[idx], nil)
-			pkg.GLogger.Debug("[Migration Job] sent %v migration requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
-			buffers[idx] = nil
+		answers := struct {
+			Bucket    string
+			Prefix    string
+			Profile   string
+			Class     string
+			MinSize   string
+			OlderThan int64
+			Pattern   string
+			Manifest  string
+			Objects   string
+			DryRun    bool
+		}{}
+		err = survey.Ask(qs, &answers)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
 		}
-		return nil
-	})
-	if err != nil {
-		for i := 0; i < len(pkg.GConfig.Workers); i++ {
-			buffers[i] = append(buffers[i], &pkg.MigrationRequest{Finished: true})
-			clients[i].Call("RpcHandler.HandleMigration", buffers[i], nil)
+		options := &ArchiveOptions{
+			StorageClass: answers.Class,
+			Filter: &pkg.FileFilter{
+				OlderThan:             time.Duration(answers.OlderThan) * 24 * time.Hour,
+				ExcludeStorageClasses: []string{"GLACIER", "DEEP_ARCHIVE", answers.Class},
+			},
+			ManifestPath: answers.Manifest,
+			ObjectList:   answers.Objects,
+			DryRun:       answers.DryRun,
 		}
-		return err
-	}
-	for i := 0; i < len(pkg.GConfig.Workers); i++ {
-		buffers[i] = append(buffers[i], &pkg.MigrationRequest{Finished: true})
-		clients[i].Call("RpcHandler.HandleMigration", buffers[i], nil)
-		pkg.GLogger.Debug("[Migration Job] sent %v migration requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
-		buffers[i] = nil
-	}
-	return nil
-}
-
-func RunRestorationJob(bucket string, prefix string, clients []*rpc.Client, profile string, days int64, speed string) error {
-	// create s3 manager
-	manager, err := pkg.NewS3Manager("us-west-2", profile)
-	if err != nil {
-		return err
-	}
-	key, pwd := manager.GetCredential()
-	if key == "" || pwd == "" {
-		return errors.New("aws profile " + profile + " does not exist")
-	}
-	if !manager.BucketExists(bucket) {
-		return errors.New(bucket + " doesn't exist")
-	}
-	region, err := manager.GetBucketRegion(bucket)
-	if err != nil {
-		return err
-	}
-	if region != "us-west-2" {
-		manager, err = pkg.NewS3Manager(region, profile)
+		options.MinSize, err = pkg.ParseSize(answers.MinSize)
 		if err != nil {
-			return err
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
 		}
-	}
-	s3InfoReq := &pkg.S3InfoRequest{
-		Profile:   profile,
-		Region1:   region,
-		AwsKey:    key,
-		AwsSecret: pwd,
-	}
-	for _, cli := range clients {
-		err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
-		if err != nil {
-			return err
+		if answers.Pattern != "" {
+			options.Filter.KeyPattern, err = regexp.Compile(answers.Pattern)
+			if err != nil {
+				pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+				return
+			}
 		}
-		cli.Call("RpcHandler.StartRestorationJob", "", nil)
-	}
-	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data restoration job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
-	buffers := make([][]*pkg.RestorationRequest, len(pkg.GConfig.Workers))
-	err = manager.HandleFiles(bucket, prefix, func(file *pkg.S3File) error {
-		idx := file.Id % int64(len(pkg.GConfig.Workers))
-		req := &pkg.RestorationRequest{
-			File:   file,
-			Bucket: bucket,
-			Days:   days,
-			Speed:  speed,
+		startTime = time.Now()
+		err = RunArchiveJob(answers.Bucket, answers.Prefix, clients, answers.Profile, options)
+		if err != nil {
+			pkg.GLogger.Error("Exception in running task [S3 Bucket Archive], reason: %v", err)
+			return
 		}
-		buffers[idx] = append(buffers[idx], req)
-		if len(buffers[idx]) >= 1000 {
-			clients[idx].Call("RpcHandler.HandleRestoration", buffers[idx], nil)
-			pkg.GLogger.Debug("[Restoration Job] sent %v restoration requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
-			buffers[idx] = nil
+		if options.DryRun {
+			rpcClose(clients)
+			return
 		}
-		return nil
+		break
+	case "S3 Bucket Undelete":
+		var qs = []*survey.Question{
+			{
+				Name:     "bucket",
+				Prompt:   &survey.Input{Message: "Bucket Name"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "prefix",
+				Prompt: &survey.Input{Message: "Prefix(leave blank if no prefix)"},
+			},
+			{
+				Name:     "profile",
+				Prompt:   &survey.Input{Message: "AWS Profile"},
+				Validate: survey.Required,
+			},
+			objectListQuestion(),
+			{
+				Name:   "after",
+				Prompt: &survey.Input{Message: "Deleted After(UTC, e.g. 2019-09-01 12:00, leave blank for no limit)"},
+			},
+			{
+				Name:   "before",
+				Prompt: &survey.Input{Message: "Deleted Before(UTC, e.g. 2019-09-02 12:00, leave blank for no limit)"},
+			},
+			{
+				Name:   "dryrun",
+				Prompt: &survey.Confirm{Message: "Dry Run"},
+			},
+		}
+		answers := struct {
+			Bucket  string
+			Prefix  string
+			Profile string
+			After   string
+			Before  string
+			DryRun  bool
+			Objects string
+		}{}
+		err = survey.Ask(qs, &answers)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		after, err := pkg.ParseTime(answers.After)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		before, err := pkg.ParseTime(answers.Before)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		startTime = time.Now()
+		err = RunUndeleteJob(answers.Bucket, answers.Prefix, clients, answers.Profile, after, before, answers.DryRun, answers.Objects)
+		if err != nil {
+			pkg.GLogger.Error("Exception in running task [S3 Bucket Undelete], reason: %v", err)
+			return
+		}
+		if answers.DryRun {
+			rpcClose(clients)
+			return
+		}
+		break
+	case "S3 Bucket Rollback":
+		var qs = []*survey.Question{
+			{
+				Name:     "bucket",
+				Prompt:   &survey.Input{Message: "Bucket Name"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "prefix",
+				Prompt: &survey.Input{Message: "Prefix(leave blank if no prefix)"},
+			},
+			{
+				Name:     "profile",
+				Prompt:   &survey.Input{Message: "AWS Profile"},
+				Validate: survey.Required,
+			},
+			objectListQuestion(),
+			{
+				Name:     "at",
+				Prompt:   &survey.Input{Message: "Roll Back To(UTC, e.g. 2019-09-01 12:00)"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "days",
+				Prompt: &survey.Input{Message: "How many days archived versions are restored", Default: "7"},
+			},
+			{
+				Name:   "speed",
+				Prompt: &survey.Input{Message: "Restoration Speed(Bulk, Standard, Expedited)", Default: "Standard"},
+			},
+			{
+				Name:   "dryrun",
+				Prompt: &survey.Confirm{Message: "Dry Run"},
+			},
+		}
+		answers := struct {
+			Bucket  string
+			Prefix  string
+			Profile string
+			At      string
+			Days    int64
+			Speed   string
+			DryRun  bool
+			Objects string
+		}{}
+		err = survey.Ask(qs, &answers)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		at, err := pkg.ParseTime(answers.At)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		startTime = time.Now()
+		err = RunRollbackJob(answers.Bucket, answers.Prefix, clients, answers.Profile, at, answers.Days, answers.Speed, answers.DryRun, answers.Objects)
+		if err != nil {
+			pkg.GLogger.Error("Exception in running task [S3 Bucket Rollback], reason: %v", err)
+			return
+		}
+		if answers.DryRun {
+			rpcClose(clients)
+			return
+		}
+		break
+	case "S3 Bucket Scan":
+		var qs = []*survey.Question{
+			{
+				Name:     "bucket",
+				Prompt:   &survey.Input{Message: "Bucket Name"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "prefix",
+				Prompt: &survey.Input{Message: "Prefix(leave blank if no prefix)"},
+			},
+			{
+				Name:     "profile",
+				Prompt:   &survey.Input{Message: "AWS Profile"},
+				Validate: survey.Required,
+			},
+			objectListQuestion(),
+			{
+				Name:   "report",
+				Prompt: &survey.Input{Message: "Report File(.json or .csv)", Default: "../scan_report.json"},
+			},
+		}
+		answers := struct {
+			Bucket  string
+			Prefix  string
+			Profile string
+			Objects string
+			Report  string
+		}{}
+		err = survey.Ask(qs, &answers)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		startTime = time.Now()
+		err = RunScanJob(answers.Bucket, answers.Prefix, clients, answers.Profile, answers.Report, answers.Objects)
+		if err != nil {
+			pkg.GLogger.Error("Exception in running task [S3 Bucket Scan], reason: %v", err)
+			return
+		}
+		break
+	case "S3 Restore Monitor":
+		var qs = []*survey.Question{
+			{
+				Name:     "bucket",
+				Prompt:   &survey.Input{Message: "Bucket Name"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "prefix",
+				Prompt: &survey.Input{Message: "Prefix(leave blank if no prefix)"},
+			},
+			{
+				Name:     "profile",
+				Prompt:   &survey.Input{Message: "AWS Profile"},
+				Validate: survey.Required,
+			},
+			objectListQuestion(),
+			{
+				Name:     "threshold",
+				Prompt:   &survey.Input{Message: "Warn about restored copies expiring within how many days", Default: "2"},
+				Validate: survey.Required,
+			},
+			{
+				Name: "action",
+				Prompt: &survey.Select{
+					Message: "Action on Expiring Copies",
+					Options: []string{pkg.MonitorReport, pkg.MonitorExtend, pkg.MonitorRecover},
+					Help:    "report: only warn, extend: restore them again, recover: copy them out of the archive like S3 Bucket Recovery",
+				},
+			},
+			{
+				Name:   "days",
+				Prompt: &survey.Input{Message: "Days extended copies are kept from now(only used to extend)", Default: "7"},
+			},
+		}
+		answers := struct {
+			Bucket    string
+			Prefix    string
+			Profile   string
+			Objects   string
+			Threshold int64
+			Action    string
+			Days      int64
+		}{}
+		err = survey.Ask(qs, &answers)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		// recovered copies are written like S3 Bucket Recovery writes them
+		var (
+			storageClass *pkg.StorageClassPolicy
+			metadata     *pkg.MetadataOptions
+			encryption   *pkg.EncryptionOptions
+		)
+		if answers.Action == pkg.MonitorRecover {
+			storageClass, metadata, encryption, err = askCopyOptions()
+			if err != nil {
+				pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+				return
+			}
+		}
+		startTime = time.Now()
+		err = RunRestoreMonitorJob(answers.Bucket, answers.Prefix, clients, answers.Profile, answers.Threshold, answers.Action, answers.Days, storageClass, metadata, encryption, answers.Objects)
+		if err != nil {
+			pkg.GLogger.Error("Exception in running task [S3 Restore Monitor], reason: %v", err)
+			return
+		}
+		break
+	case "S3 Bucket Export":
+		var qs = []*survey.Question{
+			{
+				Name:     "bucket",
+				Prompt:   &survey.Input{Message: "Bucket Name"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "prefix",
+				Prompt: &survey.Input{Message: "Prefix(leave blank if no prefix)"},
+			},
+			{
+				Name:     "profile",
+				Prompt:   &survey.Input{Message: "AWS Profile"},
+				Validate: survey.Required,
+			},
+			objectListQuestion(),
+			{
+				Name: "format",
+				Prompt: &survey.Select{
+					Message: "Format",
+					Options: []string{pkg.ExportCSV, pkg.ExportJSONL, pkg.ExportParquet},
+					Help:    "CSV and JSONL files are key lists, Parquet files are for analytics tools",
+				},
+			},
+			{
+				Name:   "details",
+				Prompt: &survey.Confirm{Message: "Look up restore status, encryption, content type and metadata of every object"},
+			},
+			{
+				Name:   "chunk",
+				Prompt: &survey.Input{Message: "Objects per File(0 for a single file)", Default: "1000000"},
+			},
+			{
+				Name: "destination",
+				Prompt: &survey.Input{
+					Message: "Destination(local path or s3://bucket/prefix)",
+					Default: "../export/listing",
+					Help:    "Files are named after the destination, e.g. listing-00000.csv",
+				},
+			},
+		}
+		answers := struct {
+			Bucket      string
+			Prefix      string
+			Profile     string
+			Objects     string
+			Format      string
+			Details     bool
+			Chunk       int64
+			Destination string
+		}{}
+		err = survey.Ask(qs, &answers)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		options := &pkg.ExportOptions{
+			Format:      answers.Format,
+			Details:     answers.Details,
+			ChunkSize:   answers.Chunk,
+			Destination: answers.Destination,
+		}
+		startTime = time.Now()
+		err = RunExportJob(answers.Bucket, answers.Prefix, answers.Profile, options, answers.Objects)
+		if err != nil {
+			pkg.GLogger.Error("Exception in running task [S3 Bucket Export], reason: %v", err)
+			return
+		}
+		// exports are written by master, workers have no job
+		pkg.GLogger.Info("Task finished. Time spent: %v hours.", time.Since(startTime).Hours())
+		rpcClose(clients)
+		return
+	case "S3 Bucket Diff":
+		var qs = []*survey.Question{
+			{
+				Name:     "source",
+				Prompt:   &survey.Input{Message: "Bucket A"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "sourceprefix",
+				Prompt: &survey.Input{Message: "Prefix of A(leave blank if no prefix)"},
+			},
+			{
+				Name:     "target",
+				Prompt:   &survey.Input{Message: "Bucket B"},
+				Validate: survey.Required,
+			},
+			{
+				Name:   "targetprefix",
+				Prompt: &survey.Input{Message: "Prefix of B(leave blank if no prefix)"},
+			},
+			{
+				Name:     "profile",
+				Prompt:   &survey.Input{Message: "AWS Profile"},
+				Validate: survey.Required,
+			},
+			{
+				Name: "targetprofile",
+				Prompt: &survey.Input{
+					Message: "AWS Profile of B(leave blank if same account)",
+					Help:    "Profile of the account owning bucket B, e.g. a profile assuming a role in that account",
+				},
+			},
+			{
+				Name: "objects",
+				Prompt: &survey.Input{
+					Message: "Key List(leave blank to list the buckets)",
+					Help:    "Key lists are .csv files with a header or .jsonl files with bucket, key, version_id, size and storage_class, only key is required. Keys are keys of A",
+				},
+			},
+			{
+				Name:   "metadata",
+				Prompt: &survey.Confirm{Message: "Compare content type, headers and metadata of every object"},
+			},
+			{
+				Name:   "report",
+				Prompt: &survey.Input{Message: "Report File", Default: "../diff_report.csv"},
+			},
+		}
+		answers := struct {
+			Source        string
+			SourcePrefix  string
+			Target        string
+			TargetPrefix  string
+			Profile       string
+			TargetProfile string
+			Objects       string
+			Metadata      bool
+			Report        string
+		}{}
+		err = survey.Ask(qs, &answers)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		startTime = time.Now()
+		err = RunDiffJob(answers.Source, answers.SourcePrefix, answers.Target, answers.TargetPrefix, answers.Profile, answers.TargetProfile, answers.Metadata, answers.Report, answers.Objects)
+		if err != nil {
+			pkg.GLogger.Error("Exception in running task [S3 Bucket Diff], reason: %v", err)
+			return
+		}
+		// diffs are made by master, workers have no job
+		pkg.GLogger.Info("Task finished. Time spent: %v hours.", time.Since(startTime).Hours())
+		rpcClose(clients)
+		return
+	case "S3 Lifecycle Check":
+		var qs = []*survey.Question{
+			{
+				Name:     "bucket",
+				Prompt:   &survey.Input{Message: "Bucket Name"},
+				Validate: survey.Required,
+			},
+			{
+				Name:     "profile",
+				Prompt:   &survey.Input{Message: "AWS Profile"},
+				Validate: survey.Required,
+			},
+			{
+				Name: "rules",
+				Prompt: &survey.Input{
+					Message: "Lifecycle Configuration File(leave blank to check the bucket's rules)",
+					Help:    "JSON like aws s3api get-bucket-lifecycle-configuration prints it, e.g. a configuration about to be applied",
+				},
+			},
+			objectListQuestion(),
+		}
+		answers := struct {
+			Bucket  string
+			Profile string
+			Rules   string
+			Objects string
+		}{}
+		err = survey.Ask(qs, &answers)
+		if err != nil {
+			pkg.GLogger.Error("Exception in configuration, reason: %v", err)
+			return
+		}
+		startTime = time.Now()
+		err = RunLifecycleCheck(answers.Bucket, answers.Profile, answers.Rules, answers.Objects)
+		if err != nil {
+			pkg.GLogger.Error("Exception in running task [S3 Lifecycle Check], reason: %v", err)
+			return
+		}
+		// checks are made by master, workers have no job
+		pkg.GLogger.Info("Task finished. Time spent: %v hours.", time.Since(startTime).Hours())
+		rpcClose(clients)
+		return
+	}
+
+	waitForTask(clients)
+	summary := collectSummary(clients)
+	pkg.GLogger.Info("Task finished. Time spent: %v hours. processed=%v, skipped=%v, failed=%v", time.Since(startTime).Hours(), summary.Processed, summary.Skipped, summary.Failed)
+	rpcClose(clients)
+}
+
+// Question for the objects of a job, answered with a key list, an S3 Inventory manifest or blank
+func objectListQuestion() *survey.Question {
+	return &survey.Question{
+		Name: "objects",
+		Prompt: &survey.Input{
+			Message: "Object List(key list or S3 Inventory manifest.json, leave blank to list the bucket)",
+			Help:    "Key lists are .csv files with a header or .jsonl files with bucket, key, version_id, size and storage_class, only key is required. Inventory manifests may be on S3 as s3://bucket/key",
+		},
+	}
+}
+
+// Ask for the storage class, metadata and encryption of the copies of migration and recovery
+func askCopyOptions() (*pkg.StorageClassPolicy, *pkg.MetadataOptions, *pkg.EncryptionOptions, error) {
+	var qs = []*survey.Question{
+		{
+			Name: "class",
+			Prompt: &survey.Select{
+				Message: "Target Storage Class",
+				Options: pkg.StorageClasses,
+			},
+		},
+		{
+			Name: "classrules",
+			Prompt: &survey.Input{
+				Message: "Storage Class Rules(e.g. 128KB:STANDARD_IA, leave blank if no rules)",
+				Help:    "Objects of at least the given size are written with the given class, the largest matching size wins",
+			},
+		},
+		{
+			Name:   "metadata",
+			Prompt: &survey.Input{Message: "Add or Override User Metadata(key=value, ..., leave blank to keep)"},
+		},
+		{
+			Name:   "headers",
+			Prompt: &survey.Input{Message: "Override Content Headers(e.g. Content-Type=text/plain, leave blank to keep)"},
+		},
+		{
+			Name:   "tags",
+			Prompt: &survey.Input{Message: "Add or Override Tags(key=value, ..., leave blank to keep)"},
+		},
+		{
+			Name: "encryption",
+			Prompt: &survey.Select{
+				Message: "Server Side Encryption",
+				Options: []string{"Bucket Default", pkg.EncryptionPreserve, pkg.EncryptionSSES3, pkg.EncryptionSSEKMS, pkg.EncryptionSSEC},
+			},
+		},
+		{
+			Name:   "kmskey",
+			Prompt: &survey.Input{Message: "KMS Key Id(SSE-KMS only, leave blank for the aws managed key)"},
+		},
+		{
+			Name:   "sourcekey",
+			Prompt: &survey.Input{Message: "Source SSE-C Key(base64, leave blank if sources are not SSE-C encrypted)"},
+		},
+		{
+			Name:   "targetkey",
+			Prompt: &survey.Input{Message: "Target SSE-C Key(base64, SSE-C only)"},
+		},
+	}
+	answers := struct {
+		Class      string
+		ClassRules string
+		Metadata   string
+		Headers    string
+		Tags       string
+		Encryption string
+		KmsKey     string
+		SourceKey  string
+		TargetKey  string
+	}{}
+	err := survey.Ask(qs, &answers)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	storageClass, err := pkg.NewStorageClassPolicy(answers.Class, answers.ClassRules)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot parse storage class rules, reason: %v", err)
+	}
+	metadata, err := pkg.NewMetadataOptions(answers.Metadata, answers.Headers, answers.Tags)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot parse metadata, reason: %v", err)
+	}
+	// "Bucket Default" sets no encryption
+	mode := answers.Encryption
+	if mode == "Bucket Default" {
+		mode = ""
+	}
+	encryption, err := pkg.NewEncryptionOptions(mode, answers.KmsKey, answers.SourceKey, answers.TargetKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot configure encryption, reason: %v", err)
+	}
+	return storageClass, metadata, encryption, nil
+}
+
+// sum up the counters of the last job on all workers
+func collectSummary(clients []*rpc.Client) *pkg.JobSummary {
+	total := &pkg.JobSummary{}
+	for i, cli := range clients {
+		summary := &pkg.JobSummary{}
+		err := cli.Call("RpcHandler.HandleJobSummary", "", summary)
+		if err != nil {
+			pkg.GLogger.Warning("Exception in collecting job summary from %v, reason: %v", pkg.GConfig.Workers[i], err)
+			continue
+		}
+		total.Processed += summary.Processed
+		total.Skipped += summary.Skipped
+		total.Failed += summary.Failed
+	}
+	return total
+}
+
+// blocking function, returns when all workers have finished their current job
+func waitForTask(clients []*rpc.Client) {
+	timer := time.NewTimer(10 * time.Second)
+	for {
+		select {
+		case <-timer.C:
+			if tasksFinished(clients) {
+				return
+			}
+			timer.Reset(10 * time.Second)
+		}
+	}
+}
+
+// whether all workers have finished their current job
+func tasksFinished(clients []*rpc.Client) bool {
+	num := 0
+	for _, cli := range clients {
+		res := false
+		cli.Call("RpcHandler.HandleTaskStatus", "", &res)
+		if res {
+			num++
+		}
+	}
+	return num == len(clients)
+}
+
+// Blocking function like waitForTask, calls drain every 10 seconds while workers are busy and once
+// they have finished, so results are collected as workers produce them
+func drainUntilFinished(clients []*rpc.Client, drain func() error) error {
+	for {
+		finished := tasksFinished(clients)
+		if err := drain(); err != nil {
+			return err
+		}
+		if finished {
+			return nil
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// collect the discrepancies workers found since they were last asked
+func drainVerificationReports(clients []*rpc.Client, write func(d *pkg.Discrepancy) error) error {
+	for i, cli := range clients {
+		var res []*pkg.Discrepancy
+		err := cli.Call("RpcHandler.HandleVerificationReport", "", &res)
+		if err != nil {
+			return err
+		}
+		pkg.GLogger.Debug("[Verification Job] received %v discrepancies from %v", len(res), pkg.GConfig.Workers[i])
+		for _, d := range res {
+			if err := write(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// List the files of bucket under prefix, or the files named by a key list or an S3 Inventory.
+// Inventories save listing huge buckets and already report size and storage class of every object.
+// Buckets are listed in parallel ranges if listing_parallelism is configured, by clients if
+// listing_on_workers is set and they have received the job's S3 info
+func listFiles(manager *pkg.S3Manager, bucket string, prefix string, objectList string, clients []*rpc.Client, handler func(file *pkg.S3File) error) error {
+	if objectList == "" {
+		if pkg.GConfig.ListingParallelism <= 1 {
+			return manager.HandleFilesFrom(bucket, prefix, &pkg.ListOptions{OnPage: func(position *pkg.ListPosition) {
+				if position.Page%100 == 0 {
+					pkg.GLogger.Info("listed %v files of %v, last key %v", position.Files, bucket, position.LastKey)
+				}
+			}}, handler)
+		}
+		var lister pkg.PageLister
+		if pkg.GConfig.ListingOnWorkers && len(clients) > 0 {
+			lister = workerLister(clients, manager)
+		}
+		return manager.HandleFilesParallel(bucket, prefix, pkg.GConfig.ListingParallelism, lister, handler)
+	}
+	pkg.GLogger.Info("reading objects of %v from %v", bucket, objectList)
+	if pkg.IsKeyList(objectList) {
+		return manager.HandleKeyList(objectList, bucket, prefix, handler)
+	}
+	return manager.HandleInventory(objectList, bucket, prefix, handler)
+}
+
+// Ranges listed by the clients in turn, pages a client fails to list are listed by manager
+func workerLister(clients []*rpc.Client, manager *pkg.S3Manager) pkg.PageLister {
+	var next uint32
+	return func(bucket string, r *pkg.ListRange) ([]*pkg.S3File, bool, error) {
+		cli := clients[int(atomic.AddUint32(&next, 1))%len(clients)]
+		res := &pkg.ListRangeResponse{}
+		err := cli.Call("RpcHandler.HandleListRange", &pkg.ListRangeRequest{Bucket: bucket, Range: *r}, res)
+		if err != nil {
+			pkg.GLogger.Warning("Exception in listing %v after %v on a worker, reason: %v", bucket+"/"+r.Prefix, r.After, err)
+			return manager.ListRangePage(bucket, r)
+		}
+		return res.Files, res.More, nil
+	}
+}
+
+// List the version history of every file of bucket under prefix, or of the files of a key list
+func listVersions(manager *pkg.S3Manager, bucket string, prefix string, objectList string, handler func(history []*pkg.S3File) error) error {
+	if objectList == "" {
+		return manager.HandleFileVersions(bucket, prefix, handler)
+	}
+	if !pkg.IsKeyList(objectList) {
+		return errors.New("S3 Inventory reports cannot be used to list versions, use a key list")
+	}
+	pkg.GLogger.Info("reading objects of %v from %v", bucket, objectList)
+	return manager.HandleKeyListVersions(objectList, bucket, prefix, handler)
+}
+
+// Compare files of from under fromPrefix with files of to under toPrefix, or only the files named
+// by a key list. Listings are parallel if listing_parallelism is configured
+func compareBuckets(source *pkg.S3Manager, from string, fromPrefix string, dest *pkg.S3Manager, to string, toPrefix string, objectList string, handler func(src *pkg.S3File, dst *pkg.S3File) error) error {
+	if objectList == "" {
+		return pkg.CompareBuckets(source, from, fromPrefix, dest, to, toPrefix, pkg.GConfig.ListingParallelism, handler)
+	}
+	if !pkg.IsKeyList(objectList) {
+		return errors.New("S3 Inventory reports cannot be used to compare buckets, use a key list")
+	}
+	pkg.GLogger.Info("reading objects of %v from %v", from, objectList)
+	return pkg.CompareKeyList(objectList, source, from, fromPrefix, dest, to, toPrefix, handler)
+}
+
+// Push credentials to the workers before they expire until rpcClose ends the job. destManager is nil
+// if the job has a single account. Credentials needing an mfa token code are not refreshed, nobody
+// answers the prompt while the job runs
+func refreshCredentials(clients []*rpc.Client, manager *pkg.S3Manager, destManager *pkg.S3Manager) {
+	for _, m := range []*pkg.S3Manager{manager, destManager} {
+		if m != nil && m.NeedsMFA() {
+			pkg.GLogger.Warning("Credentials need an mfa token code and are not refreshed, the job must finish within %v", pkg.AssumeRoleDuration)
+		}
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-jobDone:
+			return
+		case <-ticker.C:
+		}
+		req := &pkg.CredentialsRequest{}
+		cred, ok, err := manager.RefreshCredential(10 * time.Minute)
+		if err != nil {
+			pkg.GLogger.Error("Exception in refreshing credentials, reason: %v", err)
+		} else if ok {
+			req.AwsKey, req.AwsSecret, req.AwsToken = cred.AccessKeyID, cred.SecretAccessKey, cred.SessionToken
+		}
+		if destManager != nil {
+			cred, ok, err = destManager.RefreshCredential(10 * time.Minute)
+			if err != nil {
+				pkg.GLogger.Error("Exception in refreshing target credentials, reason: %v", err)
+			} else if ok {
+				req.AwsKey2, req.AwsSecret2, req.AwsToken2 = cred.AccessKeyID, cred.SecretAccessKey, cred.SessionToken
+			}
+		}
+		if req.AwsKey == "" && req.AwsKey2 == "" {
+			continue
+		}
+		if !sendCredentials(clients, req) {
+			return
+		}
+		pkg.GLogger.Info("credentials refreshed")
+	}
+}
+
+// send credentials to all workers, false if the connections are already closed
+func sendCredentials(clients []*rpc.Client, req *pkg.CredentialsRequest) bool {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	select {
+	case <-jobDone:
+		return false
+	default:
+	}
+	for i, cli := range clients {
+		err := cli.Call("RpcHandler.HandleCredentials", req, nil)
+		if err != nil {
+			pkg.GLogger.Warning("Exception in sending credentials to %v, reason: %v", pkg.GConfig.Workers[i], err)
+		}
+	}
+	return true
+}
+
+func rpcConnect(clients []*rpc.Client) error {
+	for i, addr := range pkg.GConfig.Workers {
+		cli, err := rpc.Dial("tcp", addr+":"+strconv.Itoa(pkg.GConfig.WorkerPort))
+		if err != nil {
+			return err
+		}
+		clients[i] = cli
+		pkg.GLogger.Info("successfully connected with %v:%v", addr, pkg.GConfig.WorkerPort)
+	}
+	return nil
+}
+
+// close the connections once the job is finished, which stops refreshCredentials
+func rpcClose(clients []*rpc.Client) {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	close(jobDone)
+	for i, client := range clients {
+		client.Close()
+		clients[i] = nil
+		pkg.GLogger.Info("successfully closed connection %v:%v", pkg.GConfig.Workers[i], pkg.GConfig.WorkerPort)
+	}
+}
+
+// options of a migration job
+type MigrationOptions struct {
+	// only copy new or changed objects
+	Sync bool
+	// sync and delete destination objects absent in source
+	Mirror bool
+	// what to do if a destination file exists, see pkg.ConflictPolicies
+	ConflictPolicy string
+	// computes destination keys, nil keeps source keys
+	Rewriter *pkg.KeyRewriter
+	// list and plan the migration without copying anything
+	DryRun bool
+	// storage class of every copy, nil means STANDARD
+	StorageClass *pkg.StorageClassPolicy
+	// changes of metadata and tags, nil keeps them
+	Metadata *pkg.MetadataOptions
+	// copy the full version history of every key in chronological order
+	AllVersions bool
+	// server side encryption of copies, nil leaves it to the bucket default
+	Encryption *pkg.EncryptionOptions
+	// profile of the destination account, the source profile if empty. Files the destination
+	// account isn't allowed to copy are streamed through the workers
+	TargetProfile string
+	// key list or S3 Inventory manifest naming the source objects, empty to list the bucket
+	ObjectList string
+	// migrate policy, cors, lifecycle, tags, versioning, encryption, website and public access
+	// block of the bucket once a diff is confirmed
+	BucketConfig bool
+	// with BucketConfig, also migrate notifications once the objects are copied, so they don't
+	// fire for the copies
+	Notifications bool
+	// region the destination bucket is created in if it doesn't exist, empty to require it
+	CreateRegion string
+}
+
+// Show how the settings of bucket to would change and apply them once confirmed, dest is nil if
+// to doesn't exist yet. Notifications are only compared if asked for, they are returned to be
+// applied once the objects are copied
+func migrateBucketConfig(source *pkg.S3Manager, from string, dest *pkg.S3Manager, to string, notifications bool, dryRun bool) ([]*pkg.BucketSettingDiff, error) {
+	diffs, err := pkg.DiffBucketConfig(source, from, dest, to, notifications)
+	if err != nil {
+		return nil, err
+	}
+	if len(diffs) == 0 {
+		pkg.GLogger.Info("[Migration Job] settings of %v are up to date", to)
+		return nil, nil
+	}
+	pkg.GLogger.Info("[Migration Job] %v settings of %v differ from %v:", len(diffs), to, from)
+	for _, diff := range diffs {
+		fmt.Println(diff)
+	}
+	if dryRun {
+		return nil, nil
+	}
+	apply := false
+	err = survey.AskOne(&survey.Confirm{
+		Message: fmt.Sprintf("Apply %v settings to %v", len(diffs), to),
+		Help:    "Settings are applied before objects are copied, notifications once they are copied",
+	}, &apply)
+	if err != nil {
+		return nil, err
+	}
+	if !apply {
+		pkg.GLogger.Info("[Migration Job] settings of %v are left as they are", to)
+		return nil, nil
+	}
+	var settings, later []*pkg.BucketSettingDiff
+	for _, diff := range diffs {
+		if diff.Name == pkg.NotificationSetting {
+			later = append(later, diff)
+		} else {
+			settings = append(settings, diff)
+		}
+	}
+	return later, pkg.ApplyBucketConfig(dest, to, settings)
+}
+
+// Data migration job. Copy the whole bucket to the destination with acls preserved
+// In sync mode only objects which are new or changed since the last migration are copied,
+// mirror additionally deletes destination objects which no longer exist in source
+func RunMigrationJob(from string, to string, prefix string, clients []*rpc.Client, profile string, options *MigrationOptions) error {
+	if options.Sync && options.Rewriter != nil {
+		return errors.New("sync mode cannot be used with key rewriting")
+	}
+	if options.Sync && options.AllVersions {
+		return errors.New("sync mode cannot be used with version history")
+	}
+	if options.ObjectList != "" && pkg.IsLocal(from) {
+		return errors.New("object lists name objects of a bucket, not files of a directory")
+	}
+	if options.ObjectList != "" && !pkg.IsKeyList(options.ObjectList) && (options.Sync || options.AllVersions) {
+		return errors.New("inventories can only be used to copy current versions, use a key list")
+	}
+	if pkg.IsLocal(from) && pkg.IsLocal(to) {
+		return errors.New("either source or target should be a bucket")
+	}
+	if (pkg.IsLocal(from) || pkg.IsLocal(to)) && options.AllVersions {
+		return errors.New("directories have no version history")
+	}
+	if (pkg.IsLocal(from) || pkg.IsLocal(to)) && options.BucketConfig {
+		return errors.New("directories have no bucket configuration")
+	}
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	cred, err := manager.GetCredential()
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(from) {
+		return errors.New(from + " doesn't exist")
+	}
+	targetProfile := options.TargetProfile
+	if targetProfile == "" {
+		targetProfile = profile
+	}
+	destManager, err := pkg.NewS3Manager("us-west-2", targetProfile, pkg.GConfig.Destination)
+	if err != nil {
+		return err
+	}
+	cred2, err := destManager.GetCredential()
+	if err != nil {
+		return err
+	}
+	// a dry run only tells which bucket it would create
+	missing := !destManager.BucketExists(to)
+	if missing {
+		if options.CreateRegion == "" || pkg.IsLocal(to) {
+			return errors.New(to + " doesn't exist")
+		}
+		if options.DryRun && options.Sync {
+			return errors.New(to + " doesn't exist, every object would be copied, run a dry run without sync")
+		}
+		if options.DryRun {
+			pkg.GLogger.Info("[Migration Job] dry run: create bucket %v in %v", to, options.CreateRegion)
+		} else {
+			creator, err := pkg.NewS3Manager(options.CreateRegion, targetProfile, pkg.GConfig.Destination)
+			if err != nil {
+				return err
+			}
+			if err = creator.CreateBucket(to); err != nil {
+				return err
+			}
+			missing = false
+		}
+	}
+	region1, err := manager.GetBucketRegion(from)
+	if err != nil {
+		return err
+	}
+	region2 := options.CreateRegion
+	if !missing {
+		region2, err = destManager.GetBucketRegion(to)
+		if err != nil {
+			return err
+		}
+	}
+	manager, err = pkg.NewS3Manager(region1, profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	// settings applied once the objects are copied
+	var later []*pkg.BucketSettingDiff
+	var dest *pkg.S3Manager
+	if options.BucketConfig {
+		if !missing {
+			dest, err = pkg.NewS3Manager(region2, targetProfile, pkg.GConfig.Destination)
+			if err != nil {
+				return err
+			}
+		}
+		later, err = migrateBucketConfig(manager, from, dest, to, options.Notifications, options.DryRun)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !options.DryRun {
+		s3InfoReq := &pkg.S3InfoRequest{
+			Profile:    profile,
+			Region1:    region1,
+			Region2:    region2,
+			AwsKey:     cred.AccessKeyID,
+			AwsSecret:  cred.SecretAccessKey,
+			AwsToken:   cred.SessionToken,
+			AwsKey2:    cred2.AccessKeyID,
+			AwsSecret2: cred2.SecretAccessKey,
+			AwsToken2:  cred2.SessionToken,
+			Endpoint1:  pkg.GConfig.Source,
+			Endpoint2:  pkg.GConfig.Destination,
+			Encryption: options.Encryption,
+		}
+		for _, cli := range clients {
+			err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
+			if err != nil {
+				return err
+			}
+			cli.Call("RpcHandler.StartMigraJob", "", nil)
+		}
+		pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data migration job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+		go refreshCredentials(clients, manager, destManager)
+	}
+	buffers := make([][]*pkg.MigrationRequest, len(pkg.GConfig.Workers))
+	// dry run only checks that no two source files are rewritten to the same key
+	planned := make(map[string]string)
+	collisions := 0
+	dispatch := func(req *pkg.MigrationRequest) {
+		if options.DryRun {
+			if req.Delete {
+				pkg.GLogger.Info("[Migration Job] dry run: delete %v", req.DestBucket+"/"+req.DestFileName)
+				return
+			}
+			pkg.GLogger.Info("[Migration Job] dry run: copy %v to %v", req.SourceBucket+"/"+req.File.Name, req.DestBucket+"/"+req.DestFileName)
+			if other, ok := planned[req.DestFileName]; ok {
+				collisions++
+				pkg.GLogger.Warning("[Migration Job] dry run: %v and %v are both copied to %v", other, req.File.Name, req.DestFileName)
+				return
+			}
+			planned[req.DestFileName] = req.File.Name
+			return
+		}
+		idx := req.File.Id % int64(len(pkg.GConfig.Workers))
+		buffers[idx] = append(buffers[idx], req)
+		if len(buffers[idx]) >= 1000 {
+			clients[idx].Call("RpcHandler.HandleMigration", buffers[idx], nil)
+			pkg.GLogger.Debug("[Migration Job] sent %v migration requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
+			buffers[idx] = nil
+		}
+	}
+	if options.Sync {
+		// only copy new or changed objects, and delete objects absent in source if mirror
+		destManager, err = pkg.NewS3Manager(region2, targetProfile, pkg.GConfig.Destination)
+		if err != nil {
+			return err
+		}
+		var copied, deleted, unchanged int
+		err = compareBuckets(manager, from, prefix, destManager, to, prefix, options.ObjectList, func(src *pkg.S3File, dst *pkg.S3File) error {
+			if src == nil {
+				if options.Mirror {
+					deleted++
+					dispatch(&pkg.MigrationRequest{File: dst, DestBucket: to, DestFileName: dst.Name, Delete: true})
+				}
+				return nil
+			}
+			storageClass := options.StorageClass.Resolve(src)
+			if !pkg.NeedsSync(src, dst, storageClass) {
+				unchanged++
+				return nil
+			}
+			copied++
+			dispatch(&pkg.MigrationRequest{
+				File:           src,
+				SourceBucket:   from,
+				DestBucket:     to,
+				DestFileName:   src.Name,
+				ConflictPolicy: options.ConflictPolicy,
+				StorageClass:   storageClass,
+				Metadata:       options.Metadata,
+			})
+			return nil
+		})
+		pkg.GLogger.Info("[Migration Job] sync: %v objects to copy, %v objects to delete, %v objects unchanged", copied, deleted, unchanged)
+	} else if options.AllVersions {
+		err = listVersions(manager, from, prefix, options.ObjectList, func(history []*pkg.S3File) error {
+			latest := history[len(history)-1]
+			dispatch(&pkg.MigrationRequest{
+				File:           latest,
+				SourceBucket:   from,
+				DestBucket:     to,
+				DestFileName:   options.Rewriter.Rewrite(latest),
+				ConflictPolicy: options.ConflictPolicy,
+				StorageClass:   options.StorageClass.Resolve(latest),
+				Metadata:       options.Metadata,
+				Versions:       history,
+			})
+			return nil
+		})
+	} else {
+		// workers only know the buckets of jobs which aren't dry runs
+		listers := clients
+		if options.DryRun {
+			listers = nil
+		}
+		err = listFiles(manager, from, prefix, options.ObjectList, listers, func(file *pkg.S3File) error {
+			dispatch(&pkg.MigrationRequest{
+				File:           file,
+				SourceBucket:   from,
+				DestBucket:     to,
+				DestFileName:   options.Rewriter.Rewrite(file),
+				ConflictPolicy: options.ConflictPolicy,
+				StorageClass:   options.StorageClass.Resolve(file),
+				Metadata:       options.Metadata,
+			})
+			return nil
+		})
+	}
+	if options.DryRun {
+		if err != nil {
+			return err
+		}
+		pkg.GLogger.Info("[Migration Job] dry run finished, %v destination keys, %v collisions", len(planned), collisions)
+		if collisions > 0 {
+			return fmt.Errorf("%v source files are rewritten to keys which are already used", collisions)
+		}
+		return nil
+	}
+	if err != nil {
+		for i := 0; i < len(pkg.GConfig.Workers); i++ {
+			buffers[i] = append(buffers[i], &pkg.MigrationRequest{Finished: true})
+			clients[i].Call("RpcHandler.HandleMigration", buffers[i], nil)
+		}
+		return err
+	}
+	for i := 0; i < len(pkg.GConfig.Workers); i++ {
+		buffers[i] = append(buffers[i], &pkg.MigrationRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleMigration", buffers[i], nil)
+		pkg.GLogger.Debug("[Migration Job] sent %v migration requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
+		buffers[i] = nil
+	}
+	if len(later) > 0 {
+		waitForTask(clients)
+		return pkg.ApplyBucketConfig(dest, to, later)
+	}
+	return nil
+}
+
+// Data restoration job. versions is empty to restore current versions, "all" for every version
+// or the id of the version to restore
+func RunRestorationJob(bucket string, prefix string, clients []*rpc.Client, profile string, days int64, speed string, versions string, objectList string) error {
+	if objectList != "" && !pkg.IsKeyList(objectList) && versions != "" {
+		return errors.New("inventories can only be used to restore current versions, use a key list")
+	}
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	cred, err := manager.GetCredential()
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(bucket) {
+		return errors.New(bucket + " doesn't exist")
+	}
+	region, err := manager.GetBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	if region != "us-west-2" {
+		manager, err = pkg.NewS3Manager(region, profile, pkg.GConfig.Source)
+		if err != nil {
+			return err
+		}
+	}
+	s3InfoReq := &pkg.S3InfoRequest{
+		Profile:   profile,
+		Region1:   region,
+		AwsKey:    cred.AccessKeyID,
+		AwsSecret: cred.SecretAccessKey,
+		AwsToken:  cred.SessionToken,
+		Endpoint1: pkg.GConfig.Source,
+	}
+	for _, cli := range clients {
+		err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
+		if err != nil {
+			return err
+		}
+		cli.Call("RpcHandler.StartRestorationJob", "", nil)
+	}
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data restoration job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+	go refreshCredentials(clients, manager, nil)
+	buffers := make([][]*pkg.RestorationRequest, len(pkg.GConfig.Workers))
+	dispatch := func(file *pkg.S3File) {
+		idx := file.Id % int64(len(pkg.GConfig.Workers))
+		req := &pkg.RestorationRequest{
+			File:   file,
+			Bucket: bucket,
+			Days:   days,
+			Speed:  speed,
+		}
+		buffers[idx] = append(buffers[idx], req)
+		if len(buffers[idx]) >= 1000 {
+			clients[idx].Call("RpcHandler.HandleRestoration", buffers[idx], nil)
+			pkg.GLogger.Debug("[Restoration Job] sent %v restoration requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
+			buffers[idx] = nil
+		}
+	}
+	if versions == "" {
+		err = listFiles(manager, bucket, prefix, objectList, clients, func(file *pkg.S3File) error {
+			dispatch(file)
+			return nil
+		})
+	} else {
+		err = listVersions(manager, bucket, prefix, objectList, func(history []*pkg.S3File) error {
+			for _, file := range history {
+				if !file.IsDeleteMarker && (versions == "all" || versions == file.VersionId) {
+					dispatch(file)
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		for i := 0; i < len(pkg.GConfig.Workers); i++ {
+			buffers[i] = append(buffers[i], &pkg.RestorationRequest{Finished: true})
+			clients[i].Call("RpcHandler.HandleRestoration", buffers[i], nil)
+		}
+		return err
+	}
+	for i := 0; i < len(pkg.GConfig.Workers); i++ {
+		buffers[i] = append(buffers[i], &pkg.RestorationRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleRestoration", buffers[i], nil)
+		pkg.GLogger.Debug("[Restoration Job] sent %v restoration requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
+		buffers[i] = nil
+	}
+	return nil
+}
+
+// Data recovery job. Copy restored files in place with the storage class decided by the policy,
+// metadata and tags are preserved unless changed by metadata. versions is "all" to recover every
+// archived version, or the id of a single version. The history of such a key is replayed from the
+// first version to recover on, deleteReplayed deletes the replayed versions, see pkg.RecoverFileVersions
+func RunRecoveryJob(bucket string, prefix string, clients []*rpc.Client, profile string, storageClass *pkg.StorageClassPolicy, metadata *pkg.MetadataOptions, encryption *pkg.EncryptionOptions, versions string, deleteReplayed bool, objectList string) error {
+	if objectList != "" && !pkg.IsKeyList(objectList) && versions != "" {
+		return errors.New("inventories can only be used to recover current versions, use a key list")
+	}
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	cred, err := manager.GetCredential()
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(bucket) {
+		return errors.New(bucket + " doesn't exist")
+	}
+	region, err := manager.GetBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	if region != "us-west-2" {
+		manager, err = pkg.NewS3Manager(region, profile, pkg.GConfig.Source)
+		if err != nil {
+			return err
+		}
+	}
+	s3InfoReq := &pkg.S3InfoRequest{
+		Profile:    profile,
+		Region1:    region,
+		AwsKey:     cred.AccessKeyID,
+		AwsSecret:  cred.SecretAccessKey,
+		AwsToken:   cred.SessionToken,
+		Endpoint1:  pkg.GConfig.Source,
+		Encryption: encryption,
+	}
+	for _, cli := range clients {
+		err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
+		if err != nil {
+			return err
+		}
+		cli.Call("RpcHandler.StartRecoveryJob", "", nil)
+	}
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data recovery job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+	go refreshCredentials(clients, manager, nil)
+	buffers := make([][]*pkg.RecoveryRequest, len(pkg.GConfig.Workers))
+	dispatch := func(req *pkg.RecoveryRequest) {
+		idx := req.File.Id % int64(len(pkg.GConfig.Workers))
+		buffers[idx] = append(buffers[idx], req)
+		if len(buffers[idx]) >= 1000 {
+			clients[idx].Call("RpcHandler.HandleRecovery", buffers[idx], nil)
+			pkg.GLogger.Debug("[Recovery Job] sent %v recovery requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
+			buffers[idx] = nil
+		}
+	}
+	if versions == "" {
+		err = listFiles(manager, bucket, prefix, objectList, clients, func(file *pkg.S3File) error {
+			dispatch(&pkg.RecoveryRequest{
+				File:         file,
+				Bucket:       bucket,
+				StorageClass: storageClass.Resolve(file),
+				Metadata:     metadata,
+			})
+			return nil
+		})
+	} else {
+		// a noncurrent version cannot change its storage class, the history of the key is copied
+		// again in chronological order from the first version to recover on
+		err = listVersions(manager, bucket, prefix, objectList, func(history []*pkg.S3File) error {
+			from := -1
+			for i, file := range history {
+				if versions == "all" && pkg.IsArchived(file.StorageClass) || versions == file.VersionId && !file.IsDeleteMarker {
+					from = i
+					break
+				}
+			}
+			if from < 0 {
+				return nil
+			}
+			first := history[from]
+			dispatch(&pkg.RecoveryRequest{
+				File:           first,
+				Bucket:         bucket,
+				StorageClass:   storageClass.Resolve(first),
+				Metadata:       metadata,
+				Versions:       history[from:],
+				DeleteReplayed: deleteReplayed,
+			})
+			return nil
+		})
+	}
+	if err != nil {
+		for i := 0; i < len(pkg.GConfig.Workers); i++ {
+			buffers[i] = append(buffers[i], &pkg.RecoveryRequest{Finished: true})
+			clients[i].Call("RpcHandler.HandleRecovery", buffers[i], nil)
+		}
+		return err
+	}
+	for i := 0; i < len(pkg.GConfig.Workers); i++ {
+		buffers[i] = append(buffers[i], &pkg.RecoveryRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleRecovery", buffers[i], nil)
+		pkg.GLogger.Debug("[Recovery Job] sent %v restoration requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
+		buffers[i] = nil
+	}
+	return nil
+}
+
+// Data verification job. Compare the destination of a migration with its source, the report lists
+// missing objects and objects whose size, etag, storage class or acls differ.
+// Listings of both buckets are compared on master, acls are compared by workers
+func RunVerificationJob(from string, to string, prefix string, clients []*rpc.Client, profile string, checkAcl bool, reportPath string, fixup bool, objectList string) error {
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	cred, err := manager.GetCredential()
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(from) {
+		return errors.New(from + " doesn't exist")
+	}
+	destManager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Destination)
+	if err != nil {
+		return err
+	}
+	if !destManager.BucketExists(to) {
+		return errors.New(to + " doesn't exist")
+	}
+	region1, err := manager.GetBucketRegion(from)
+	if err != nil {
+		return err
+	}
+	region2, err := destManager.GetBucketRegion(to)
+	if err != nil {
+		return err
+	}
+	sourceManager, err := pkg.NewS3Manager(region1, profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	destManager, err = pkg.NewS3Manager(region2, profile, pkg.GConfig.Destination)
+	if err != nil {
+		return err
+	}
+	s3InfoReq := &pkg.S3InfoRequest{
+		Profile:   profile,
+		Region1:   region1,
+		Region2:   region2,
+		AwsKey:    cred.AccessKeyID,
+		AwsSecret: cred.SecretAccessKey,
+		AwsToken:  cred.SessionToken,
+		Endpoint1: pkg.GConfig.Source,
+		Endpoint2: pkg.GConfig.Destination,
+	}
+	for _, cli := range clients {
+		err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
+		if err != nil {
+			return err
+		}
+		cli.Call("RpcHandler.StartVerificationJob", "", nil)
+	}
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data verification job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+	go refreshCredentials(clients, manager, nil)
+	// discrepancies are written as they are found, files to fix up are spooled to a temporary file
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	spool, err := ioutil.TempFile("", "crazys3-fixup-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	spoolEncoder := json.NewEncoder(spool)
+	counts := make(map[string]int)
+	total := 0
+	write := func(d *pkg.Discrepancy) error {
+		counts[d.Kind]++
+		total++
+		_, err := fmt.Fprintln(file, d.String())
+		return err
+	}
+	// acl discrepancies of workers, their objects don't differ otherwise
+	writeAcl := func(d *pkg.Discrepancy) error {
+		err := write(d)
+		if err == nil && fixup {
+			err = spoolEncoder.Encode(d.Source)
+		}
+		return err
+	}
+	lastDrain := time.Now()
+	buffers := make([][]*pkg.VerificationRequest, len(pkg.GConfig.Workers))
+	err = compareBuckets(sourceManager, from, prefix, destManager, to, prefix, objectList, func(src *pkg.S3File, dst *pkg.S3File) error {
+		key := ""
+		if src != nil {
+			key = src.Name
+		} else {
+			key = dst.Name
+		}
+		discrepancies := pkg.CompareFiles(key, src, dst)
+		for _, d := range discrepancies {
+			if err := write(d); err != nil {
+				return err
+			}
+		}
+		// a key is fixed up once, whatever differs
+		if fixup && src != nil && len(discrepancies) > 0 {
+			if err := spoolEncoder.Encode(src); err != nil {
+				return err
+			}
+		}
+		// acls of objects which already differ aren't compared, fix-up copies them again anyway
+		if src == nil || dst == nil || !checkAcl || len(discrepancies) > 0 {
+			return nil
+		}
+		idx := src.Id % int64(len(pkg.GConfig.Workers))
+		req := &pkg.VerificationRequest{
+			File:         src,
+			SourceBucket: from,
+			DestBucket:   to,
+			DestFileName: dst.Name,
+		}
+		buffers[idx] = append(buffers[idx], req)
+		if len(buffers[idx]) >= 1000 {
+			clients[idx].Call("RpcHandler.HandleVerification", buffers[idx], nil)
+			pkg.GLogger.Debug("[Verification Job] sent %v verification requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
+			buffers[idx] = nil
+		}
+		if time.Since(lastDrain) > 10*time.Second {
+			lastDrain = time.Now()
+			return drainVerificationReports(clients, writeAcl)
+		}
+		return nil
+	})
+	for i := 0; i < len(pkg.GConfig.Workers); i++ {
+		buffers[i] = append(buffers[i], &pkg.VerificationRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleVerification", buffers[i], nil)
+		pkg.GLogger.Debug("[Verification Job] sent %v verification requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
+		buffers[i] = nil
+	}
+	if err != nil {
+		return err
+	}
+	// acl discrepancies are written as workers find them
+	err = drainUntilFinished(clients, func() error {
+		return drainVerificationReports(clients, writeAcl)
+	})
+	if err != nil {
+		return err
+	}
+	pkg.GLogger.Info("[Verification Job] %v discrepancies written to %v: %v", total, reportPath, counts)
+	if !fixup {
+		return nil
+	}
+
+	// objects which only exist in destination cannot be fixed by a migration
+	for _, cli := range clients {
+		cli.Call("RpcHandler.StartMigraJob", "", nil)
+	}
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> fix-up migration job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+	if _, err := spool.Seek(0, 0); err != nil {
+		return err
+	}
+	migraBuffers := make([][]*pkg.MigrationRequest, len(pkg.GConfig.Workers))
+	decoder := json.NewDecoder(spool)
+	for {
+		source := &pkg.S3File{}
+		if err = decoder.Decode(source); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			break
+		}
+		idx := source.Id % int64(len(pkg.GConfig.Workers))
+		migraBuffers[idx] = append(migraBuffers[idx], &pkg.MigrationRequest{
+			File:         source,
+			SourceBucket: from,
+			DestBucket:   to,
+			DestFileName: source.Name,
+		})
+		if len(migraBuffers[idx]) >= 1000 {
+			clients[idx].Call("RpcHandler.HandleMigration", migraBuffers[idx], nil)
+			pkg.GLogger.Debug("[Migration Job] sent %v migration requests to %v", len(migraBuffers[idx]), pkg.GConfig.Workers[idx])
+			migraBuffers[idx] = nil
+		}
+	}
+	for i := 0; i < len(pkg.GConfig.Workers); i++ {
+		migraBuffers[i] = append(migraBuffers[i], &pkg.MigrationRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleMigration", migraBuffers[i], nil)
+		pkg.GLogger.Debug("[Migration Job] sent %v migration requests to %v", len(migraBuffers[i]), pkg.GConfig.Workers[i])
+		migraBuffers[i] = nil
+	}
+	return err
+}
+
+// options of an archive job
+type ArchiveOptions struct {
+	// GLACIER_IR, GLACIER or DEEP_ARCHIVE
+	StorageClass string
+	// smaller objects are refused
+	MinSize int64
+	Filter  *pkg.FileFilter
+	// csv file listing the archived objects
+	ManifestPath string
+	// list and plan the archive without copying anything
+	DryRun bool
+	// key list or S3 Inventory manifest naming the objects, empty to list the bucket
+	ObjectList string
+}
+
+// Data archive job. Copy objects in place into an archive storage class, the archived objects
+// are written to a manifest so they can be restored later
+func RunArchiveJob(bucket string, prefix string, clients []*rpc.Client, profile string, options *ArchiveOptions) error {
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	cred, err := manager.GetCredential()
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(bucket) {
+		return errors.New(bucket + " doesn't exist")
+	}
+	region, err := manager.GetBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	if region != "us-west-2" {
+		manager, err = pkg.NewS3Manager(region, profile, pkg.GConfig.Source)
+		if err != nil {
+			return err
+		}
+	}
+	if !options.DryRun {
+		s3InfoReq := &pkg.S3InfoRequest{
+			Profile:   profile,
+			Region1:   region,
+			AwsKey:    cred.AccessKeyID,
+			AwsSecret: cred.SecretAccessKey,
+			AwsToken:  cred.SessionToken,
+			Endpoint1: pkg.GConfig.Source,
+		}
+		for _, cli := range clients {
+			err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
+			if err != nil {
+				return err
+			}
+			cli.Call("RpcHandler.StartArchiveJob", "", nil)
+		}
+		pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data archive job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+		go refreshCredentials(clients, manager, nil)
+	}
+	// objects are appended to the manifest as workers archive them, so it survives a failed job
+	var (
+		manifest *os.File
+		writer   *csv.Writer
+		total    int
+	)
+	drainManifest := func() error {
+		for i, cli := range clients {
+			var res []*pkg.ArchiveRequest
+			err := cli.Call("RpcHandler.HandleArchiveManifest", "", &res)
+			if err != nil {
+				return err
+			}
+			pkg.GLogger.Debug("[Archive Job] received %v archived objects from %v", len(res), pkg.GConfig.Workers[i])
+			for _, req := range res {
+				writer.Write([]string{req.Bucket, req.File.Name, strconv.FormatInt(req.File.Size, 10), req.File.StorageClass, req.StorageClass})
+			}
+			total += len(res)
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	if !options.DryRun {
+		manifest, err = os.Create(options.ManifestPath)
+		if err != nil {
+			return err
+		}
+		defer manifest.Close()
+		writer = csv.NewWriter(manifest)
+		writer.Write([]string{"bucket", "key", "size", "previous_storage_class", "storage_class"})
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return err
+		}
+	}
+	lastDrain := time.Now()
+	var (
+		archived, refused, filtered int
+		archivedBytes               int64
+	)
+	buffers := make([][]*pkg.ArchiveRequest, len(pkg.GConfig.Workers))
+	// workers only know the buckets of jobs which aren't dry runs
+	listers := clients
+	if options.DryRun {
+		listers = nil
+	}
+	err = listFiles(manager, bucket, prefix, options.ObjectList, listers, func(file *pkg.S3File) error {
+		if file.Size < options.MinSize {
+			refused++
+			pkg.GLogger.Debug("[Archive Job] refused %v, size %v is below %v", bucket+"/"+file.Name, file.Size, options.MinSize)
+			return nil
+		}
+		if !options.Filter.Match(file) {
+			filtered++
+			return nil
+		}
+		archived++
+		archivedBytes += file.Size
+		if options.DryRun {
+			pkg.GLogger.Info("[Archive Job] dry run: archive %v(%v bytes, %v) to %v", bucket+"/"+file.Name, file.Size, file.StorageClass, options.StorageClass)
+			return nil
+		}
+		idx := file.Id % int64(len(pkg.GConfig.Workers))
+		req := &pkg.ArchiveRequest{
+			File:         file,
+			Bucket:       bucket,
+			StorageClass: options.StorageClass,
+		}
+		buffers[idx] = append(buffers[idx], req)
+		if len(buffers[idx]) >= 1000 {
+			clients[idx].Call("RpcHandler.HandleArchive", buffers[idx], nil)
+			pkg.GLogger.Debug("[Archive Job] sent %v archive requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
+			buffers[idx] = nil
+		}
+		if time.Since(lastDrain) > 10*time.Second {
+			lastDrain = time.Now()
+			return drainManifest()
+		}
+		return nil
+	})
+	pkg.GLogger.Info("[Archive Job] %v objects(%v bytes) to archive, %v refused below minimum size, %v filtered", archived, archivedBytes, refused, filtered)
+	if options.DryRun {
+		return err
+	}
+	for i := 0; i < len(pkg.GConfig.Workers); i++ {
+		buffers[i] = append(buffers[i], &pkg.ArchiveRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleArchive", buffers[i], nil)
+		pkg.GLogger.Debug("[Archive Job] sent %v archive requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
+		buffers[i] = nil
+	}
+	// objects archived before listing failed still belong in the manifest
+	if drainErr := drainUntilFinished(clients, drainManifest); err == nil {
+		err = drainErr
+	}
+	pkg.GLogger.Info("[Archive Job] %v archived objects written to %v", total, options.ManifestPath)
+	return err
+}
+
+// Data undelete job. Remove the delete markers hiding the latest version of files in a versioned
+// bucket, only markers created in [after, before) are removed, zero times don't limit
+func RunUndeleteJob(bucket string, prefix string, clients []*rpc.Client, profile string, after time.Time, before time.Time, dryRun bool, objectList string) error {
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	cred, err := manager.GetCredential()
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(bucket) {
+		return errors.New(bucket + " doesn't exist")
+	}
+	region, err := manager.GetBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	if region != "us-west-2" {
+		manager, err = pkg.NewS3Manager(region, profile, pkg.GConfig.Source)
+		if err != nil {
+			return err
+		}
+	}
+	if !dryRun {
+		s3InfoReq := &pkg.S3InfoRequest{
+			Profile:   profile,
+			Region1:   region,
+			AwsKey:    cred.AccessKeyID,
+			AwsSecret: cred.SecretAccessKey,
+			AwsToken:  cred.SessionToken,
+			Endpoint1: pkg.GConfig.Source,
+		}
+		for _, cli := range clients {
+			err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
+			if err != nil {
+				return err
+			}
+			cli.Call("RpcHandler.StartUndeleteJob", "", nil)
+		}
+		pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data undelete job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+		go refreshCredentials(clients, manager, nil)
+	}
+	var files, markers int
+	buffers := make([][]*pkg.UndeleteRequest, len(pkg.GConfig.Workers))
+	err = listVersions(manager, bucket, prefix, objectList, func(history []*pkg.S3File) error {
+		deleteMarkers := pkg.UndeleteMarkers(history, after, before)
+		if len(deleteMarkers) == 0 {
+			return nil
+		}
+		files++
+		markers += len(deleteMarkers)
+		if dryRun {
+			version := history[len(history)-len(deleteMarkers)-1]
+			pkg.GLogger.Info("[Undelete Job] dry run: %v reappears with version %v(%v bytes, %v) after removing %v delete markers",
+				bucket+"/"+version.Name, version.VersionId, version.Size, version.LastModified, len(deleteMarkers))
+			return nil
+		}
+		for _, marker := range deleteMarkers {
+			idx := marker.Id % int64(len(pkg.GConfig.Workers))
+			buffers[idx] = append(buffers[idx], &pkg.UndeleteRequest{File: marker})
+			if len(buffers[idx]) >= 1000 {
+				clients[idx].Call("RpcHandler.HandleUndelete", buffers[idx], nil)
+				pkg.GLogger.Debug("[Undelete Job] sent %v undelete requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
+				buffers[idx] = nil
+			}
+		}
+		return nil
 	})
+	pkg.GLogger.Info("[Undelete Job] %v files to undelete, %v delete markers to remove", files, markers)
+	if dryRun {
+		return err
+	}
+	for i := 0; i < len(pkg.GConfig.Workers); i++ {
+		buffers[i] = append(buffers[i], &pkg.UndeleteRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleUndelete", buffers[i], nil)
+		pkg.GLogger.Debug("[Undelete Job] sent %v undelete requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
+		buffers[i] = nil
+	}
+	return err
+}
+
+// Data rollback job. Every file of a versioned bucket is rolled back to the version which was
+// current at the time: that version is copied in place to become current again and files
+// created later are deleted. Archived versions are restored, run the job again once they are
+func RunRollbackJob(bucket string, prefix string, clients []*rpc.Client, profile string, at time.Time, days int64, speed string, dryRun bool, objectList string) error {
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
 	if err != nil {
-		for i := 0; i < len(pkg.GConfig.Workers); i++ {
-			buffers[i] = append(buffers[i], &pkg.RestorationRequest{Finished: true})
-			clients[i].Call("RpcHandler.HandleRestoration", buffers[i], nil)
+		return err
+	}
+	cred, err := manager.GetCredential()
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(bucket) {
+		return errors.New(bucket + " doesn't exist")
+	}
+	region, err := manager.GetBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	if region != "us-west-2" {
+		manager, err = pkg.NewS3Manager(region, profile, pkg.GConfig.Source)
+		if err != nil {
+			return err
+		}
+	}
+	versioned, err := manager.IsVersioned(bucket)
+	if err != nil {
+		return err
+	}
+	if !versioned {
+		return errors.New(bucket + " is not versioned")
+	}
+	if !dryRun {
+		s3InfoReq := &pkg.S3InfoRequest{
+			Profile:   profile,
+			Region1:   region,
+			AwsKey:    cred.AccessKeyID,
+			AwsSecret: cred.SecretAccessKey,
+			AwsToken:  cred.SessionToken,
+			Endpoint1: pkg.GConfig.Source,
+		}
+		for _, cli := range clients {
+			err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
+			if err != nil {
+				return err
+			}
+			cli.Call("RpcHandler.StartRollbackJob", "", nil)
+		}
+		pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data rollback job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+		go refreshCredentials(clients, manager, nil)
+	}
+	var rolledBack, archived, deleted, unchanged int
+	buffers := make([][]*pkg.RollbackRequest, len(pkg.GConfig.Workers))
+	dispatch := func(req *pkg.RollbackRequest) {
+		if dryRun {
+			if req.Delete {
+				pkg.GLogger.Info("[Rollback Job] dry run: delete %v", bucket+"/"+req.File.Name)
+			} else {
+				pkg.GLogger.Info("[Rollback Job] dry run: roll back %v to version %v(%v, %v)", bucket+"/"+req.File.Name, req.File.VersionId, req.File.LastModified, req.File.StorageClass)
+			}
+			return
+		}
+		idx := req.File.Id % int64(len(pkg.GConfig.Workers))
+		buffers[idx] = append(buffers[idx], req)
+		if len(buffers[idx]) >= 1000 {
+			clients[idx].Call("RpcHandler.HandleRollback", buffers[idx], nil)
+			pkg.GLogger.Debug("[Rollback Job] sent %v rollback requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
+			buffers[idx] = nil
 		}
+	}
+	err = listVersions(manager, bucket, prefix, objectList, func(history []*pkg.S3File) error {
+		target := pkg.VersionAt(history, at)
+		latest := history[len(history)-1]
+		switch {
+		case target == latest:
+			unchanged++
+		case target == nil || target.IsDeleteMarker:
+			if latest.IsDeleteMarker {
+				unchanged++
+				return nil
+			}
+			deleted++
+			dispatch(&pkg.RollbackRequest{File: latest, Delete: true})
+		case !latest.IsDeleteMarker && latest.Size == target.Size && latest.ETag == target.ETag:
+			// rolled back by an earlier run, workers check the rollback marker of multipart copies
+			unchanged++
+		default:
+			rolledBack++
+			if pkg.IsArchived(target.StorageClass) {
+				archived++
+			}
+			dispatch(&pkg.RollbackRequest{File: target, Days: days, Speed: speed})
+		}
+		return nil
+	})
+	pkg.GLogger.Info("[Rollback Job] %v files to roll back(%v archived versions need restoration), %v files to delete, %v files unchanged", rolledBack, archived, deleted, unchanged)
+	if dryRun {
 		return err
 	}
 	for i := 0; i < len(pkg.GConfig.Workers); i++ {
-		buffers[i] = append(buffers[i], &pkg.RestorationRequest{Finished: true})
-		clients[i].Call("RpcHandler.HandleRestoration", buffers[i], nil)
-		pkg.GLogger.Debug("[Restoration Job] sent %v restoration requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
+		buffers[i] = append(buffers[i], &pkg.RollbackRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleRollback", buffers[i], nil)
+		pkg.GLogger.Debug("[Rollback Job] sent %v rollback requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
 		buffers[i] = nil
 	}
-	return nil
+	return err
 }
 
-func RunRecoveryJob(bucket string, prefix string, clients []*rpc.Client, profile string) error {
+// Data scan job. Count the objects of bucket and their bytes by storage class, top level prefix,
+// size and month of last modification. Master counts what listings tell, workers only look up the
+// restore status of archived objects, so the job summary counts archived objects.
+// The report is printed as a table and written to reportPath, as CSV if it ends with .csv
+func RunScanJob(bucket string, prefix string, clients []*rpc.Client, profile string, reportPath string, objectList string) error {
 	// create s3 manager
-	manager, err := pkg.NewS3Manager("us-west-2", profile)
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
 	if err != nil {
 		return err
 	}
-	key, pwd := manager.GetCredential()
-	if key == "" || pwd == "" {
-		return errors.New("aws profile " + profile + " does not exist")
+	cred, err := manager.GetCredential()
+	if err != nil {
+		return err
 	}
 	if !manager.BucketExists(bucket) {
 		return errors.New(bucket + " doesn't exist")
@@ -356,7 +2300,7 @@ func RunRecoveryJob(bucket string, prefix string, clients []*rpc.Client, profile
 		return err
 	}
 	if region != "us-west-2" {
-		manager, err = pkg.NewS3Manager(region, profile)
+		manager, err = pkg.NewS3Manager(region, profile, pkg.GConfig.Source)
 		if err != nil {
 			return err
 		}
@@ -364,44 +2308,349 @@ func RunRecoveryJob(bucket string, prefix string, clients []*rpc.Client, profile
 	s3InfoReq := &pkg.S3InfoRequest{
 		Profile:   profile,
 		Region1:   region,
-		AwsKey:    key,
-		AwsSecret: pwd,
+		AwsKey:    cred.AccessKeyID,
+		AwsSecret: cred.SecretAccessKey,
+		AwsToken:  cred.SessionToken,
+		Endpoint1: pkg.GConfig.Source,
 	}
 	for _, cli := range clients {
 		err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
 		if err != nil {
 			return err
 		}
-		cli.Call("RpcHandler.StartRecoveryJob", "", nil)
+		cli.Call("RpcHandler.StartScanJob", "", nil)
 	}
-	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data recovery job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
-	buffers := make([][]*pkg.RecoveryRequest, len(pkg.GConfig.Workers))
-	err = manager.HandleFiles(bucket, prefix, func(file *pkg.S3File) error {
-		idx := file.Id % int64(len(pkg.GConfig.Workers))
-		req := &pkg.RecoveryRequest{
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data scan job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+	go refreshCredentials(clients, manager, nil)
+	// listings tell everything but the restore status of archived files, only those are sent to workers
+	report := pkg.NewScanReport()
+	var archived int64
+	buffers := make([][]*pkg.ScanRequest, len(pkg.GConfig.Workers))
+	err = listFiles(manager, bucket, prefix, objectList, clients, func(file *pkg.S3File) error {
+		if !pkg.IsArchived(file.StorageClass) {
+			report.Add(file, prefix)
+			return nil
+		}
+		idx := archived % int64(len(pkg.GConfig.Workers))
+		archived++
+		buffers[idx] = append(buffers[idx], &pkg.ScanRequest{
 			File:   file,
 			Bucket: bucket,
-		}
-		buffers[idx] = append(buffers[idx], req)
+			Prefix: prefix,
+		})
 		if len(buffers[idx]) >= 1000 {
-			clients[idx].Call("RpcHandler.HandleRestoration", buffers[idx], nil)
-			pkg.GLogger.Debug("[Recovery Job] sent %v recovery requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
+			clients[idx].Call("RpcHandler.HandleScan", buffers[idx], nil)
+			pkg.GLogger.Debug("[Scan Job] sent %v scan requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
 			buffers[idx] = nil
 		}
 		return nil
 	})
+	for i := 0; i < len(pkg.GConfig.Workers); i++ {
+		buffers[i] = append(buffers[i], &pkg.ScanRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleScan", buffers[i], nil)
+		pkg.GLogger.Debug("[Scan Job] sent %v scan requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
+		buffers[i] = nil
+	}
 	if err != nil {
-		for i := 0; i < len(pkg.GConfig.Workers); i++ {
-			buffers[i] = append(buffers[i], &pkg.RecoveryRequest{Finished: true})
-			clients[i].Call("RpcHandler.HandleRecovery", buffers[i], nil)
+		return err
+	}
+	waitForTask(clients)
+	for i, cli := range clients {
+		part := pkg.NewScanReport()
+		err := cli.Call("RpcHandler.HandleScanReport", "", part)
+		if err != nil {
+			return err
+		}
+		pkg.GLogger.Debug("[Scan Job] received statistics of %v objects from %v", part.Total.Objects, pkg.GConfig.Workers[i])
+		report.Merge(part)
+	}
+	if err := report.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if strings.HasSuffix(reportPath, ".csv") {
+		err = report.WriteCSV(file)
+	} else {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	}
+	if err != nil {
+		return err
+	}
+	pkg.GLogger.Info("[Scan Job] statistics of %v objects(%v bytes) written to %v", report.Total.Objects, report.Total.Bytes, reportPath)
+	return nil
+}
+
+// Data export job. Write the listing of bucket to CSV or JSONL files which can be used as key lists,
+// or to Parquet files, see pkg.ExportOptions. Master writes the files itself, objects are listed
+// like for other jobs
+func RunExportJob(bucket string, prefix string, profile string, options *pkg.ExportOptions, objectList string) error {
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(bucket) {
+		return errors.New(bucket + " doesn't exist")
+	}
+	region, err := manager.GetBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	if region != "us-west-2" {
+		manager, err = pkg.NewS3Manager(region, profile, pkg.GConfig.Source)
+		if err != nil {
+			return err
+		}
+	}
+	exporter, err := manager.NewExporter(options)
+	if err != nil {
+		return err
+	}
+	err = listFiles(manager, bucket, prefix, objectList, nil, exporter.Export)
+	if e := exporter.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	pkg.GLogger.Info("[Export Job] %v objects of %v exported to %v files", exporter.Rows, bucket, len(exporter.Files))
+	return nil
+}
+
+// Data diff job. Compare from under fromPrefix with to under toPrefix, which may be in other regions
+// or accounts. The report lists keys only in one bucket and keys whose size, etag, storage class or,
+// with compareMetadata, headers and metadata differ. It can be the object list of a migration,
+// which needs a prefix rule if the prefixes differ
+func RunDiffJob(from string, fromPrefix string, to string, toPrefix string, profile string, targetProfile string, compareMetadata bool, reportPath string, objectList string) error {
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(from) {
+		return errors.New(from + " doesn't exist")
+	}
+	if targetProfile == "" {
+		targetProfile = profile
+	}
+	destManager, err := pkg.NewS3Manager("us-west-2", targetProfile, pkg.GConfig.Destination)
+	if err != nil {
+		return err
+	}
+	if !destManager.BucketExists(to) {
+		return errors.New(to + " doesn't exist")
+	}
+	region1, err := manager.GetBucketRegion(from)
+	if err != nil {
+		return err
+	}
+	region2, err := destManager.GetBucketRegion(to)
+	if err != nil {
+		return err
+	}
+	manager, err = pkg.NewS3Manager(region1, profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	destManager, err = pkg.NewS3Manager(region2, targetProfile, pkg.GConfig.Destination)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	report := pkg.NewDiffReport(file, manager, destManager, compareMetadata)
+	err = compareBuckets(manager, from, fromPrefix, destManager, to, toPrefix, objectList, func(src *pkg.S3File, dst *pkg.S3File) error {
+		if src == nil {
+			return report.Compare(strings.TrimPrefix(dst.Name, toPrefix), src, dst)
+		}
+		return report.Compare(strings.TrimPrefix(src.Name, fromPrefix), src, dst)
+	})
+	if e := report.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	pkg.GLogger.Info("[Diff Job] %v differing keys written to %v, only in %v: %v, only in %v: %v, size: %v, etag: %v, storage class: %v, metadata: %v",
+		report.Keys, reportPath, from, report.Counts[pkg.DiscrepancyMissing], to, report.Counts[pkg.DiscrepancyExtra],
+		report.Counts[pkg.DiscrepancySize], report.Counts[pkg.DiscrepancyETag], report.Counts[pkg.DiscrepancyStorageClass], report.Counts[pkg.DiscrepancyMetadata])
+	if fromPrefix != toPrefix {
+		pkg.GLogger.Warning("[Diff Job] keys of the report are under %v, a migration from it needs the rule \"prefix %v %v\"", fromPrefix, fromPrefix, toPrefix)
+	}
+	return nil
+}
+
+// Lifecycle check. Lint the lifecycle rules of bucket, or the rules of a configuration file before
+// they are applied, and simulate them against the current objects: how many objects and bytes each
+// rule would transition or expire, and when. Nothing is changed
+func RunLifecycleCheck(bucket string, profile string, rulesPath string, objectList string) error {
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(bucket) {
+		return errors.New(bucket + " doesn't exist")
+	}
+	region, err := manager.GetBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	if region != "us-west-2" {
+		manager, err = pkg.NewS3Manager(region, profile, pkg.GConfig.Source)
+		if err != nil {
+			return err
+		}
+	}
+	var rules []*s3.LifecycleRule
+	if rulesPath != "" {
+		rules, err = pkg.ReadLifecycleRules(rulesPath)
+	} else {
+		rules, err = manager.GetLifecycleRules(bucket)
+	}
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		pkg.GLogger.Info("[Lifecycle Check] %v has no lifecycle rules", bucket)
+		return nil
+	}
+	sim := pkg.NewLifecycleSimulation(rules, time.Now())
+	err = listFiles(manager, bucket, "", objectList, nil, func(file *pkg.S3File) error {
+		sim.Add(file)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	pkg.GLogger.Info("[Lifecycle Check] %v rules simulated against %v objects(%v bytes) of %v", len(rules), sim.Objects.Objects, sim.Objects.Bytes, bucket)
+	if err := sim.WriteTable(os.Stdout); err != nil {
+		return err
+	}
+	findings := pkg.LintLifecycleRules(rules, sim)
+	for _, finding := range findings {
+		if finding.Severity == pkg.LintWarning {
+			pkg.GLogger.Warning("[Lifecycle Check] %v", finding)
+		} else {
+			pkg.GLogger.Error("[Lifecycle Check] %v", finding)
 		}
+	}
+	if len(findings) == 0 {
+		pkg.GLogger.Info("[Lifecycle Check] no problems found")
+	}
+	return nil
+}
+
+// Restore monitor job. Check the restored copies of the archived files of bucket and warn about copies
+// expiring within threshold days, which have to be restored and paid for again once expired.
+// Expiring copies can be extended by days or recovered instead, with the storage class, metadata and
+// encryption RunRecoveryJob takes, see pkg.RestoreStatus
+func RunRestoreMonitorJob(bucket string, prefix string, clients []*rpc.Client, profile string, threshold int64, action string, days int64, storageClass *pkg.StorageClassPolicy, metadata *pkg.MetadataOptions, encryption *pkg.EncryptionOptions, objectList string) error {
+	if action == pkg.MonitorExtend && days < 1 {
+		return errors.New("restored copies must be extended by at least 1 day")
+	}
+	// create s3 manager
+	manager, err := pkg.NewS3Manager("us-west-2", profile, pkg.GConfig.Source)
+	if err != nil {
+		return err
+	}
+	cred, err := manager.GetCredential()
+	if err != nil {
+		return err
+	}
+	if !manager.BucketExists(bucket) {
+		return errors.New(bucket + " doesn't exist")
+	}
+	region, err := manager.GetBucketRegion(bucket)
+	if err != nil {
 		return err
 	}
+	if region != "us-west-2" {
+		manager, err = pkg.NewS3Manager(region, profile, pkg.GConfig.Source)
+		if err != nil {
+			return err
+		}
+	}
+	s3InfoReq := &pkg.S3InfoRequest{
+		Profile:    profile,
+		Region1:    region,
+		AwsKey:     cred.AccessKeyID,
+		AwsSecret:  cred.SecretAccessKey,
+		AwsToken:   cred.SessionToken,
+		Endpoint1:  pkg.GConfig.Source,
+		Encryption: encryption,
+	}
+	for _, cli := range clients {
+		err := cli.Call("RpcHandler.HandleS3Info", s3InfoReq, nil)
+		if err != nil {
+			return err
+		}
+		cli.Call("RpcHandler.StartRestoreMonitorJob", "", nil)
+	}
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> restore monitor job started <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<")
+	go refreshCredentials(clients, manager, nil)
+	buffers := make([][]*pkg.RestoreMonitorRequest, len(pkg.GConfig.Workers))
+	err = listFiles(manager, bucket, prefix, objectList, clients, func(file *pkg.S3File) error {
+		// only archived files have restored copies, key lists may not tell the storage class
+		if file.StorageClass != "" && !pkg.IsArchived(file.StorageClass) {
+			return nil
+		}
+		idx := file.Id % int64(len(pkg.GConfig.Workers))
+		req := &pkg.RestoreMonitorRequest{
+			File:      file,
+			Bucket:    bucket,
+			Threshold: threshold,
+			Action:    action,
+			Days:      days,
+		}
+		if action == pkg.MonitorRecover {
+			req.StorageClass, req.Metadata = storageClass.Resolve(file), metadata
+		}
+		buffers[idx] = append(buffers[idx], req)
+		if len(buffers[idx]) >= 1000 {
+			clients[idx].Call("RpcHandler.HandleRestoreMonitor", buffers[idx], nil)
+			pkg.GLogger.Debug("[Restore Monitor Job] sent %v monitor requests to %v", len(buffers[idx]), pkg.GConfig.Workers[idx])
+			buffers[idx] = nil
+		}
+		return nil
+	})
 	for i := 0; i < len(pkg.GConfig.Workers); i++ {
-		buffers[i] = append(buffers[i], &pkg.RecoveryRequest{Finished: true})
-		clients[i].Call("RpcHandler.HandleRecovery", buffers[i], nil)
-		pkg.GLogger.Debug("[Recovery Job] sent %v restoration requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
+		buffers[i] = append(buffers[i], &pkg.RestoreMonitorRequest{Finished: true})
+		clients[i].Call("RpcHandler.HandleRestoreMonitor", buffers[i], nil)
+		pkg.GLogger.Debug("[Restore Monitor Job] sent %v monitor requests to %v", len(buffers[i]), pkg.GConfig.Workers[i])
 		buffers[i] = nil
 	}
+	if err != nil {
+		return err
+	}
+	waitForTask(clients)
+	report := &pkg.RestoreMonitorReport{}
+	for i, cli := range clients {
+		part := &pkg.RestoreMonitorReport{}
+		err := cli.Call("RpcHandler.HandleRestoreMonitorReport", "", part)
+		if err != nil {
+			return err
+		}
+		pkg.GLogger.Debug("[Restore Monitor Job] received restore status of %v expiring copies from %v", part.Expiring.Objects, pkg.GConfig.Workers[i])
+		report.Merge(part)
+	}
+	pkg.GLogger.Info("[Restore Monitor Job] restored=%v(%v bytes), expiring within %v days=%v(%v bytes), extended=%v, recovered=%v, ongoing=%v, not restored=%v",
+		report.Restored.Objects, report.Restored.Bytes, threshold, report.Expiring.Objects, report.Expiring.Bytes,
+		report.Extended.Objects, report.Recovered.Objects, report.Ongoing.Objects, report.NotRestored.Objects)
+	if left := report.Expiring.Objects - report.Extended.Objects - report.Recovered.Objects; left > 0 {
+		pkg.GLogger.Warning("[Restore Monitor Job] %v restored copies expire within %v days and aren't recovered, run S3 Bucket Recovery before they have to be restored again", left, threshold)
+	}
+	if !report.NextExpiry.IsZero() {
+		pkg.GLogger.Info("[Restore Monitor Job] the next restored copy expires at %v", report.NextExpiry)
+	}
 	return nil
 }
@@ -0,0 +1,326 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"strings"
+)
+
+// error codes of settings a bucket doesn't have
+var settingNotFound = map[string]bool{
+	"NoSuchBucketPolicy":                             true,
+	"NoSuchCORSConfiguration":                        true,
+	"NoSuchLifecycleConfiguration":                   true,
+	"NoSuchTagSet":                                   true,
+	"ServerSideEncryptionConfigurationNotFoundError": true,
+	"NoSuchWebsiteConfiguration":                     true,
+	"NoSuchPublicAccessBlockConfiguration":           true,
+}
+
+// A bucket level setting. get returns nil if the bucket doesn't have it
+type bucketSetting struct {
+	name string
+	get  func(cli *s3.S3, bucket string) (interface{}, error)
+	put  func(cli *s3.S3, bucket string, value interface{}) error
+}
+
+// name of the notification setting, notifications fire for every object copied once they are set
+const NotificationSetting = "notification"
+
+// Settings in the order they are applied. Versioning comes first so copies keep their versions,
+// notifications come last and should only be applied once objects are copied
+var bucketSettings = []*bucketSetting{
+	{
+		name: "versioning",
+		get: func(cli *s3.S3, bucket string) (interface{}, error) {
+			res, err := cli.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+			// MFA delete can only be changed with the root account's MFA device
+			if err != nil || res.Status == nil {
+				return nil, err
+			}
+			return &s3.VersioningConfiguration{Status: res.Status}, nil
+		},
+		put: func(cli *s3.S3, bucket string, value interface{}) error {
+			_, err := cli.PutBucketVersioning(&s3.PutBucketVersioningInput{
+				Bucket:                  aws.String(bucket),
+				VersioningConfiguration: value.(*s3.VersioningConfiguration),
+			})
+			return err
+		},
+	},
+	{
+		name: "encryption",
+		get: func(cli *s3.S3, bucket string) (interface{}, error) {
+			res, err := cli.GetBucketEncryption(&s3.GetBucketEncryptionInput{Bucket: aws.String(bucket)})
+			if err != nil {
+				return nil, err
+			}
+			return res.ServerSideEncryptionConfiguration, nil
+		},
+		put: func(cli *s3.S3, bucket string, value interface{}) error {
+			_, err := cli.PutBucketEncryption(&s3.PutBucketEncryptionInput{
+				Bucket:                            aws.String(bucket),
+				ServerSideEncryptionConfiguration: value.(*s3.ServerSideEncryptionConfiguration),
+			})
+			return err
+		},
+	},
+	{
+		name: "public_access_block",
+		get: func(cli *s3.S3, bucket string) (interface{}, error) {
+			res, err := cli.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)})
+			if err != nil {
+				return nil, err
+			}
+			return res.PublicAccessBlockConfiguration, nil
+		},
+		put: func(cli *s3.S3, bucket string, value interface{}) error {
+			_, err := cli.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+				Bucket:                         aws.String(bucket),
+				PublicAccessBlockConfiguration: value.(*s3.PublicAccessBlockConfiguration),
+			})
+			return err
+		},
+	},
+	{
+		name: "policy",
+		get: func(cli *s3.S3, bucket string) (interface{}, error) {
+			res, err := cli.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(bucket)})
+			if err != nil {
+				return nil, err
+			}
+			// compared as JSON rather than as text
+			var policy interface{}
+			if err := json.Unmarshal([]byte(aws.StringValue(res.Policy)), &policy); err != nil {
+				return nil, err
+			}
+			return policy, nil
+		},
+		put: func(cli *s3.S3, bucket string, value interface{}) error {
+			policy, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			_, err = cli.PutBucketPolicy(&s3.PutBucketPolicyInput{
+				Bucket: aws.String(bucket),
+				Policy: aws.String(string(policy)),
+			})
+			return err
+		},
+	},
+	{
+		name: "cors",
+		get: func(cli *s3.S3, bucket string) (interface{}, error) {
+			res, err := cli.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(bucket)})
+			if err != nil {
+				return nil, err
+			}
+			return &s3.CORSConfiguration{CORSRules: res.CORSRules}, nil
+		},
+		put: func(cli *s3.S3, bucket string, value interface{}) error {
+			_, err := cli.PutBucketCors(&s3.PutBucketCorsInput{
+				Bucket:            aws.String(bucket),
+				CORSConfiguration: value.(*s3.CORSConfiguration),
+			})
+			return err
+		},
+	},
+	{
+		name: "lifecycle",
+		get: func(cli *s3.S3, bucket string) (interface{}, error) {
+			res, err := cli.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+			if err != nil {
+				return nil, err
+			}
+			return &s3.BucketLifecycleConfiguration{Rules: res.Rules}, nil
+		},
+		put: func(cli *s3.S3, bucket string, value interface{}) error {
+			_, err := cli.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+				Bucket:                 aws.String(bucket),
+				LifecycleConfiguration: value.(*s3.BucketLifecycleConfiguration),
+			})
+			return err
+		},
+	},
+	{
+		name: "tags",
+		get: func(cli *s3.S3, bucket string) (interface{}, error) {
+			res, err := cli.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: aws.String(bucket)})
+			if err != nil {
+				return nil, err
+			}
+			return &s3.Tagging{TagSet: res.TagSet}, nil
+		},
+		put: func(cli *s3.S3, bucket string, value interface{}) error {
+			_, err := cli.PutBucketTagging(&s3.PutBucketTaggingInput{
+				Bucket:  aws.String(bucket),
+				Tagging: value.(*s3.Tagging),
+			})
+			return err
+		},
+	},
+	{
+		name: "website",
+		get: func(cli *s3.S3, bucket string) (interface{}, error) {
+			res, err := cli.GetBucketWebsite(&s3.GetBucketWebsiteInput{Bucket: aws.String(bucket)})
+			if err != nil {
+				return nil, err
+			}
+			return &s3.WebsiteConfiguration{
+				ErrorDocument:         res.ErrorDocument,
+				IndexDocument:         res.IndexDocument,
+				RedirectAllRequestsTo: res.RedirectAllRequestsTo,
+				RoutingRules:          res.RoutingRules,
+			}, nil
+		},
+		put: func(cli *s3.S3, bucket string, value interface{}) error {
+			_, err := cli.PutBucketWebsite(&s3.PutBucketWebsiteInput{
+				Bucket:               aws.String(bucket),
+				WebsiteConfiguration: value.(*s3.WebsiteConfiguration),
+			})
+			return err
+		},
+	},
+	{
+		name: NotificationSetting,
+		get: func(cli *s3.S3, bucket string) (interface{}, error) {
+			res, err := cli.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{Bucket: aws.String(bucket)})
+			if err != nil || len(res.LambdaFunctionConfigurations)+len(res.QueueConfigurations)+len(res.TopicConfigurations) == 0 {
+				return nil, err
+			}
+			return res, nil
+		},
+		put: func(cli *s3.S3, bucket string, value interface{}) error {
+			_, err := cli.PutBucketNotificationConfiguration(&s3.PutBucketNotificationConfigurationInput{
+				Bucket:                    aws.String(bucket),
+				NotificationConfiguration: value.(*s3.NotificationConfiguration),
+			})
+			return err
+		},
+	},
+}
+
+// A setting which differs between two buckets. Source and Dest are JSON, empty if the bucket doesn't have it
+type BucketSettingDiff struct {
+	Name   string
+	Source string
+	Dest   string
+	// source setting as it is applied to the destination
+	value   interface{}
+	setting *bucketSetting
+}
+
+// diff style lines, "+" is set on the destination, "~" replaces the destination's setting
+func (d *BucketSettingDiff) String() string {
+	if d.Dest == "" {
+		return fmt.Sprintf("+ %v\t%v", d.Name, d.Source)
+	}
+	return fmt.Sprintf("~ %v\t%v -> %v", d.Name, d.Dest, d.Source)
+}
+
+// Compare the settings of bucket from with those of bucket to, dest is nil if to doesn't exist yet.
+// Settings only the destination has are kept, so they aren't reported. Policies are compared with
+// the source bucket's ARN replaced. Notifications are only compared if asked for, their targets
+// belong to the source account
+func DiffBucketConfig(source *S3Manager, from string, dest *S3Manager, to string, notifications bool) ([]*BucketSettingDiff, error) {
+	var res []*BucketSettingDiff
+	for _, setting := range bucketSettings {
+		if setting.name == NotificationSetting && !notifications {
+			continue
+		}
+		value, err := getSetting(setting, source, from)
+		if err != nil || value == nil {
+			if err != nil {
+				return nil, fmt.Errorf("cannot get %v of %v, reason: %v", setting.name, from, err)
+			}
+			continue
+		}
+		if setting.name == "policy" {
+			if value, err = replaceBucketArn(value, from, to); err != nil {
+				return nil, err
+			}
+		}
+		var current interface{}
+		if dest != nil {
+			current, err = getSetting(setting, dest, to)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get %v of %v, reason: %v", setting.name, to, err)
+			}
+		}
+		a, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		diff := &BucketSettingDiff{Name: setting.name, Source: string(a), value: value, setting: setting}
+		if current != nil {
+			b, err := json.Marshal(current)
+			if err != nil {
+				return nil, err
+			}
+			if string(a) == string(b) {
+				continue
+			}
+			diff.Dest = string(b)
+		}
+		res = append(res, diff)
+	}
+	return res, nil
+}
+
+func getSetting(setting *bucketSetting, manager *S3Manager, bucket string) (interface{}, error) {
+	value, err := setting.get(manager.s3cli, bucket)
+	if aerr, ok := err.(awserr.Error); ok && settingNotFound[aerr.Code()] {
+		return nil, nil
+	}
+	return value, err
+}
+
+// policies name their bucket in resources, e.g. arn:aws:s3:::bucket/*
+func replaceBucketArn(policy interface{}, from string, to string) (interface{}, error) {
+	b, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+	replacer := strings.NewReplacer(
+		"arn:aws:s3:::"+from+"\"", "arn:aws:s3:::"+to+"\"",
+		"arn:aws:s3:::"+from+"/", "arn:aws:s3:::"+to+"/",
+	)
+	var res interface{}
+	err = json.Unmarshal([]byte(replacer.Replace(string(b))), &res)
+	return res, err
+}
+
+// Apply the source settings of diffs to bucket to. Every setting is tried, the first error is returned
+func ApplyBucketConfig(dest *S3Manager, to string, diffs []*BucketSettingDiff) error {
+	var res error
+	for _, diff := range diffs {
+		err := diff.setting.put(dest.s3cli, to, diff.value)
+		if err != nil {
+			GLogger.Warning("Exception in setting %v of %v, reason: %v", diff.Name, to, err)
+			if res == nil {
+				res = fmt.Errorf("cannot set %v of %v, reason: %v", diff.Name, to, err)
+			}
+			continue
+		}
+		GLogger.Info("set %v of %v", diff.Name, to)
+	}
+	return res
+}
+
+// create bucket in the manager's region
+func (manager *S3Manager) CreateBucket(bucket string) error {
+	input := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+	// us-east-1 is the default location and cannot be a constraint
+	if manager.region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{LocationConstraint: aws.String(manager.region)}
+	}
+	_, err := manager.s3cli.CreateBucket(input)
+	if err != nil {
+		return err
+	}
+	GLogger.Info("created bucket %v in %v", bucket, manager.region)
+	return nil
+}
@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func putSetting(t *testing.T, manager *S3Manager, bucket string, name string, value interface{}) {
+	for _, setting := range bucketSettings {
+		if setting.name == name {
+			if err := setting.put(manager.s3cli, bucket, value); err != nil {
+				t.Fatalf("cannot put %v, reason: %v", name, err)
+			}
+			return
+		}
+	}
+	t.Fatalf("no setting %v", name)
+}
+
+func jsonValue(t *testing.T, s string) interface{} {
+	var value interface{}
+	if err := json.Unmarshal([]byte(s), &value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// diffs as "name +" or "name ~"
+func describeDiffs(diffs []*BucketSettingDiff) []string {
+	var res []string
+	for _, diff := range diffs {
+		res = append(res, diff.String()[2:2+len(diff.Name)]+" "+diff.String()[:1])
+	}
+	return res
+}
+
+func TestReplaceBucketArn(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{"bucket", `{"Resource":"arn:aws:s3:::src"}`, `{"Resource":"arn:aws:s3:::dst"}`},
+		{"objects", `{"Resource":["arn:aws:s3:::src/*","arn:aws:s3:::src/logs/*"]}`, `{"Resource":["arn:aws:s3:::dst/*","arn:aws:s3:::dst/logs/*"]}`},
+		{"other buckets", `{"Resource":["arn:aws:s3:::src-logs/*","arn:aws:s3:::my-src","arn:aws:s3:::srcs"]}`,
+			`{"Resource":["arn:aws:s3:::src-logs/*","arn:aws:s3:::my-src","arn:aws:s3:::srcs"]}`},
+		{"conditions", `{"Condition":{"ArnLike":{"aws:SourceArn":"arn:aws:s3:::src"}},"Principal":{"AWS":"arn:aws:iam::111122223333:root"}}`,
+			`{"Condition":{"ArnLike":{"aws:SourceArn":"arn:aws:s3:::dst"}},"Principal":{"AWS":"arn:aws:iam::111122223333:root"}}`},
+		{"bucket name elsewhere", `{"Sid":"src","Resource":"arn:aws:s3:::dst/src/*"}`, `{"Sid":"src","Resource":"arn:aws:s3:::dst/src/*"}`},
+	}
+	for _, test := range tests {
+		got, err := replaceBucketArn(jsonValue(t, test.policy), "src", "dst")
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if want := jsonValue(t, test.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, want)
+		}
+	}
+}
+
+// a source bucket with versioning, a policy, cors, tags and a notification, and a destination
+// with the same cors, other tags and a lifecycle
+func bucketConfigFakes(t *testing.T) (*fakeS3, *fakeS3) {
+	source, dest := newFakeS3(), newFakeS3()
+	putSetting(t, source.manager(), "src", "versioning", &s3.VersioningConfiguration{Status: aws.String("Enabled")})
+	putSetting(t, source.manager(), "src", "policy", jsonValue(t, `{"Version":"2012-10-17","Statement":[{"Effect":"Allow",
+		"Principal":{"AWS":"arn:aws:iam::111122223333:root"},"Action":"s3:GetObject","Resource":["arn:aws:s3:::src/*","arn:aws:s3:::src-logs/*"]}]}`))
+	cors := &s3.CORSConfiguration{CORSRules: []*s3.CORSRule{{AllowedMethods: aws.StringSlice([]string{"GET"}), AllowedOrigins: aws.StringSlice([]string{"*"})}}}
+	putSetting(t, source.manager(), "src", "cors", cors)
+	putSetting(t, source.manager(), "src", "tags", &s3.Tagging{TagSet: []*s3.Tag{{Key: aws.String("team"), Value: aws.String("a")}}})
+	putSetting(t, source.manager(), "src", NotificationSetting, &s3.NotificationConfiguration{TopicConfigurations: []*s3.TopicConfiguration{{
+		TopicArn: aws.String("arn:aws:sns:us-east-1:111122223333:topic"), Events: aws.StringSlice([]string{"s3:ObjectCreated:*"})}}})
+	putSetting(t, dest.manager(), "dst", "cors", cors)
+	putSetting(t, dest.manager(), "dst", "tags", &s3.Tagging{TagSet: []*s3.Tag{{Key: aws.String("team"), Value: aws.String("b")}}})
+	putSetting(t, dest.manager(), "dst", "lifecycle", &s3.BucketLifecycleConfiguration{Rules: []*s3.LifecycleRule{{ID: aws.String("expire"),
+		Status: aws.String("Enabled"), Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("tmp/")}, Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)}}}})
+	return source, dest
+}
+
+func TestDiffBucketConfig(t *testing.T) {
+	source, dest := bucketConfigFakes(t)
+	defer source.server.Close()
+	defer dest.server.Close()
+	tests := []struct {
+		name          string
+		dest          *S3Manager
+		notifications bool
+		want          []string
+	}{
+		// settings the source doesn't have and the destination's lifecycle aren't reported
+		{"existing destination", dest.manager(), false, []string{"versioning +", "policy +", "tags ~"}},
+		{"notifications", dest.manager(), true, []string{"versioning +", "policy +", "tags ~", "notification +"}},
+		{"new destination", nil, false, []string{"versioning +", "policy +", "cors +", "tags +"}},
+	}
+	for _, test := range tests {
+		diffs, err := DiffBucketConfig(source.manager(), "src", test.dest, "dst", test.notifications)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if got := describeDiffs(diffs); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got diffs %v, want %v", test.name, got, test.want)
+		}
+		for _, diff := range diffs {
+			if diff.Name == "policy" && (!strings.Contains(diff.Source, "arn:aws:s3:::dst/*") || !strings.Contains(diff.Source, "arn:aws:s3:::src-logs/*")) {
+				t.Errorf("%v: policy %v", test.name, diff.Source)
+			}
+			if diff.Name == "tags" && test.dest != nil && (!strings.Contains(diff.Dest, `"b"`) || !strings.Contains(diff.Source, `"a"`)) {
+				t.Errorf("%v: tags %v", test.name, diff)
+			}
+		}
+	}
+
+	// only settings the bucket doesn't have are skipped
+	source.denied["encryption"] = true
+	if _, err := DiffBucketConfig(source.manager(), "src", dest.manager(), "dst", false); err == nil || !strings.Contains(err.Error(), "encryption") {
+		t.Errorf("got error %v for a denied setting", err)
+	}
+}
+
+func TestApplyBucketConfig(t *testing.T) {
+	source, dest := bucketConfigFakes(t)
+	defer source.server.Close()
+	defer dest.server.Close()
+	diffs, err := DiffBucketConfig(source.manager(), "src", dest.manager(), "dst", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a setting which cannot be set doesn't stop the others
+	dest.denied["tagging"] = true
+	if err := ApplyBucketConfig(dest.manager(), "dst", diffs); err == nil || !strings.Contains(err.Error(), "tags") {
+		t.Errorf("got error %v for a denied setting", err)
+	}
+	delete(dest.denied, "tagging")
+	if err := ApplyBucketConfig(dest.manager(), "dst", diffs); err != nil {
+		t.Fatal(err)
+	}
+	diffs, err = DiffBucketConfig(source.manager(), "src", dest.manager(), "dst", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("settings %v differ after applying them", describeDiffs(diffs))
+	}
+	// notifications are held back and the destination keeps its lifecycle
+	if dest.count("PUT notification") != 0 || dest.settings["lifecycle"] == nil || dest.count("PUT versioning") != 2 {
+		t.Errorf("got requests %v", dest.requests)
+	}
+
+	// notifications are applied once asked for
+	diffs, err = DiffBucketConfig(source.manager(), "src", dest.manager(), "dst", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyBucketConfig(dest.manager(), "dst", diffs); err != nil {
+		t.Fatal(err)
+	}
+	if got := describeDiffs(diffs); !reflect.DeepEqual(got, []string{"notification +"}) || dest.count("PUT notification") != 1 {
+		t.Errorf("applied %v", got)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/mgutz/ansi"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -30,6 +31,13 @@ type Config struct {
 	MasterPort int      `json:"master_port"`
 	WorkerPort int      `json:"worker_port"`
 	Worker     string   `json:"worker"`
+	// S3 compatible services of source and destination buckets, AWS if omitted
+	Source      *Endpoint `json:"source"`
+	Destination *Endpoint `json:"destination"`
+	// ranges of buckets listed at the same time, buckets are listed page by page if 0 or 1
+	ListingParallelism int `json:"listing_parallelism"`
+	// whether ranges are listed by workers instead of master
+	ListingOnWorkers bool `json:"listing_on_workers"`
 }
 
 var GConfig *Config
@@ -45,7 +53,28 @@ func InitConfig(filePath string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	if err = GConfig.Source.load(); err != nil {
+		return err
+	}
+	return GConfig.Destination.load()
+}
+
+// accepted layouts of times entered by users, interpreted as UTC
+var TimeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02", time.RFC3339}
+
+// parse a time entered by a user, blank is the zero time
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range TimeLayouts {
+		t, err := time.ParseInLocation(layout, s, time.UTC)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %v, expected one of %v", s, TimeLayouts)
 }
 
 /* logging setting */
@@ -0,0 +1,343 @@
+package pkg
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// debug mode logs to the console only
+	InitLogger(true)
+	os.Exit(m.Run())
+}
+
+type fakeObject struct {
+	body         []byte
+	modified     time.Time
+	metadata     map[string]string
+	storageClass string
+	// x-amz-restore header of archived objects
+	restore string
+}
+
+// An S3 endpoint with one bucket, path style. It answers HEAD, GET, PUT, copies and DELETE of
+// objects and their versions, object acls and ListObjectsV2 with prefix, delimiter, start-after,
+// fetch-owner and pageSize keys per page, and GET and PUT of bucket settings
+type fakeS3 struct {
+	mutex    sync.Mutex
+	objects  map[string]*fakeObject
+	versions map[string]*fakeObject
+	pageSize int
+	// requests by method, e.g. "GET", "HEAD", "PUT", "LIST", "COPY", "DELETE"
+	requests map[string]int
+	// version ids copied from in order, and those copies fail
+	copied   []string
+	failCopy map[string]bool
+	// bucket settings by subresource as they were put, e.g. "policy", and those answered with AccessDenied
+	settings map[string][]byte
+	denied   map[string]bool
+	server   *httptest.Server
+}
+
+// the test closes the server
+func newFakeS3() *fakeS3 {
+	fake := &fakeS3{objects: make(map[string]*fakeObject), versions: make(map[string]*fakeObject), pageSize: 1000,
+		requests: make(map[string]int), failCopy: make(map[string]bool), settings: make(map[string][]byte),
+		denied: make(map[string]bool)}
+	fake.server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	return fake
+}
+
+// a noncurrent version of key
+func (fake *fakeS3) putVersion(key string, versionId string, body string, storageClass string, restore string) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.versions[key+"?"+versionId] = &fakeObject{body: []byte(body), modified: time.Now().UTC().Truncate(time.Second),
+		metadata: map[string]string{}, storageClass: storageClass, restore: restore}
+}
+
+func (fake *fakeS3) put(key string, body string, modified time.Time) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.objects[key] = &fakeObject{body: []byte(body), modified: modified.UTC().Truncate(time.Second), metadata: map[string]string{}}
+}
+
+func (fake *fakeS3) count(method string) int {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return fake.requests[method]
+}
+
+// manager of the fake's bucket, requests aren't retried
+func (fake *fakeS3) manager() *S3Manager {
+	cred := credentials.NewStaticCredentials("key", "secret", "")
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(fake.server.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      cred,
+		MaxRetries:       aws.Int(0),
+	}))
+	return &S3Manager{s3cli: s3.New(sess), region: "us-east-1", cred: cred}
+}
+
+func (fake *fakeS3) serve(w http.ResponseWriter, r *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) == 1 || parts[1] == "" {
+		for name := range fakeSettingNotFound {
+			if _, ok := r.URL.Query()[name]; ok {
+				fake.setting(w, r, name)
+				return
+			}
+		}
+		fake.requests["LIST"]++
+		fake.list(w, r)
+		return
+	}
+	key := parts[1]
+	query := r.URL.Query()
+	if _, ok := query["acl"]; ok {
+		fake.requests["ACL"]++
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, xml.Header+"<AccessControlPolicy><Owner><ID>owner</ID></Owner><AccessControlList></AccessControlList></AccessControlPolicy>")
+		}
+		return
+	}
+	versionId := query.Get("versionId")
+	object := fake.objects[key]
+	if versionId != "" {
+		object = fake.versions[key+"?"+versionId]
+	}
+	switch {
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		fake.requests["COPY"]++
+		source := strings.SplitN(r.Header.Get("X-Amz-Copy-Source"), "?versionId=", 2)
+		path, _ := url.PathUnescape(source[0])
+		from := fake.objects[strings.SplitN(path, "/", 2)[1]]
+		if len(source) == 2 {
+			id, _ := url.QueryUnescape(source[1])
+			fake.copied = append(fake.copied, id)
+			if fake.failCopy[id] {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			from = fake.versions[strings.SplitN(path, "/", 2)[1]+"?"+id]
+		}
+		if from == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		copied := *from
+		copied.modified = time.Now().UTC().Truncate(time.Second)
+		copied.storageClass = r.Header.Get("X-Amz-Storage-Class")
+		copied.restore = ""
+		fake.objects[key] = &copied
+		fmt.Fprint(w, xml.Header+"<CopyObjectResult><ETag>"+copied.etag()+"</ETag></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		fake.requests["PUT"]++
+		body, _ := ioutil.ReadAll(r.Body)
+		object := &fakeObject{body: body, modified: time.Now().UTC().Truncate(time.Second), metadata: map[string]string{}}
+		for name := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+				object.metadata[name[len("x-amz-meta-"):]] = r.Header.Get(name)
+			}
+		}
+		fake.objects[key] = object
+		w.Header().Set("ETag", object.etag())
+	case r.Method == http.MethodDelete:
+		fake.requests["DELETE"]++
+		if versionId != "" {
+			delete(fake.versions, key+"?"+versionId)
+		} else {
+			delete(fake.objects, key)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		fake.requests[r.Method]++
+		if object == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.body)))
+		w.Header().Set("Last-Modified", object.modified.Format(http.TimeFormat))
+		w.Header().Set("ETag", object.etag())
+		if object.storageClass != "" && object.storageClass != "STANDARD" {
+			w.Header().Set("X-Amz-Storage-Class", object.storageClass)
+		}
+		if object.restore != "" {
+			w.Header().Set("X-Amz-Restore", object.restore)
+		}
+		if versionId != "" {
+			w.Header().Set("X-Amz-Version-Id", versionId)
+		}
+		for name, value := range object.metadata {
+			w.Header().Set("X-Amz-Meta-"+name, value)
+		}
+		if r.Method == http.MethodGet {
+			w.Write(object.body)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// error codes of bucket settings which aren't set, settings without one are empty then
+var fakeSettingNotFound = map[string]string{
+	"versioning":        "",
+	"encryption":        "ServerSideEncryptionConfigurationNotFoundError",
+	"publicAccessBlock": "NoSuchPublicAccessBlockConfiguration",
+	"policy":            "NoSuchBucketPolicy",
+	"cors":              "NoSuchCORSConfiguration",
+	"lifecycle":         "NoSuchLifecycleConfiguration",
+	"tagging":           "NoSuchTagSet",
+	"website":           "NoSuchWebsiteConfiguration",
+	"notification":      "",
+}
+
+// GET and PUT of a bucket setting, counted as e.g. "PUT policy". Settings are returned as they were put
+func (fake *fakeS3) setting(w http.ResponseWriter, r *http.Request, name string) {
+	fake.requests[r.Method+" "+name]++
+	fail := func(status int, code string) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, xml.Header+"<Error><Code>"+code+"</Code><Message>"+code+"</Message></Error>")
+	}
+	if fake.denied[name] {
+		fail(http.StatusForbidden, "AccessDenied")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		fake.settings[name], _ = ioutil.ReadAll(r.Body)
+	case http.MethodGet:
+		if body, ok := fake.settings[name]; ok {
+			w.Write(body)
+		} else if code := fakeSettingNotFound[name]; code != "" {
+			fail(http.StatusNotFound, code)
+		} else {
+			fmt.Fprint(w, xml.Header+"<Configuration></Configuration>")
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (object *fakeObject) etag() string {
+	sum := md5.Sum(object.body)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+type fakeListResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []fakeListObject
+	CommonPrefixes        []fakeListPrefix
+}
+
+type fakeListObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+	Owner        *fakeOwner `xml:",omitempty"`
+}
+
+type fakeOwner struct {
+	ID string
+}
+
+type fakeListPrefix struct {
+	Prefix string
+}
+
+func (fake *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	after := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		after = token
+	}
+	var keys []string
+	for key := range fake.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	res := &fakeListResult{}
+	seen := make(map[string]bool)
+	entries := 0
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			continue
+		}
+		if entries == fake.pageSize {
+			res.IsTruncated = true
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if !seen[common] {
+					seen[common] = true
+					entries++
+					res.CommonPrefixes = append(res.CommonPrefixes, fakeListPrefix{Prefix: common})
+				}
+				res.NextContinuationToken = key
+				continue
+			}
+		}
+		object := fake.objects[key]
+		entries++
+		listed := fakeListObject{Key: key, LastModified: object.modified.Format(time.RFC3339),
+			ETag: object.etag(), Size: len(object.body), StorageClass: "STANDARD"}
+		if query.Get("fetch-owner") == "true" {
+			listed.Owner = &fakeOwner{ID: "owner"}
+		}
+		res.Contents = append(res.Contents, listed)
+		res.NextContinuationToken = key
+	}
+	if !res.IsTruncated {
+		res.NextContinuationToken = ""
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	xml.NewEncoder(w).Encode(res)
+}
+
+// a directory for a test, the test removes it
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "crazys3-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
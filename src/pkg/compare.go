@@ -0,0 +1,196 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sort"
+	"strings"
+)
+
+const (
+	DiscrepancyMissing      = "missing"
+	DiscrepancyExtra        = "extra"
+	DiscrepancySize         = "size"
+	DiscrepancyETag         = "etag"
+	DiscrepancyStorageClass = "storage_class"
+	DiscrepancyAcl          = "acl"
+)
+
+// a difference between an object in the source bucket and its copy in the destination
+type Discrepancy struct {
+	Key    string
+	Kind   string
+	Source *S3File
+	Dest   *S3File
+	Detail string
+}
+
+// diff style line, "-" is missing in destination, "+" only exists in destination, "~" differs
+func (d *Discrepancy) String() string {
+	switch d.Kind {
+	case DiscrepancyMissing:
+		return fmt.Sprintf("- %v\t%v", d.Key, d.Detail)
+	case DiscrepancyExtra:
+		return fmt.Sprintf("+ %v\t%v", d.Key, d.Detail)
+	default:
+		return fmt.Sprintf("~ %v\t%v: %v", d.Key, d.Kind, d.Detail)
+	}
+}
+
+// Walk two listings side by side and pair up objects by their key relative to the prefix.
+// One side of a pair is nil when the key only exists in the other listing.
+// S3 lists keys in UTF-8 binary order, so the listings are merged without loading them into memory.
+// Each bucket is listed in parallel ranges if parallelism is above 1, see HandleFilesParallel
+func CompareBuckets(source *S3Manager, sourceBucket string, sourcePrefix string, dest *S3Manager, destBucket string, destPrefix string, parallelism int, handler func(src *S3File, dst *S3File) error) error {
+	done := make(chan struct{})
+	defer close(done)
+	list := func(manager *S3Manager, bucket string, prefix string) (chan *S3File, chan error) {
+		files := make(chan *S3File, 1000)
+		errs := make(chan error, 1)
+		go func() {
+			err := manager.HandleFilesParallel(bucket, prefix, parallelism, nil, func(file *S3File) error {
+				select {
+				case files <- file:
+					return nil
+				case <-done:
+					return ErrStopHandling
+				}
+			})
+			errs <- err
+			close(files)
+		}()
+		return files, errs
+	}
+	srcFiles, srcErrs := list(source, sourceBucket, sourcePrefix)
+	dstFiles, dstErrs := list(dest, destBucket, destPrefix)
+	// a closed channel only means the end of listing if the listing didn't fail
+	next := func(files chan *S3File, errs chan error) (*S3File, bool, error) {
+		file, ok := <-files
+		if ok {
+			return file, true, nil
+		}
+		return nil, false, <-errs
+	}
+	src, srcOk, err := next(srcFiles, srcErrs)
+	if err != nil {
+		return err
+	}
+	dst, dstOk, err := next(dstFiles, dstErrs)
+	if err != nil {
+		return err
+	}
+	for srcOk || dstOk {
+		var srcKey, dstKey string
+		if srcOk {
+			srcKey = strings.TrimPrefix(src.Name, sourcePrefix)
+		}
+		if dstOk {
+			dstKey = strings.TrimPrefix(dst.Name, destPrefix)
+		}
+		switch {
+		case srcOk && (!dstOk || srcKey < dstKey):
+			err = handler(src, nil)
+			if err == nil {
+				src, srcOk, err = next(srcFiles, srcErrs)
+			}
+		case dstOk && (!srcOk || dstKey < srcKey):
+			err = handler(nil, dst)
+			if err == nil {
+				dst, dstOk, err = next(dstFiles, dstErrs)
+			}
+		default:
+			err = handler(src, dst)
+			if err == nil {
+				src, srcOk, err = next(srcFiles, srcErrs)
+			}
+			if err == nil {
+				dst, dstOk, err = next(dstFiles, dstErrs)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compare two objects by their listing information. ACLs are not part of the listing, see AclEqual.
+// ETags of multipart uploads depend on the part size, so they are only compared when both are single part
+func CompareFiles(key string, src *S3File, dst *S3File) []*Discrepancy {
+	if dst == nil {
+		return []*Discrepancy{{Key: key, Kind: DiscrepancyMissing, Source: src, Detail: "missing in destination"}}
+	}
+	if src == nil {
+		return []*Discrepancy{{Key: key, Kind: DiscrepancyExtra, Dest: dst, Detail: "only in destination"}}
+	}
+	var res []*Discrepancy
+	if src.Size != dst.Size {
+		res = append(res, &Discrepancy{Key: key, Kind: DiscrepancySize, Source: src, Dest: dst,
+			Detail: fmt.Sprintf("%v -> %v", src.Size, dst.Size)})
+	}
+	if comparableETags(src, dst) && src.ETag != dst.ETag {
+		res = append(res, &Discrepancy{Key: key, Kind: DiscrepancyETag, Source: src, Dest: dst,
+			Detail: fmt.Sprintf("%v -> %v", src.ETag, dst.ETag)})
+	}
+	if src.StorageClass != dst.StorageClass {
+		res = append(res, &Discrepancy{Key: key, Kind: DiscrepancyStorageClass, Source: src, Dest: dst,
+			Detail: fmt.Sprintf("%v -> %v", src.StorageClass, dst.StorageClass)})
+	}
+	return res
+}
+
+// Whether the destination object is missing, outdated or not in storageClass, the class migration
+// writes src with. A copy is newer than its source unless the source changed. The storage class of
+// objects of key lists may be unknown, it is ignored then
+func NeedsSync(src *S3File, dst *S3File, storageClass string) bool {
+	if dst == nil || FilesDiffer(src, dst) {
+		return true
+	}
+	if storageClass != "" && dst.StorageClass != "" && dst.StorageClass != storageClass {
+		return true
+	}
+	return src.LastModified.After(dst.LastModified)
+}
+
+// Whether the content of two files differs by size or, if both are single part, by etag
+func FilesDiffer(src *S3File, dst *S3File) bool {
+	if src.Size != dst.Size {
+		return true
+	}
+	return comparableETags(src, dst) && src.ETag != dst.ETag
+}
+
+// etags of multipart objects depend on the part size, files of directories have none
+func comparableETags(src *S3File, dst *S3File) bool {
+	multipart := strings.Contains(src.ETag, "-") || strings.Contains(dst.ETag, "-")
+	return !multipart && src.ETag != "" && dst.ETag != ""
+}
+
+// Compare the grants of two acls regardless of their order. Owners are ignored,
+// because the owner of a copied object is the account which copied it
+func AclEqual(a *s3.GetObjectAclOutput, b *s3.GetObjectAclOutput) bool {
+	grants := func(acl *s3.GetObjectAclOutput) []string {
+		var res []string
+		for _, g := range acl.Grants {
+			grantee := ""
+			if g.Grantee != nil {
+				grantee = fmt.Sprintf("%v|%v|%v|%v", aws.StringValue(g.Grantee.Type), aws.StringValue(g.Grantee.ID),
+					aws.StringValue(g.Grantee.URI), aws.StringValue(g.Grantee.EmailAddress))
+			}
+			res = append(res, grantee+"|"+aws.StringValue(g.Permission))
+		}
+		sort.Strings(res)
+		return res
+	}
+	ga, gb := grants(a), grants(b)
+	if len(ga) != len(gb) {
+		return false
+	}
+	for i := range ga {
+		if ga[i] != gb[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareBuckets(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"src/p/a.txt":   "a",
+		"src/p/b/c.txt": "c",
+		"src/p/d.txt":   "d",
+		"src/other.txt": "x",
+		"dst/q/a.txt":   "a",
+		"dst/q/b.txt":   "b",
+		"dst/q/d.txt":   "dd",
+		"dst/q/e.txt":   "e",
+	} {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), content)
+	}
+	source, dest := LocalScheme+filepath.Join(dir, "src"), LocalScheme+filepath.Join(dir, "dst")
+	manager := &S3Manager{}
+	var got []string
+	err := CompareBuckets(manager, source, "p/", manager, dest, "q/", 1, func(src *S3File, dst *S3File) error {
+		switch {
+		case dst == nil:
+			got = append(got, "-"+src.Name)
+		case src == nil:
+			got = append(got, "+"+dst.Name)
+		case src.Size != dst.Size:
+			got = append(got, "~"+src.Name)
+		default:
+			got = append(got, "="+src.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"=p/a.txt", "+q/b.txt", "-p/b/c.txt", "~p/d.txt", "+q/e.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = CompareBuckets(manager, source, "p/", manager, dest, "q/", 1, func(src *S3File, dst *S3File) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("handler error: got %v after %v calls", err, calls)
+	}
+}
+
+func TestNeedsSync(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := old.Add(time.Hour)
+	file := func(size int64, modified time.Time, class string) *S3File {
+		return &S3File{Size: size, ETag: `"a"`, LastModified: modified, StorageClass: class}
+	}
+	tests := []struct {
+		name         string
+		src, dst     *S3File
+		storageClass string
+		want         bool
+	}{
+		{"missing", file(1, old, "STANDARD"), nil, "STANDARD", true},
+		{"up to date", file(1, old, "STANDARD"), file(1, newer, "STANDARD"), "STANDARD", false},
+		{"same time", file(1, old, "STANDARD"), file(1, old, "STANDARD"), "STANDARD", false},
+		{"source changed", file(1, newer, "STANDARD"), file(1, old, "STANDARD"), "STANDARD", true},
+		{"size differs", file(1, old, "STANDARD"), file(2, newer, "STANDARD"), "STANDARD", true},
+		{"other class", file(1, old, "STANDARD"), file(1, newer, "STANDARD"), "GLACIER", true},
+		{"class of source ignored", file(1, old, "GLACIER"), file(1, newer, "STANDARD"), "STANDARD", false},
+		// classes of key lists may be unknown
+		{"unknown class", file(1, old, ""), file(1, newer, ""), "GLACIER", false},
+		{"no class asked", file(1, old, "STANDARD"), file(1, newer, "GLACIER"), "", false},
+	}
+	for _, test := range tests {
+		if got := NeedsSync(test.src, test.dst, test.storageClass); got != test.want {
+			t.Errorf("%v: NeedsSync = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFilesDiffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst *S3File
+		want     bool
+	}{
+		{"equal", &S3File{Size: 1, ETag: `"a"`}, &S3File{Size: 1, ETag: `"a"`}, false},
+		{"size", &S3File{Size: 1, ETag: `"a"`}, &S3File{Size: 2, ETag: `"a"`}, true},
+		{"etag", &S3File{Size: 1, ETag: `"a"`}, &S3File{Size: 1, ETag: `"b"`}, true},
+		// etags of multipart uploads depend on the part size
+		{"multipart", &S3File{Size: 1, ETag: `"a-2"`}, &S3File{Size: 1, ETag: `"b"`}, false},
+		// files of directories have no etag
+		{"no etag", &S3File{Size: 1}, &S3File{Size: 1, ETag: `"b"`}, false},
+	}
+	for _, test := range tests {
+		if got := FilesDiffer(test.src, test.dst); got != test.want {
+			t.Errorf("%v: FilesDiffer = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
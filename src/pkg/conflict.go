@@ -0,0 +1,46 @@
+package pkg
+
+import (
+	"errors"
+)
+
+// policies of a migration when the destination file already exists
+const (
+	ConflictOverwrite            = "overwrite"
+	ConflictSkipIfExists         = "skip-if-exists"
+	ConflictOverwriteIfNewer     = "overwrite-if-newer"
+	ConflictOverwriteIfDifferent = "overwrite-if-different"
+	ConflictFail                 = "fail"
+)
+
+var ConflictPolicies = []string{ConflictOverwrite, ConflictSkipIfExists, ConflictOverwriteIfNewer, ConflictOverwriteIfDifferent, ConflictFail}
+
+var ErrDestinationExists = errors.New("destination file already exists")
+
+// Decide whether src should be copied to destBucket/destFileName under the policy. The destination is
+// checked with a HEAD request, which is skipped for the default policy ConflictOverwrite.
+// The check is best-effort: a file written to the destination between the HEAD and the copy is
+// overwritten, S3 copies cannot be made conditional on the destination
+func (manager *S3Manager) ShouldCopy(policy string, src *S3File, destBucket string, destFileName string) (bool, error) {
+	if policy == "" || policy == ConflictOverwrite {
+		return true, nil
+	}
+	dst, err := manager.GetFile(destBucket, destFileName)
+	if err != nil {
+		return false, err
+	}
+	if dst == nil {
+		return true, nil
+	}
+	switch policy {
+	case ConflictSkipIfExists:
+		return false, nil
+	case ConflictOverwriteIfNewer:
+		return src.LastModified.After(dst.LastModified), nil
+	case ConflictOverwriteIfDifferent:
+		return FilesDiffer(src, dst), nil
+	case ConflictFail:
+		return false, ErrDestinationExists
+	}
+	return false, errors.New("unknown conflict policy " + policy)
+}
@@ -0,0 +1,314 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// CopyObject cannot copy larger files, they are copied in parts
+	MaxCopySize  = 5 << 30
+	CopyPartSize = 512 << 20
+)
+
+// options of a copy, a nil value copies with STANDARD storage class and keeps metadata and tags
+type CopyOptions struct {
+	// STANDARD if empty
+	StorageClass string
+	Metadata     *MetadataOptions
+	Encryption   *EncryptionOptions
+}
+
+// per job changes of the copied metadata, everything not mentioned is preserved
+type MetadataOptions struct {
+	// user metadata added or overridden
+	Metadata map[string]string
+	// content headers overridden, e.g. Content-Type or Cache-Control
+	Headers map[string]string
+	// tags added or overridden
+	Tags map[string]string
+}
+
+var contentHeaders = []string{"Cache-Control", "Content-Disposition", "Content-Encoding", "Content-Language", "Content-Type"}
+
+func (options *MetadataOptions) IsEmpty() bool {
+	return options == nil || (len(options.Metadata) == 0 && len(options.Headers) == 0 && len(options.Tags) == 0)
+}
+
+// Parse the per job inputs, "key=value, key2=value2" each. Returns nil if nothing changes
+func NewMetadataOptions(metadata string, headers string, tags string) (*MetadataOptions, error) {
+	var err error
+	options := &MetadataOptions{}
+	if options.Metadata, err = ParseKeyValues(metadata); err != nil {
+		return nil, err
+	}
+	if options.Headers, err = ParseHeaders(headers); err != nil {
+		return nil, err
+	}
+	if options.Tags, err = ParseKeyValues(tags); err != nil {
+		return nil, err
+	}
+	if options.IsEmpty() {
+		return nil, nil
+	}
+	return options, nil
+}
+
+// Parse "key=value, key2=value2" into a map
+func ParseKeyValues(s string) (map[string]string, error) {
+	res := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.New("expected key=value, got " + pair)
+		}
+		res[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return res, nil
+}
+
+// Parse content header overrides, only the headers S3 stores with an object are accepted
+func ParseHeaders(s string) (map[string]string, error) {
+	headers, err := ParseKeyValues(s)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+	for name, value := range headers {
+		name = http.CanonicalHeaderKey(name)
+		known := false
+		for _, h := range contentHeaders {
+			known = known || h == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unsupported header %v, expected one of %v", name, contentHeaders)
+		}
+		res[name] = value
+	}
+	return res, nil
+}
+
+// headers and user metadata of an object
+type objectHeaders struct {
+	headers  map[string]*string
+	metadata map[string]*string
+}
+
+// HEAD the source of a copy, SSE-C encrypted sources need their key
+func (manager *S3Manager) headObject(file *S3File, options *EncryptionOptions) (*s3.HeadObjectOutput, error) {
+	input := &s3.HeadObjectInput{
+		Bucket:    aws.String(file.BucketName),
+		Key:       aws.String(file.Name),
+		VersionId: versionId(file),
+	}
+	if options != nil && options.SourceCustomerKey != "" {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(options.SourceCustomerKey)
+	}
+	return manager.s3cli.HeadObject(input)
+}
+
+// headers and user metadata of the source merged with the overrides
+func getHeaders(head *s3.HeadObjectOutput, options *MetadataOptions) *objectHeaders {
+	res := &objectHeaders{
+		headers: map[string]*string{
+			"Cache-Control":       head.CacheControl,
+			"Content-Disposition": head.ContentDisposition,
+			"Content-Encoding":    head.ContentEncoding,
+			"Content-Language":    head.ContentLanguage,
+			"Content-Type":        head.ContentType,
+		},
+		metadata: head.Metadata,
+	}
+	if res.metadata == nil {
+		res.metadata = make(map[string]*string)
+	}
+	if options != nil {
+		for name, value := range options.Headers {
+			res.headers[name] = aws.String(value)
+		}
+		for key, value := range options.Metadata {
+			res.metadata[key] = aws.String(value)
+		}
+	}
+	return res
+}
+
+// tags of the object merged with the overrides, url encoded as the Tagging parameter expects
+func (manager *S3Manager) getTagging(file *S3File, options *MetadataOptions) (string, error) {
+	res, err := manager.s3cli.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket:    aws.String(file.BucketName),
+		Key:       aws.String(file.Name),
+		VersionId: versionId(file),
+	})
+	if err != nil {
+		return "", err
+	}
+	tags := url.Values{}
+	for _, tag := range res.TagSet {
+		tags.Set(aws.StringValue(tag.Key), aws.StringValue(tag.Value))
+	}
+	if options != nil {
+		for key, value := range options.Tags {
+			tags.Set(key, value)
+		}
+	}
+	return tags.Encode(), nil
+}
+
+// Copy file to destBucket/destFileName. CopyObject keeps metadata and tags by itself, they are only
+// read and applied explicitly when they change or when the file is too large and copied in parts.
+// Encryption is applied the same way to single and multipart copies
+func (manager *S3Manager) copyObject(file *S3File, destBucket string, destFileName string, options *CopyOptions) error {
+	if options == nil {
+		options = &CopyOptions{}
+	}
+	storageClass := options.StorageClass
+	if storageClass == "" {
+		storageClass = "STANDARD"
+	}
+	copySource := copySourceOf(file)
+	var head *s3.HeadObjectOutput
+	if file.Size > MaxCopySize || !options.Metadata.IsEmpty() || options.Encryption.needsSource() {
+		var err error
+		head, err = manager.headObject(file, options.Encryption)
+		if err != nil {
+			return err
+		}
+	}
+	encryption := options.Encryption.resolve(head)
+	if file.Size <= MaxCopySize && options.Metadata.IsEmpty() {
+		input := &s3.CopyObjectInput{
+			Bucket:       aws.String(destBucket),
+			CopySource:   aws.String(copySource),
+			Key:          aws.String(destFileName),
+			StorageClass: aws.String(storageClass),
+		}
+		encryption.applyToCopy(input)
+		res, err := manager.s3cli.CopyObject(input)
+		if err != nil {
+			return err
+		}
+		GLogger.Debug("copied file %v to %v, res=%v", file.BucketName+"/"+file.Name, destBucket+"/"+destFileName, res)
+		return nil
+	}
+	headers := getHeaders(head, options.Metadata)
+	if file.Size <= MaxCopySize {
+		input := &s3.CopyObjectInput{
+			Bucket:             aws.String(destBucket),
+			CopySource:         aws.String(copySource),
+			Key:                aws.String(destFileName),
+			StorageClass:       aws.String(storageClass),
+			MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+			CacheControl:       headers.headers["Cache-Control"],
+			ContentDisposition: headers.headers["Content-Disposition"],
+			ContentEncoding:    headers.headers["Content-Encoding"],
+			ContentLanguage:    headers.headers["Content-Language"],
+			ContentType:        headers.headers["Content-Type"],
+			Metadata:           headers.metadata,
+		}
+		encryption.applyToCopy(input)
+		if len(options.Metadata.Tags) > 0 {
+			tagging, err := manager.getTagging(file, options.Metadata)
+			if err != nil {
+				return err
+			}
+			input.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+			input.Tagging = aws.String(tagging)
+		}
+		res, err := manager.s3cli.CopyObject(input)
+		if err != nil {
+			return err
+		}
+		GLogger.Debug("copied file %v to %v with metadata %v, res=%v", file.BucketName+"/"+file.Name, destBucket+"/"+destFileName, headers.metadata, res)
+		return nil
+	}
+
+	// multipart uploads start without metadata or tags
+	tagging, err := manager.getTagging(file, options.Metadata)
+	if err != nil {
+		return err
+	}
+	create := &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(destBucket),
+		Key:                aws.String(destFileName),
+		StorageClass:       aws.String(storageClass),
+		CacheControl:       headers.headers["Cache-Control"],
+		ContentDisposition: headers.headers["Content-Disposition"],
+		ContentEncoding:    headers.headers["Content-Encoding"],
+		ContentLanguage:    headers.headers["Content-Language"],
+		ContentType:        headers.headers["Content-Type"],
+		Metadata:           headers.metadata,
+	}
+	if tagging != "" {
+		create.Tagging = aws.String(tagging)
+	}
+	encryption.applyToCreate(create)
+	upload, err := manager.s3cli.CreateMultipartUpload(create)
+	if err != nil {
+		return err
+	}
+	var parts []*s3.CompletedPart
+	for num, start := int64(1), int64(0); start < file.Size; num, start = num+1, start+CopyPartSize {
+		end := start + CopyPartSize - 1
+		if end >= file.Size {
+			end = file.Size - 1
+		}
+		input := &s3.UploadPartCopyInput{
+			Bucket:          aws.String(destBucket),
+			Key:             aws.String(destFileName),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(num),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%v-%v", start, end)),
+		}
+		encryption.applyToPart(input)
+		part, err := manager.s3cli.UploadPartCopy(input)
+		if err != nil {
+			manager.abortUpload(destBucket, destFileName, upload.UploadId)
+			return err
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int64(num)})
+	}
+	res, err := manager.s3cli.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(destBucket),
+		Key:             aws.String(destFileName),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		manager.abortUpload(destBucket, destFileName, upload.UploadId)
+		return err
+	}
+	GLogger.Debug("copied file %v to %v in %v parts, res=%v", file.BucketName+"/"+file.Name, destBucket+"/"+destFileName, len(parts), res)
+	return nil
+}
+
+// CopySource of a file, keys are URL encoded so "?", "%", "+" and non-ASCII characters name the right object
+func copySourceOf(file *S3File) string {
+	copySource := url.PathEscape(file.BucketName + "/" + file.Name)
+	if file.VersionId != "" {
+		copySource += "?versionId=" + url.QueryEscape(file.VersionId)
+	}
+	return copySource
+}
+
+func (manager *S3Manager) abortUpload(bucket string, fileName string, uploadId *string) {
+	_, err := manager.s3cli.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(fileName),
+		UploadId: uploadId,
+	})
+	if err != nil {
+		GLogger.Warning("Exception in aborting upload of %v, reason: %v", bucket+"/"+fileName, err)
+	}
+}
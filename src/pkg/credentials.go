@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"sync"
+	"time"
+)
+
+// lifetime of credentials of assumed roles, refreshed before they expire
+const AssumeRoleDuration = time.Hour
+
+var (
+	profileCreds      = make(map[string]*credentials.Credentials)
+	profileCredsMutex sync.Mutex
+	// profiles whose token code was asked, the code is asked only once
+	mfaProfiles sync.Map
+)
+
+// Session of a profile of ~/.aws/credentials and ~/.aws/config with everything the sdk supports:
+// static keys, role_arn with source_profile or credential_source, external_id, mfa_serial
+// (the token code is asked on stdin once, nobody answers a prompt while a job runs),
+// credential_process and web_identity_token_file
+func newProfileSession(profile string, config *aws.Config) (*session.Session, error) {
+	tokenProvider := func() (string, error) {
+		if _, asked := mfaProfiles.LoadOrStore(profile, true); asked {
+			return "", fmt.Errorf("mfa token code of aws profile %v is only asked once", profile)
+		}
+		return stscreds.StdinTokenProvider()
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:                  *config,
+		Profile:                 profile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: tokenProvider,
+		AssumeRoleDuration:      AssumeRoleDuration,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot load aws profile %v, reason: %v", profile, err)
+	}
+	return sess, nil
+}
+
+// Credentials pushed by master. Workers don't have the profiles, master sends the credentials
+// with the job and again before temporary ones expire
+type PushedCredentials struct {
+	mutex   sync.Mutex
+	value   credentials.Value
+	updated bool
+	cred    *credentials.Credentials
+}
+
+func NewPushedCredentials(key, secret, token string) *PushedCredentials {
+	pushed := &PushedCredentials{}
+	pushed.cred = credentials.NewCredentials(pushed)
+	pushed.Update(key, secret, token)
+	return pushed
+}
+
+// replace the credentials, requests signed from now on use the new ones
+func (pushed *PushedCredentials) Update(key, secret, token string) {
+	pushed.mutex.Lock()
+	defer pushed.mutex.Unlock()
+	pushed.value = credentials.Value{
+		AccessKeyID:     key,
+		SecretAccessKey: secret,
+		SessionToken:    token,
+		ProviderName:    "PushedCredentials",
+	}
+	pushed.updated = true
+}
+
+func (pushed *PushedCredentials) Retrieve() (credentials.Value, error) {
+	pushed.mutex.Lock()
+	defer pushed.mutex.Unlock()
+	pushed.updated = false
+	if pushed.value.AccessKeyID == "" || pushed.value.SecretAccessKey == "" {
+		return pushed.value, errors.New("no credentials received from master")
+	}
+	return pushed.value, nil
+}
+
+// the credentials are retrieved again after an update
+func (pushed *PushedCredentials) IsExpired() bool {
+	pushed.mutex.Lock()
+	defer pushed.mutex.Unlock()
+	return pushed.updated
+}
+
+// Credentials of the manager, temporary ones include a session token. Errors tell which profile
+// couldn't be loaded and why
+func (manager *S3Manager) GetCredential() (credentials.Value, error) {
+	val, err := manager.cred.Get()
+	if err != nil && manager.profile != "" {
+		return val, fmt.Errorf("cannot load credentials of aws profile %v, reason: %v", manager.profile, err)
+	}
+	return val, err
+}
+
+// whether the credentials were assumed with an mfa token code, they can't be renewed without asking again
+func (manager *S3Manager) NeedsMFA() bool {
+	_, asked := mfaProfiles.Load(manager.profile)
+	return asked
+}
+
+// Credentials renewed if they expire within window, ok is false if they were not renewed.
+// Static credentials never expire, the ones of NeedsMFA are not renewed
+func (manager *S3Manager) RefreshCredential(window time.Duration) (val credentials.Value, ok bool, err error) {
+	if manager.NeedsMFA() {
+		return val, false, nil
+	}
+	expires, err := manager.cred.ExpiresAt()
+	if err != nil || time.Until(expires) > window {
+		return val, false, nil
+	}
+	manager.cred.Expire()
+	val, err = manager.GetCredential()
+	return val, err == nil, err
+}
@@ -0,0 +1,184 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	DiscrepancyMetadata = "metadata"
+	// pairs looked up at the same time to compare metadata
+	diffHeadThreads = 32
+)
+
+// Columns of a diff report. Rows are key list entries of the source object, or of the destination
+// object for keys only in the destination, so a report can be the object list of a migration
+var diffColumns = []string{"bucket", "key", "size", "storage_class", "difference", "detail"}
+
+type diffItem struct {
+	key           string
+	src, dst      *S3File
+	discrepancies []*Discrepancy
+	ready         chan struct{}
+}
+
+// Writes the differences of two buckets in the order they are compared, see CompareBuckets.
+// With metadata, objects whose listings are equal are looked up with HEAD in both buckets
+type DiffReport struct {
+	source   *S3Manager
+	dest     *S3Manager
+	metadata bool
+	writer   *csv.Writer
+	queue    chan *diffItem
+	threads  chan struct{}
+	done     chan struct{}
+	failed   int32
+	// set before done is closed
+	err error
+	// keys written by kind of discrepancy, keys with several discrepancies are counted for each
+	Counts map[string]int64
+	Keys   int64
+}
+
+func NewDiffReport(w io.Writer, source *S3Manager, dest *S3Manager, metadata bool) *DiffReport {
+	report := &DiffReport{
+		source:   source,
+		dest:     dest,
+		metadata: metadata,
+		writer:   csv.NewWriter(w),
+		queue:    make(chan *diffItem, 1000),
+		threads:  make(chan struct{}, diffHeadThreads),
+		done:     make(chan struct{}),
+		Counts:   make(map[string]int64),
+	}
+	go report.write()
+	return report
+}
+
+// Compare a pair of objects of CompareBuckets or CompareKeyList, key is relative to the prefixes.
+// Returns ErrStopHandling once writing failed, Close returns the reason
+func (report *DiffReport) Compare(key string, src *S3File, dst *S3File) error {
+	if atomic.LoadInt32(&report.failed) != 0 {
+		return ErrStopHandling
+	}
+	item := &diffItem{
+		key:           key,
+		src:           src,
+		dst:           dst,
+		discrepancies: CompareFiles(key, src, dst),
+		ready:         make(chan struct{}),
+	}
+	if report.metadata && src != nil && dst != nil && len(item.discrepancies) == 0 && !IsLocal(src.BucketName) && !IsLocal(dst.BucketName) {
+		report.threads <- struct{}{}
+		go func() {
+			defer func() { <-report.threads }()
+			item.discrepancies = report.compareMetadata(item)
+			close(item.ready)
+		}()
+	} else {
+		close(item.ready)
+	}
+	report.queue <- item
+	return nil
+}
+
+// objects which cannot be looked up are reported as differing
+func (report *DiffReport) compareMetadata(item *diffItem) []*Discrepancy {
+	discrepancy := func(detail string) []*Discrepancy {
+		return []*Discrepancy{{Key: item.key, Kind: DiscrepancyMetadata, Source: item.src, Dest: item.dst, Detail: detail}}
+	}
+	srcHead, err := report.source.headObject(item.src, nil)
+	if err != nil {
+		return discrepancy(fmt.Sprintf("cannot get metadata of source, reason: %v", err))
+	}
+	dstHead, err := report.dest.headObject(item.dst, nil)
+	if err != nil {
+		return discrepancy(fmt.Sprintf("cannot get metadata of destination, reason: %v", err))
+	}
+	if diffs := metadataDiff(srcHead, dstHead); len(diffs) > 0 {
+		return discrepancy(strings.Join(diffs, ", "))
+	}
+	return nil
+}
+
+// differences of headers and user metadata, e.g. "Content-Type: text/plain -> text/html"
+func metadataDiff(src *s3.HeadObjectOutput, dst *s3.HeadObjectOutput) []string {
+	a, b := getHeaders(src, nil), getHeaders(dst, nil)
+	var res []string
+	compare := func(names map[string]bool, from map[string]*string, to map[string]*string, prefix string) {
+		var sorted []string
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+		for _, name := range sorted {
+			if v1, v2 := aws.StringValue(from[name]), aws.StringValue(to[name]); v1 != v2 {
+				res = append(res, fmt.Sprintf("%v%v: %v -> %v", prefix, name, v1, v2))
+			}
+		}
+	}
+	headers := make(map[string]bool)
+	for _, m := range []map[string]*string{a.headers, b.headers} {
+		for name := range m {
+			headers[name] = true
+		}
+	}
+	compare(headers, a.headers, b.headers, "")
+	// metadata keys are canonicalized by the SDK, the modification time of uploaded files differs anyway
+	metadata := make(map[string]bool)
+	for _, m := range []map[string]*string{a.metadata, b.metadata} {
+		for key := range m {
+			if !strings.EqualFold(key, MtimeMetadata) {
+				metadata[key] = true
+			}
+		}
+	}
+	compare(metadata, a.metadata, b.metadata, "x-amz-meta-")
+	return res
+}
+
+// Write the remaining differences
+func (report *DiffReport) Close() error {
+	close(report.queue)
+	<-report.done
+	return report.err
+}
+
+func (report *DiffReport) write() {
+	defer close(report.done)
+	report.err = report.writer.Write(diffColumns)
+	for item := range report.queue {
+		<-item.ready
+		if report.err != nil || len(item.discrepancies) == 0 {
+			continue
+		}
+		// one row per key, so a key is migrated once
+		file := item.src
+		if file == nil {
+			file = item.dst
+		}
+		var kinds, details []string
+		for _, d := range item.discrepancies {
+			kinds = append(kinds, d.Kind)
+			details = append(details, d.Detail)
+			report.Counts[d.Kind]++
+		}
+		report.Keys++
+		report.writer.Write([]string{file.BucketName, file.Name, strconv.FormatInt(file.Size, 10), file.StorageClass,
+			strings.Join(kinds, ";"), strings.Join(details, "; ")})
+		if report.err = report.writer.Error(); report.err != nil {
+			atomic.StoreInt32(&report.failed, 1)
+		}
+	}
+	report.writer.Flush()
+	if report.err == nil {
+		report.err = report.writer.Error()
+	}
+}
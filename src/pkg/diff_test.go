@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"reflect"
+	"testing"
+)
+
+func TestMetadataDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		src, dst *s3.HeadObjectOutput
+		want     []string
+	}{
+		{"equal",
+			&s3.HeadObjectOutput{ContentType: aws.String("text/plain"), Metadata: map[string]*string{"Key": aws.String("v")}},
+			&s3.HeadObjectOutput{ContentType: aws.String("text/plain"), Metadata: map[string]*string{"Key": aws.String("v")}},
+			nil},
+		{"changed",
+			&s3.HeadObjectOutput{ContentType: aws.String("text/plain"), Metadata: map[string]*string{"Key": aws.String("v1")}},
+			&s3.HeadObjectOutput{ContentType: aws.String("text/html"), Metadata: map[string]*string{"Key": aws.String("v2")}},
+			[]string{"Content-Type: text/plain -> text/html", "x-amz-meta-Key: v1 -> v2"}},
+		{"only on the source",
+			&s3.HeadObjectOutput{CacheControl: aws.String("no-cache"), Metadata: map[string]*string{"Key": aws.String("v")}},
+			&s3.HeadObjectOutput{},
+			[]string{"Cache-Control: no-cache -> ", "x-amz-meta-Key: v -> "}},
+		{"only on the destination",
+			&s3.HeadObjectOutput{},
+			&s3.HeadObjectOutput{ContentEncoding: aws.String("gzip"), Metadata: map[string]*string{"B": aws.String("2"), "A": aws.String("1")}},
+			[]string{"Content-Encoding:  -> gzip", "x-amz-meta-A:  -> 1", "x-amz-meta-B:  -> 2"}},
+		{"modification time",
+			&s3.HeadObjectOutput{Metadata: map[string]*string{MtimeMetadata: aws.String("1")}},
+			&s3.HeadObjectOutput{Metadata: map[string]*string{MtimeMetadata: aws.String("2")}},
+			nil},
+	}
+	for _, test := range tests {
+		if got := metadataDiff(test.src, test.dst); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
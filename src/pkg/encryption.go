@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// server side encryption of copies, the empty mode leaves it to the default encryption of the bucket
+const (
+	EncryptionPreserve = "preserve"
+	EncryptionSSES3    = "SSE-S3"
+	EncryptionSSEKMS   = "SSE-KMS"
+	EncryptionSSEC     = "SSE-C"
+)
+
+var EncryptionModes = []string{"", EncryptionPreserve, EncryptionSSES3, EncryptionSSEKMS, EncryptionSSEC}
+
+type EncryptionOptions struct {
+	Mode string
+	// for SSE-KMS, the aws managed key if empty
+	KMSKeyId string
+	// raw 256 bit keys of SSE-C encrypted sources and of SSE-C copies
+	SourceCustomerKey string
+	CustomerKey       string
+}
+
+// encryption parameters of a single copy
+type encryption struct {
+	sse               *string
+	kmsKeyId          *string
+	customerKey       *string
+	sourceCustomerKey *string
+}
+
+// Validate the per job inputs, customer keys are base64 encoded. Returns nil if nothing is set
+func NewEncryptionOptions(mode string, kmsKeyId string, sourceCustomerKey string, customerKey string) (*EncryptionOptions, error) {
+	options := &EncryptionOptions{Mode: mode, KMSKeyId: kmsKeyId}
+	known := false
+	for _, m := range EncryptionModes {
+		known = known || m == mode
+	}
+	if !known {
+		return nil, errors.New("unknown encryption " + mode)
+	}
+	var err error
+	if options.SourceCustomerKey, err = decodeCustomerKey(sourceCustomerKey); err != nil {
+		return nil, err
+	}
+	if options.CustomerKey, err = decodeCustomerKey(customerKey); err != nil {
+		return nil, err
+	}
+	if mode == EncryptionSSEC && options.CustomerKey == "" {
+		return nil, errors.New("SSE-C needs a customer key")
+	}
+	if mode == "" && options.SourceCustomerKey == "" {
+		return nil, nil
+	}
+	return options, nil
+}
+
+func decodeCustomerKey(key string) (string, error) {
+	if key == "" {
+		return "", nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) != 32 {
+		return "", errors.New("SSE-C key should be 256 bits")
+	}
+	return string(raw), nil
+}
+
+// whether the source has to be read to decide the encryption
+func (options *EncryptionOptions) needsSource() bool {
+	return options != nil && options.Mode == EncryptionPreserve
+}
+
+// parameters of a copy of the source described by head, which is only needed to preserve encryption
+func (options *EncryptionOptions) resolve(head *s3.HeadObjectOutput) *encryption {
+	res := &encryption{}
+	if options == nil {
+		return res
+	}
+	if options.SourceCustomerKey != "" {
+		res.sourceCustomerKey = aws.String(options.SourceCustomerKey)
+	}
+	customerKey := func(key string) {
+		if key != "" {
+			res.customerKey = aws.String(key)
+		}
+	}
+	switch options.Mode {
+	case EncryptionSSES3:
+		res.sse = aws.String(s3.ServerSideEncryptionAes256)
+	case EncryptionSSEKMS:
+		res.sse = aws.String(s3.ServerSideEncryptionAwsKms)
+		if options.KMSKeyId != "" {
+			res.kmsKeyId = aws.String(options.KMSKeyId)
+		}
+	case EncryptionSSEC:
+		customerKey(options.CustomerKey)
+	case EncryptionPreserve:
+		switch {
+		case head.SSECustomerAlgorithm != nil:
+			// keep the source key unless another one is given
+			customerKey(options.SourceCustomerKey)
+			customerKey(options.CustomerKey)
+		case aws.StringValue(head.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms:
+			res.sse = head.ServerSideEncryption
+			res.kmsKeyId = head.SSEKMSKeyId
+		case head.ServerSideEncryption != nil:
+			res.sse = head.ServerSideEncryption
+		}
+	}
+	return res
+}
+
+func (e *encryption) applyToCopy(input *s3.CopyObjectInput) {
+	input.ServerSideEncryption = e.sse
+	input.SSEKMSKeyId = e.kmsKeyId
+	if e.customerKey != nil {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = e.customerKey
+	}
+	if e.sourceCustomerKey != nil {
+		input.CopySourceSSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.CopySourceSSECustomerKey = e.sourceCustomerKey
+	}
+}
+
+func (e *encryption) applyToCreate(input *s3.CreateMultipartUploadInput) {
+	input.ServerSideEncryption = e.sse
+	input.SSEKMSKeyId = e.kmsKeyId
+	if e.customerKey != nil {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = e.customerKey
+	}
+}
+
+func (e *encryption) applyToUpload(input *s3manager.UploadInput) {
+	input.ServerSideEncryption = e.sse
+	input.SSEKMSKeyId = e.kmsKeyId
+	if e.customerKey != nil {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = e.customerKey
+	}
+}
+
+func (e *encryption) applyToPart(input *s3.UploadPartCopyInput) {
+	if e.customerKey != nil {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = e.customerKey
+	}
+	if e.sourceCustomerKey != nil {
+		input.CopySourceSSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.CopySourceSSECustomerKey = e.sourceCustomerKey
+	}
+}
@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"io/ioutil"
+	"net/http"
+)
+
+// S3 compatible service such as MinIO or Ceph RGW, a nil endpoint is AWS
+type Endpoint struct {
+	// e.g. https://minio.example.com:9000
+	URL string `json:"url"`
+	// most S3 compatible services don't support virtual hosted buckets
+	PathStyle bool `json:"path_style"`
+	// pem file of the CA signing the endpoint's certificate
+	CAFile string `json:"ca_file"`
+	// skip certificate verification, for testing only
+	Insecure bool `json:"insecure"`
+	// content of CAFile, loaded on master so workers don't need the file
+	CACert string `json:"-"`
+}
+
+// read the CA file, if any
+func (endpoint *Endpoint) load() error {
+	if endpoint == nil || endpoint.CAFile == "" {
+		return nil
+	}
+	cert, err := ioutil.ReadFile(endpoint.CAFile)
+	if err != nil {
+		return err
+	}
+	endpoint.CACert = string(cert)
+	return nil
+}
+
+func (endpoint *Endpoint) apply(config *aws.Config) error {
+	if endpoint == nil || endpoint.URL == "" {
+		return nil
+	}
+	config.Endpoint = aws.String(endpoint.URL)
+	config.S3ForcePathStyle = aws.Bool(endpoint.PathStyle)
+	if endpoint.CACert == "" && !endpoint.Insecure {
+		return nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: endpoint.Insecure}
+	if endpoint.Insecure {
+		GLogger.Warning("certificate of %v is not verified", endpoint.URL)
+	}
+	if endpoint.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(endpoint.CACert)) {
+			return errors.New("no certificate found in " + endpoint.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	config.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	return nil
+}
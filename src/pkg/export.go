@@ -0,0 +1,346 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ExportCSV     = "CSV"
+	ExportJSONL   = "JSONL"
+	ExportParquet = "PARQUET"
+	// objects looked up at the same time for details
+	exportHeadThreads = 32
+)
+
+// A listed object as exported. The columns and fields of key lists come first, so exports can be
+// used as key lists, see KeyListEntry
+type ExportEntry struct {
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	VersionId    string    `json:"version_id,omitempty"`
+	Size         int64     `json:"size"`
+	StorageClass string    `json:"storage_class"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+	// only exported with details
+	Restore     string            `json:"restore,omitempty"`
+	Encryption  string            `json:"encryption,omitempty"`
+	KmsKeyId    string            `json:"kms_key_id,omitempty"`
+	ContentType string            `json:"content_type,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// why the details couldn't be looked up, the detail columns are empty then
+	DetailsError string `json:"details_error,omitempty"`
+}
+
+var exportColumns = []string{"bucket", "key", "version_id", "size", "storage_class", "etag", "last_modified",
+	"restore", "encryption", "kms_key_id", "content_type", "metadata", "details_error"}
+
+// Parquet types of the columns, sizes and times are numbers
+var exportParquetColumns = func() []parquetColumn {
+	columns := make([]parquetColumn, len(exportColumns))
+	for i, name := range exportColumns {
+		columns[i] = parquetColumn{name: name, physicalType: parquetByteArray, convertedType: parquetUTF8}
+		switch name {
+		case "size":
+			columns[i].physicalType, columns[i].convertedType = parquetInt64, -1
+		case "last_modified":
+			columns[i].physicalType, columns[i].convertedType = parquetInt64, parquetTimestampMillis
+		}
+	}
+	return columns
+}()
+
+func (entry *ExportEntry) metadata() string {
+	if len(entry.Metadata) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(entry.Metadata)
+	return string(b)
+}
+
+func (entry *ExportEntry) record() []string {
+	return []string{entry.Bucket, entry.Key, entry.VersionId, strconv.FormatInt(entry.Size, 10), entry.StorageClass,
+		entry.ETag, entry.LastModified.UTC().Format(time.RFC3339), entry.Restore, entry.Encryption, entry.KmsKeyId,
+		entry.ContentType, entry.metadata(), entry.DetailsError}
+}
+
+// values of the Parquet columns, see exportParquetColumns
+func (entry *ExportEntry) row() []interface{} {
+	return []interface{}{entry.Bucket, entry.Key, entry.VersionId, entry.Size, entry.StorageClass,
+		entry.ETag, entry.LastModified.UnixNano() / int64(time.Millisecond), entry.Restore, entry.Encryption, entry.KmsKeyId,
+		entry.ContentType, entry.metadata(), entry.DetailsError}
+}
+
+type ExportOptions struct {
+	// ExportCSV, ExportJSONL or ExportParquet
+	Format string
+	// HEAD every object for its restore status, encryption, content type and metadata
+	Details bool
+	// rows per file, a single file if 0
+	ChunkSize int64
+	// local path or s3://bucket/prefix the files are named after, e.g. export-00000.csv
+	Destination string
+}
+
+type exportItem struct {
+	file  *S3File
+	entry *ExportEntry
+	// closed once entry has its details
+	ready chan struct{}
+}
+
+// Writes exported objects in the order they are given into files of ExportOptions.ChunkSize rows.
+// Files are written to a temporary file and renamed or uploaded once they are complete, so
+// files with the final names are always complete
+type Exporter struct {
+	manager *S3Manager
+	options *ExportOptions
+	// client and key prefix of a bucket destination, nil for local files
+	cli     *s3.S3
+	bucket  string
+	prefix  string
+	queue   chan *exportItem
+	threads chan struct{}
+	done    chan struct{}
+	failed  int32
+	// set by the writer before done is closed
+	err error
+	// the chunk being written
+	chunk         int
+	rows          int64
+	file          *os.File
+	csvWriter     *csv.Writer
+	jsonWriter    *json.Encoder
+	parquetWriter *parquetWriter
+	// files written so far and their rows
+	Files []string
+	Rows  int64
+}
+
+func (manager *S3Manager) NewExporter(options *ExportOptions) (*Exporter, error) {
+	if options.Format != ExportCSV && options.Format != ExportJSONL && options.Format != ExportParquet {
+		return nil, errors.New("unsupported export format " + options.Format + ", only CSV, JSONL and PARQUET can be written")
+	}
+	exporter := &Exporter{
+		manager: manager,
+		options: options,
+		queue:   make(chan *exportItem, 1000),
+		threads: make(chan struct{}, exportHeadThreads),
+		done:    make(chan struct{}),
+	}
+	if strings.HasPrefix(options.Destination, "s3://") {
+		parts := strings.SplitN(strings.TrimPrefix(options.Destination, "s3://"), "/", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.New("invalid destination " + options.Destination + ", expected s3://bucket/prefix")
+		}
+		cli, err := manager.bucketClient(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		exporter.cli, exporter.bucket, exporter.prefix = cli, parts[0], parts[1]
+	}
+	go exporter.write()
+	return exporter, nil
+}
+
+// Queue file for export, returns ErrStopHandling once writing failed, Close returns the reason
+func (exporter *Exporter) Export(file *S3File) error {
+	if atomic.LoadInt32(&exporter.failed) != 0 {
+		return ErrStopHandling
+	}
+	item := &exportItem{
+		file: file,
+		entry: &ExportEntry{
+			Bucket:       file.BucketName,
+			Key:          file.Name,
+			VersionId:    file.VersionId,
+			Size:         file.Size,
+			StorageClass: file.StorageClass,
+			ETag:         file.ETag,
+			LastModified: file.LastModified,
+		},
+		ready: make(chan struct{}),
+	}
+	if exporter.options.Details && !IsLocal(file.BucketName) {
+		exporter.threads <- struct{}{}
+		go func() {
+			defer func() { <-exporter.threads }()
+			exporter.addDetails(item)
+			close(item.ready)
+		}()
+	} else {
+		close(item.ready)
+	}
+	exporter.queue <- item
+	return nil
+}
+
+// objects which cannot be looked up are exported with the reason instead of details
+func (exporter *Exporter) addDetails(item *exportItem) {
+	head, err := exporter.manager.headObject(item.file, nil)
+	if err != nil {
+		GLogger.Warning("Exception in getting details of %v, reason: %v", item.file.BucketName+"/"+item.file.Name, err)
+		item.entry.DetailsError = err.Error()
+		return
+	}
+	item.entry.Restore = aws.StringValue(head.Restore)
+	item.entry.Encryption = aws.StringValue(head.ServerSideEncryption)
+	item.entry.KmsKeyId = aws.StringValue(head.SSEKMSKeyId)
+	item.entry.ContentType = aws.StringValue(head.ContentType)
+	if len(head.Metadata) > 0 {
+		item.entry.Metadata = aws.StringValueMap(head.Metadata)
+	}
+}
+
+// Write the remaining objects and complete the last file
+func (exporter *Exporter) Close() error {
+	close(exporter.queue)
+	<-exporter.done
+	return exporter.err
+}
+
+func (exporter *Exporter) write() {
+	defer close(exporter.done)
+	for item := range exporter.queue {
+		<-item.ready
+		if exporter.err != nil {
+			continue
+		}
+		if exporter.err = exporter.writeEntry(item.entry); exporter.err != nil {
+			atomic.StoreInt32(&exporter.failed, 1)
+		}
+	}
+	if exporter.err == nil {
+		exporter.err = exporter.closeChunk()
+	} else if exporter.file != nil {
+		exporter.file.Close()
+		os.Remove(exporter.file.Name())
+	}
+}
+
+func (exporter *Exporter) writeEntry(entry *ExportEntry) error {
+	if exporter.file == nil {
+		if err := exporter.openChunk(); err != nil {
+			return err
+		}
+	}
+	var err error
+	switch {
+	case exporter.csvWriter != nil:
+		err = exporter.csvWriter.Write(entry.record())
+	case exporter.parquetWriter != nil:
+		err = exporter.parquetWriter.Write(entry.row())
+	default:
+		err = exporter.jsonWriter.Encode(entry)
+	}
+	if err != nil {
+		return err
+	}
+	exporter.rows++
+	exporter.Rows++
+	if exporter.options.ChunkSize > 0 && exporter.rows >= exporter.options.ChunkSize {
+		return exporter.closeChunk()
+	}
+	return nil
+}
+
+// name of the current chunk, a local path or a key of the destination bucket
+func (exporter *Exporter) chunkName() string {
+	name := exporter.options.Destination
+	if exporter.cli != nil {
+		name = exporter.prefix
+	}
+	return fmt.Sprintf("%v-%05d.%v", name, exporter.chunk, strings.ToLower(exporter.options.Format))
+}
+
+// temporary files of local chunks are next to them, so they can be renamed
+func (exporter *Exporter) openChunk() error {
+	var err error
+	if exporter.cli != nil {
+		exporter.file, err = ioutil.TempFile("", "crazys3-export-")
+	} else {
+		name := exporter.chunkName()
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err == nil {
+			exporter.file, err = ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+"-")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	exporter.rows = 0
+	exporter.csvWriter, exporter.jsonWriter, exporter.parquetWriter = nil, nil, nil
+	switch exporter.options.Format {
+	case ExportCSV:
+		exporter.csvWriter = csv.NewWriter(exporter.file)
+		return exporter.csvWriter.Write(exportColumns)
+	case ExportParquet:
+		exporter.parquetWriter, err = newParquetWriter(exporter.file, exportParquetColumns)
+		return err
+	}
+	exporter.jsonWriter = json.NewEncoder(exporter.file)
+	return nil
+}
+
+func (exporter *Exporter) closeChunk() error {
+	if exporter.file == nil {
+		return nil
+	}
+	var err error
+	switch {
+	case exporter.csvWriter != nil:
+		exporter.csvWriter.Flush()
+		err = exporter.csvWriter.Error()
+	case exporter.parquetWriter != nil:
+		err = exporter.parquetWriter.Close()
+	}
+	if e := exporter.file.Close(); err == nil {
+		err = e
+	}
+	temp := exporter.file.Name()
+	exporter.file = nil
+	// nothing left to remove once it is renamed
+	defer os.Remove(temp)
+	name := exporter.chunkName()
+	if err == nil {
+		if exporter.cli != nil {
+			err = exporter.upload(temp, name)
+			name = "s3://" + exporter.bucket + "/" + name
+		} else {
+			err = os.Rename(temp, name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	GLogger.Info("exported %v objects to %v", exporter.rows, name)
+	exporter.Files = append(exporter.Files, name)
+	exporter.chunk++
+	return nil
+}
+
+func (exporter *Exporter) upload(path string, key string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = s3manager.NewUploaderWithClient(exporter.cli).Upload(&s3manager.UploadInput{
+		Bucket: aws.String(exporter.bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	return err
+}
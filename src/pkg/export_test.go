@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// files with sizes 0 to count-1
+func exportFiles(count int) []*S3File {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var files []*S3File
+	for i := 0; i < count; i++ {
+		files = append(files, &S3File{BucketName: "bucket", Name: "key," + strconv.Itoa(i), VersionId: "v" + strconv.Itoa(i),
+			Size: int64(i), StorageClass: "STANDARD", ETag: "\"etag\"", LastModified: modified.Add(time.Duration(i) * time.Second)})
+	}
+	return files
+}
+
+func export(t *testing.T, manager *S3Manager, options *ExportOptions, files []*S3File) *Exporter {
+	exporter, err := manager.NewExporter(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if err := exporter.Export(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return exporter
+}
+
+// entries of an exported file with the columns of key lists and the detail error
+func readExport(t *testing.T, format string, path string) []ExportEntry {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []ExportEntry
+	switch format {
+	case ExportCSV:
+		records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(records[0], exportColumns) {
+			t.Errorf("%v has the header %v", path, records[0])
+		}
+		for _, record := range records[1:] {
+			entry := ExportEntry{Bucket: record[0], Key: record[1], VersionId: record[2], StorageClass: record[4],
+				ETag: record[5], DetailsError: record[12]}
+			entry.Size, _ = strconv.ParseInt(record[3], 10, 64)
+			entry.LastModified, _ = time.Parse(time.RFC3339, record[6])
+			entries = append(entries, entry)
+		}
+	case ExportJSONL:
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			var entry ExportEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				t.Fatalf("%v: %v", path, err)
+			}
+			entries = append(entries, entry)
+		}
+	case ExportParquet:
+		// the footer length and the magic end the file, the footer has the row count
+		if !bytes.HasPrefix(b, []byte(parquetMagic)) || !bytes.HasSuffix(b, []byte(parquetMagic)) {
+			t.Fatalf("%v has no Parquet magic", path)
+		}
+		length := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+		if length <= 0 || length > len(b)-12 {
+			t.Fatalf("%v has a footer of %v bytes", path, length)
+		}
+		reader := &thriftReader{b: b[len(b)-8-length : len(b)-8]}
+		rows := reader.readStruct().i64(3)
+		if reader.err != nil {
+			t.Fatalf("%v: %v", path, reader.err)
+		}
+		err := readParquet(bytes.NewReader(b), int64(len(b)), exportColumns, func(row map[string]interface{}) bool {
+			entry := ExportEntry{}
+			entry.Bucket, _ = row["bucket"].(string)
+			entry.Key, _ = row["key"].(string)
+			entry.VersionId, _ = row["version_id"].(string)
+			entry.Size, _ = row["size"].(int64)
+			entry.StorageClass, _ = row["storage_class"].(string)
+			entry.ETag, _ = row["etag"].(string)
+			entry.LastModified, _ = row["last_modified"].(time.Time)
+			entry.DetailsError, _ = row["details_error"].(string)
+			entries = append(entries, entry)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		if rows != int64(len(entries)) {
+			t.Errorf("%v has %v rows in the footer and %v rows", path, rows, len(entries))
+		}
+	}
+	return entries
+}
+
+func TestExport(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	files := exportFiles(5)
+	var want []ExportEntry
+	for _, file := range files {
+		want = append(want, ExportEntry{Bucket: file.BucketName, Key: file.Name, VersionId: file.VersionId, Size: file.Size,
+			StorageClass: file.StorageClass, ETag: file.ETag, LastModified: file.LastModified})
+	}
+	tests := []struct {
+		format    string
+		chunkSize int64
+		// rows of the files
+		chunks []int
+	}{
+		{ExportCSV, 0, []int{5}},
+		{ExportCSV, 2, []int{2, 2, 1}},
+		{ExportJSONL, 0, []int{5}},
+		{ExportJSONL, 5, []int{5}},
+		{ExportParquet, 0, []int{5}},
+		{ExportParquet, 3, []int{3, 2}},
+	}
+	for _, test := range tests {
+		destination := filepath.Join(dir, test.format+strconv.FormatInt(test.chunkSize, 10), "export")
+		exporter := export(t, &S3Manager{}, &ExportOptions{Format: test.format, ChunkSize: test.chunkSize, Destination: destination}, files)
+		if exporter.Rows != int64(len(files)) || len(exporter.Files) != len(test.chunks) {
+			t.Errorf("%v in chunks of %v: exported %v rows to %v", test.format, test.chunkSize, exporter.Rows, exporter.Files)
+			continue
+		}
+		var entries []ExportEntry
+		for i, path := range exporter.Files {
+			if want := destination + "-0000" + strconv.Itoa(i) + "." + strings.ToLower(test.format); path != want {
+				t.Errorf("%v: file %v is %v, want %v", test.format, i, path, want)
+			}
+			chunk := readExport(t, test.format, path)
+			if len(chunk) != test.chunks[i] {
+				t.Errorf("%v: %v has %v rows, want %v", test.format, path, len(chunk), test.chunks[i])
+			}
+			entries = append(entries, chunk...)
+		}
+		if !reflect.DeepEqual(entries, want) {
+			t.Errorf("%v in chunks of %v: read %+v, want %+v", test.format, test.chunkSize, entries, want)
+		}
+		// temporary files were renamed
+		names, _ := filepath.Glob(filepath.Join(filepath.Dir(destination), "*"))
+		hidden, _ := filepath.Glob(filepath.Join(filepath.Dir(destination), ".*"))
+		if len(names) != len(test.chunks) || len(hidden) != 0 {
+			t.Errorf("%v: files %v left in the destination", test.format, append(names, hidden...))
+		}
+	}
+
+	if _, err := (&S3Manager{}).NewExporter(&ExportOptions{Format: "XML", Destination: dir}); err == nil {
+		t.Error("created an exporter of XML")
+	}
+}
+
+func TestExportDetails(t *testing.T) {
+	fake := newFakeS3()
+	defer fake.server.Close()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fake.put("a", "body", modified)
+	fake.objects["a"].metadata["Color"] = "red"
+	fake.objects["a"].restore = `ongoing-request="true"`
+	files := []*S3File{
+		{BucketName: "bucket", Name: "a", Size: 4, LastModified: modified},
+		{BucketName: "bucket", Name: "missing", Size: 1, LastModified: modified},
+	}
+	// uploaded to the bucket by a client of the endpoint
+	manager := fake.manager()
+	manager.endpoint = &Endpoint{URL: fake.server.URL, PathStyle: true}
+	exporter := export(t, manager, &ExportOptions{Format: ExportJSONL, Details: true, Destination: "s3://bucket/exports/list"}, files)
+	if !reflect.DeepEqual(exporter.Files, []string{"s3://bucket/exports/list-00000.jsonl"}) {
+		t.Fatalf("exported to %v", exporter.Files)
+	}
+	object := fake.objects["exports/list-00000.jsonl"]
+	if object == nil {
+		t.Fatal("nothing uploaded")
+	}
+	var entries []ExportEntry
+	for _, line := range bytes.Split(bytes.TrimSpace(object.body), []byte("\n")) {
+		var entry ExportEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("exported %v entries", len(entries))
+	}
+	if entries[0].Restore != `ongoing-request="true"` || !reflect.DeepEqual(entries[0].Metadata, map[string]string{"Color": "red"}) || entries[0].DetailsError != "" {
+		t.Errorf("got details %+v", entries[0])
+	}
+	// a failed lookup is exported with the reason
+	if entries[1].Key != "missing" || entries[1].DetailsError == "" || entries[1].Restore != "" {
+		t.Errorf("got details %+v of a missing object", entries[1])
+	}
+	var keys []string
+	for key := range fake.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"a", "exports/list-00000.jsonl"}) {
+		t.Errorf("objects %v after the export", keys)
+	}
+}
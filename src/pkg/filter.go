@@ -0,0 +1,35 @@
+package pkg
+
+import (
+	"regexp"
+	"time"
+)
+
+// select files of a listing, zero values don't filter
+type FileFilter struct {
+	// only files last modified before now minus OlderThan
+	OlderThan time.Duration
+	// only files whose key matches
+	KeyPattern *regexp.Regexp
+	// skip files in one of these storage classes
+	ExcludeStorageClasses []string
+}
+
+// a nil filter matches every file
+func (filter *FileFilter) Match(file *S3File) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.OlderThan > 0 && file.LastModified.After(time.Now().Add(-filter.OlderThan)) {
+		return false
+	}
+	if filter.KeyPattern != nil && !filter.KeyPattern.MatchString(file.Name) {
+		return false
+	}
+	for _, class := range filter.ExcludeStorageClasses {
+		if file.StorageClass == class {
+			return false
+		}
+	}
+	return true
+}
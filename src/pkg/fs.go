@@ -0,0 +1,306 @@
+package pkg
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A directory used in place of a bucket, e.g. file:///mnt/data. Keys are paths relative to the
+// directory with "/" separators. The directory has to be mounted at the same path on master
+// and on every worker, e.g. over NFS
+const LocalScheme = "file://"
+
+// user metadata of uploaded files keeping their modification time, in seconds since epoch
+// like rclone stores it
+const MtimeMetadata = "Mtime"
+
+// returned by transfers from or to a directory if the destination is already up to date
+var ErrUnchanged = errors.New("destination is up to date")
+
+func IsLocal(bucket string) bool {
+	return strings.HasPrefix(bucket, LocalScheme)
+}
+
+// path of the file with the key in the directory, keys must not leave the directory
+func localPath(bucket string, key string) (string, error) {
+	root := filepath.Clean(strings.TrimPrefix(bucket, LocalScheme))
+	res := filepath.Join(root, filepath.FromSlash(key))
+	if !strings.HasPrefix(res, root+string(filepath.Separator)) {
+		return "", errors.New("key " + key + " is outside of " + root)
+	}
+	return res, nil
+}
+
+func localFile(bucket string, key string, info os.FileInfo) *S3File {
+	return &S3File{
+		BucketName:   bucket,
+		Name:         key,
+		Size:         info.Size(),
+		LastModified: info.ModTime().UTC(),
+	}
+}
+
+// Iterate the regular files of the directory in the same order as S3 lists keys, symbolic
+// links and other special files are skipped
+func handleLocalFiles(bucket string, prefix string, handler func(file *S3File) error) error {
+	root := filepath.Clean(strings.TrimPrefix(bucket, LocalScheme))
+	var Id int64
+	// entries of a directory are sorted like the keys beneath them, "a/..." comes after "a.txt"
+	var walk func(dir string, key string) (bool, error)
+	walk = func(dir string, key string) (bool, error) {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return false, err
+		}
+		name := func(info os.FileInfo) string {
+			if info.IsDir() {
+				return key + info.Name() + "/"
+			}
+			return key + info.Name()
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return name(infos[i]) < name(infos[j])
+		})
+		for _, info := range infos {
+			fileName := name(info)
+			// only descend into directories which may hold keys with the prefix
+			if !strings.HasPrefix(fileName, prefix) && !strings.HasPrefix(prefix, fileName) {
+				continue
+			}
+			if info.IsDir() {
+				stopped, err := walk(filepath.Join(dir, info.Name()), fileName)
+				if stopped || err != nil {
+					return stopped, err
+				}
+				continue
+			}
+			if !info.Mode().IsRegular() || !strings.HasPrefix(fileName, prefix) {
+				GLogger.Debug("skipped %v of %v", fileName, bucket)
+				continue
+			}
+			Id++
+			file := localFile(bucket, fileName, info)
+			file.Id = Id
+			e := handler(file)
+			if e == ErrStopHandling {
+				return true, nil
+			}
+			if e != nil {
+				GLogger.Warning("Exception in handling file %v of %v, reason: %v", fileName, bucket, e)
+			}
+		}
+		return false, nil
+	}
+	_, err := walk(root, "")
+	return err
+}
+
+// nil if the file doesn't exist
+func getLocalFile(bucket string, key string) (*S3File, error) {
+	filePath, err := localPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return localFile(bucket, key, info), nil
+}
+
+func deleteLocalFile(bucket string, key string) error {
+	filePath, err := localPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filePath)
+	if err != nil {
+		return err
+	}
+	GLogger.Debug("deleted file %v", filePath)
+	return nil
+}
+
+// modification time of an object, kept in its metadata if it was uploaded from a directory
+func objectMtime(head *s3.HeadObjectOutput) time.Time {
+	for key, value := range head.Metadata {
+		if !strings.EqualFold(key, MtimeMetadata) {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(aws.StringValue(value), 64)
+		if err == nil {
+			return time.Unix(0, int64(seconds*1e9)).UTC()
+		}
+	}
+	return aws.TimeValue(head.LastModified)
+}
+
+// The MD5 of an object if its ETag is one, the ETag of multipart, KMS and SSE-C encrypted objects isn't
+func etagChecksum(head *s3.HeadObjectOutput) (string, bool) {
+	etag := strings.Trim(aws.StringValue(head.ETag), "\"")
+	ok := !strings.Contains(etag, "-") && head.SSECustomerAlgorithm == nil &&
+		aws.StringValue(head.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms
+	return etag, ok
+}
+
+// Upload a file of a directory to destBucket/destFileName with its modification time in the
+// metadata. Files already uploaded with the same size and modification time are not uploaded again
+func (manager *S3Manager) uploadFile(file *S3File, destBucket string, destFileName string, options *CopyOptions) error {
+	if options == nil {
+		options = &CopyOptions{}
+	}
+	if options.Encryption.needsSource() {
+		return errors.New("encryption of files of a directory cannot be preserved")
+	}
+	storageClass := options.StorageClass
+	if storageClass == "" {
+		storageClass = "STANDARD"
+	}
+	filePath, err := localPath(file.BucketName, file.Name)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	mtime := strconv.FormatFloat(float64(info.ModTime().UnixNano())/1e9, 'f', -1, 64)
+	head, err := manager.s3cli.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(destBucket),
+		Key:    aws.String(destFileName),
+	})
+	if err == nil && aws.Int64Value(head.ContentLength) == info.Size() && objectMtime(head).Unix() == info.ModTime().Unix() {
+		return ErrUnchanged
+	}
+
+	headers := getHeaders(&s3.HeadObjectOutput{}, options.Metadata)
+	headers.metadata[MtimeMetadata] = aws.String(mtime)
+	if headers.headers["Content-Type"] == nil {
+		if contentType := mime.TypeByExtension(path.Ext(file.Name)); contentType != "" {
+			headers.headers["Content-Type"] = aws.String(contentType)
+		}
+	}
+	uploader := s3manager.NewUploaderWithClient(manager.s3cli, func(u *s3manager.Uploader) {
+		u.PartSize = StreamPartSize
+		u.Concurrency = StreamConcurrency
+	})
+	input := &s3manager.UploadInput{
+		Bucket:             aws.String(destBucket),
+		Key:                aws.String(destFileName),
+		Body:               f,
+		StorageClass:       aws.String(storageClass),
+		CacheControl:       headers.headers["Cache-Control"],
+		ContentDisposition: headers.headers["Content-Disposition"],
+		ContentEncoding:    headers.headers["Content-Encoding"],
+		ContentLanguage:    headers.headers["Content-Language"],
+		ContentType:        headers.headers["Content-Type"],
+		Metadata:           headers.metadata,
+	}
+	if options.Metadata != nil && len(options.Metadata.Tags) > 0 {
+		tags := url.Values{}
+		for key, value := range options.Metadata.Tags {
+			tags.Set(key, value)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+	options.Encryption.resolve(nil).applyToUpload(input)
+	res, err := uploader.Upload(input)
+	if err != nil {
+		return err
+	}
+	GLogger.Debug("uploaded file %v to %v, res=%v", filePath, destBucket+"/"+destFileName, res)
+	return nil
+}
+
+// Download file into the directory destBucket, keeping the modification time of the object. The
+// object is written to a temporary file first, so interrupted downloads leave no partial files
+// and files already downloaded with the same size and modification time are skipped
+func (manager *S3Manager) downloadFile(file *S3File, destBucket string, destFileName string, options *CopyOptions) error {
+	var encryption *EncryptionOptions
+	if options != nil {
+		encryption = options.Encryption
+	}
+	filePath, err := localPath(destBucket, destFileName)
+	if err != nil {
+		return err
+	}
+	head, err := manager.headObject(file, encryption)
+	if err != nil {
+		return err
+	}
+	mtime := objectMtime(head)
+	size := aws.Int64Value(head.ContentLength)
+	if info, err := os.Stat(filePath); err == nil && info.Size() == size && info.ModTime().Unix() == mtime.Unix() {
+		return ErrUnchanged
+	}
+	input := &s3.GetObjectInput{
+		Bucket:    aws.String(file.BucketName),
+		Key:       aws.String(file.Name),
+		VersionId: versionId(file),
+		IfMatch:   head.ETag,
+	}
+	if encryption != nil && encryption.SourceCustomerKey != "" {
+		input.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		input.SSECustomerKey = aws.String(encryption.SourceCustomerKey)
+	}
+	obj, err := manager.s3cli.GetObject(input)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".crazys3-")
+	if err != nil {
+		return err
+	}
+	hash := md5.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), obj.Body)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil && written != size {
+		err = fmt.Errorf("downloaded %v bytes of %v, expected %v", written, file.BucketName+"/"+file.Name, size)
+	}
+	if etag, ok := etagChecksum(head); err == nil && ok && hex.EncodeToString(hash.Sum(nil)) != etag {
+		err = fmt.Errorf("checksum of downloaded %v doesn't match its ETag %v", file.BucketName+"/"+file.Name, etag)
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), mtime, mtime)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	GLogger.Debug("downloaded file %v to %v", file.BucketName+"/"+file.Name, filePath)
+	return nil
+}
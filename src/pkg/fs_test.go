@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHandleLocalFiles(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.txt", "a/b.txt", "a/c/d.txt", "a-b.txt", "b.txt", "ab/x.txt"} {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), name)
+	}
+	if err := os.Symlink(filepath.Join(dir, "b.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		// S3 order: "-" < "." < "/" < "b"
+		{"", []string{"a-b.txt", "a.txt", "a/b.txt", "a/c/d.txt", "ab/x.txt", "b.txt"}},
+		{"a/", []string{"a/b.txt", "a/c/d.txt"}},
+		{"a", []string{"a-b.txt", "a.txt", "a/b.txt", "a/c/d.txt", "ab/x.txt"}},
+		{"a/c", []string{"a/c/d.txt"}},
+		{"c", nil},
+	}
+	for _, test := range tests {
+		var got []string
+		var ids []int64
+		err := handleLocalFiles(LocalScheme+dir, test.prefix, func(file *S3File) error {
+			got = append(got, file.Name)
+			ids = append(ids, file.Id)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("prefix %q: %v", test.prefix, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("prefix %q: got %v, want %v", test.prefix, got, test.want)
+		}
+		for i, id := range ids {
+			if id != int64(i+1) {
+				t.Errorf("prefix %q: file %v has id %v", test.prefix, got[i], id)
+			}
+		}
+	}
+
+	var got []string
+	err := handleLocalFiles(LocalScheme+dir, "", func(file *S3File) error {
+		got = append(got, file.Name)
+		if len(got) == 2 {
+			return ErrStopHandling
+		}
+		return nil
+	})
+	if err != nil || len(got) != 2 {
+		t.Errorf("stopped listing: got %v, %v", got, err)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		bucket string
+		key    string
+		want   string
+		ok     bool
+	}{
+		{"file:///data", "a/b.txt", "/data/a/b.txt", true},
+		{"file:///data/", "a.txt", "/data/a.txt", true},
+		{"file:///data", "a/../b.txt", "/data/b.txt", true},
+		{"file:///data", "../etc/passwd", "", false},
+		{"file:///data", "a/../../etc/passwd", "", false},
+		{"file:///data", "../data2/x", "", false},
+		{"file:///data", "", "", false},
+		{"file:///data", ".", "", false},
+	}
+	for _, test := range tests {
+		got, err := localPath(test.bucket, test.key)
+		if (err == nil) != test.ok {
+			t.Errorf("localPath(%q, %q): error %v, want ok=%v", test.bucket, test.key, err, test.ok)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("localPath(%q, %q) = %q, want %q", test.bucket, test.key, got, test.want)
+		}
+	}
+}
+
+func TestUploadFileResume(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fake := newFakeS3()
+	defer fake.server.Close()
+	manager := fake.manager()
+	path := filepath.Join(dir, "a.txt")
+	writeFile(t, path, "hello")
+	file := &S3File{BucketName: LocalScheme + dir, Name: "a.txt"}
+
+	tests := []struct {
+		name    string
+		prepare func()
+		want    error
+		puts    int
+	}{
+		{"new file", func() {}, nil, 1},
+		{"same size and mtime", func() {}, ErrUnchanged, 1},
+		{"changed mtime", func() {
+			mtime := time.Now().Add(-time.Hour)
+			os.Chtimes(path, mtime, mtime)
+		}, nil, 2},
+		{"changed size", func() { writeFile(t, path, "hello world") }, nil, 3},
+		{"uploaded again", func() {}, ErrUnchanged, 3},
+	}
+	for _, test := range tests {
+		test.prepare()
+		err := manager.uploadFile(file, "bucket", "a.txt", nil)
+		if err != test.want {
+			t.Errorf("%v: got %v, want %v", test.name, err, test.want)
+		}
+		if puts := fake.count("PUT"); puts != test.puts {
+			t.Errorf("%v: %v uploads, want %v", test.name, puts, test.puts)
+		}
+	}
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	fake := newFakeS3()
+	defer fake.server.Close()
+	manager := fake.manager()
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fake.put("a/b.txt", "hello", modified)
+	file := &S3File{BucketName: "bucket", Name: "a/b.txt"}
+	path := filepath.Join(dir, "a", "b.txt")
+
+	tests := []struct {
+		name    string
+		prepare func()
+		want    error
+		gets    int
+	}{
+		{"new file", func() {}, nil, 1},
+		{"same size and mtime", func() {}, ErrUnchanged, 1},
+		{"changed locally", func() {
+			writeFile(t, path, "other")
+		}, nil, 2},
+		{"changed object", func() { fake.put("a/b.txt", "hello world", modified.Add(time.Hour)) }, nil, 3},
+		{"downloaded again", func() {}, ErrUnchanged, 3},
+	}
+	for _, test := range tests {
+		test.prepare()
+		err := manager.downloadFile(file, LocalScheme+dir, "a/b.txt", nil)
+		if err != test.want {
+			t.Errorf("%v: got %v, want %v", test.name, err, test.want)
+		}
+		if gets := fake.count("GET"); gets != test.gets {
+			t.Errorf("%v: %v downloads, want %v", test.name, gets, test.gets)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		object := fake.objects["a/b.txt"]
+		if info.Size() != int64(len(object.body)) || !info.ModTime().Equal(object.modified) {
+			t.Errorf("%v: file has size %v and mtime %v, object %v and %v", test.name, info.Size(), info.ModTime(), len(object.body), object.modified)
+		}
+	}
+	// temporary files are renamed or removed
+	infos, err := ioutil.ReadDir(filepath.Join(dir, "a"))
+	if err != nil || len(infos) != 1 {
+		t.Errorf("directory has %v files, want only b.txt", len(infos))
+	}
+}
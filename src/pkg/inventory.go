@@ -0,0 +1,287 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// manifest.json of an S3 Inventory report
+type InventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key         string `json:"key"`
+		Size        int64  `json:"size"`
+		MD5checksum string `json:"MD5checksum"`
+	} `json:"files"`
+}
+
+// columns of ORC and Parquet inventories by the names of the columns of CSV inventories
+var inventoryColumns = map[string]string{
+	"bucket":             "Bucket",
+	"key":                "Key",
+	"version_id":         "VersionId",
+	"is_latest":          "IsLatest",
+	"is_delete_marker":   "IsDeleteMarker",
+	"size":               "Size",
+	"last_modified_date": "LastModifiedDate",
+	"e_tag":              "ETag",
+	"storage_class":      "StorageClass",
+}
+
+// Iterate the current objects of bucket under prefix as reported by an S3 Inventory, instead of
+// listing the bucket. manifest is s3://bucket/key of manifest.json or a local copy of it, local
+// manifests expect the data files in ../data like in the inventory destination. The data files
+// may be CSV, ORC or Parquet
+func (manager *S3Manager) HandleInventory(manifest string, bucket string, prefix string, handler func(file *S3File) error) error {
+	source, err := manager.openInventoryFile(manifest)
+	if err != nil {
+		return err
+	}
+	inventory := &InventoryManifest{}
+	err = json.NewDecoder(source).Decode(inventory)
+	source.Close()
+	if err != nil {
+		return err
+	}
+	if inventory.SourceBucket != bucket {
+		return errors.New("inventory " + manifest + " is a report of " + inventory.SourceBucket + ", not of " + bucket)
+	}
+	// the schema of ORC and Parquet files is in the files
+	columns := make(map[string]int)
+	switch inventory.FileFormat {
+	case "CSV":
+		for i, column := range strings.Split(inventory.FileSchema, ",") {
+			columns[strings.TrimSpace(column)] = i
+		}
+		for _, column := range []string{"Bucket", "Key"} {
+			if _, ok := columns[column]; !ok {
+				return errors.New("inventory " + manifest + " has no " + column + " column")
+			}
+		}
+	case "ORC", "Parquet":
+	default:
+		return errors.New("unsupported inventory format " + inventory.FileFormat + ", expected CSV, ORC or Parquet")
+	}
+	var Id int64
+	for _, dataFile := range inventory.Files {
+		location := "s3://" + strings.TrimPrefix(inventory.DestinationBucket, "arn:aws:s3:::") + "/" + dataFile.Key
+		if !strings.HasPrefix(manifest, "s3://") {
+			location = filepath.Join(filepath.Dir(filepath.Dir(manifest)), "data", filepath.Base(dataFile.Key))
+		}
+		stopped, err := handleInventoryFile(manager, location, dataFile.MD5checksum, inventory.FileFormat, columns, prefix, &Id, handler)
+		if stopped || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns true if the handler stopped the iteration. columns are the columns of CSV files
+func handleInventoryFile(manager *S3Manager, location string, checksum string, format string, columns map[string]int, prefix string, Id *int64, handler func(file *S3File) error) (bool, error) {
+	f, err := manager.openInventoryFile(location)
+	if err != nil {
+		return false, err
+	}
+	// the checksum is verified before any row is handled, so the file is spooled first
+	spool, err := ioutil.TempFile("", "inventory")
+	if err != nil {
+		f.Close()
+		return false, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(spool, hash), f)
+	f.Close()
+	if err != nil {
+		return false, fmt.Errorf("cannot read %v, reason: %v", location, err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); checksum != "" && sum != checksum {
+		return false, fmt.Errorf("checksum %v of %v doesn't match %v of the manifest", sum, location, checksum)
+	}
+
+	stopped := false
+	var rowErr error
+	// handle a row, column returns the value of a column of a CSV inventory. Keys of CSV
+	// inventories are url encoded. Returns false to stop
+	handle := func(column func(name string) string, encoded bool) bool {
+		// noncurrent versions and delete markers are listed if the inventory includes all versions
+		if column("IsLatest") == "false" || column("IsDeleteMarker") == "true" {
+			return true
+		}
+		key := column("Key")
+		if encoded {
+			if key, rowErr = url.QueryUnescape(key); rowErr != nil {
+				rowErr = fmt.Errorf("invalid key %v in %v", column("Key"), location)
+				return false
+			}
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return true
+		}
+		*Id++
+		file := &S3File{
+			Id:           *Id,
+			BucketName:   column("Bucket"),
+			Name:         key,
+			StorageClass: column("StorageClass"),
+		}
+		// listings return quoted etags
+		if etag := column("ETag"); etag != "" {
+			file.ETag = "\"" + etag + "\""
+		}
+		file.Size, _ = strconv.ParseInt(column("Size"), 10, 64)
+		file.LastModified, _ = time.Parse(time.RFC3339, column("LastModifiedDate"))
+		e := handler(file)
+		if e == ErrStopHandling {
+			stopped = true
+			return false
+		}
+		if e != nil {
+			GLogger.Warning("Exception in handling file %v of inventory %v, reason: %v", key, location, e)
+		}
+		return true
+	}
+
+	if format != "CSV" {
+		read := readParquet
+		if format == "ORC" {
+			read = readORC
+		}
+		var names []string
+		for name := range inventoryColumns {
+			names = append(names, name)
+		}
+		err = read(spool, size, names, func(row map[string]interface{}) bool {
+			if _, ok := row["key"]; !ok {
+				rowErr = fmt.Errorf("%v has no key column", location)
+				return false
+			}
+			values := make(map[string]string, len(row))
+			for name, value := range row {
+				values[inventoryColumns[name]] = inventoryValue(value)
+			}
+			return handle(func(name string) string { return values[name] }, false)
+		})
+		if err != nil {
+			return false, fmt.Errorf("cannot read %v, reason: %v", location, err)
+		}
+		if rowErr != nil {
+			return false, rowErr
+		}
+		GLogger.Debug("read inventory file %v", location)
+		return stopped, nil
+	}
+
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	unzipped, err := gzip.NewReader(spool)
+	if err != nil {
+		return false, fmt.Errorf("cannot read %v, reason: %v", location, err)
+	}
+	reader := csv.NewReader(unzipped)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, fmt.Errorf("cannot read %v, reason: %v", location, err)
+		}
+		column := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		if !handle(column, true) {
+			if rowErr != nil {
+				return false, rowErr
+			}
+			return stopped, nil
+		}
+	}
+	GLogger.Debug("read inventory file %v", location)
+	return false, nil
+}
+
+// a value of an ORC or Parquet inventory as CSV inventories have it
+func inventoryValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// open s3://bucket/key or a local file, the bucket may be in another region than the manager
+func (manager *S3Manager) openInventoryFile(location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		return os.Open(location)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid location " + location + ", expected s3://bucket/key")
+	}
+	cli, err := manager.bucketClient(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(parts[0]),
+		Key:    aws.String(parts[1]),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// a client of the manager's account in the region of bucket
+func (manager *S3Manager) bucketClient(bucket string) (*s3.S3, error) {
+	region, err := manager.GetBucketRegion(bucket)
+	if err != nil {
+		return nil, err
+	}
+	config := &aws.Config{
+		Region:      aws.String(region),
+		Credentials: manager.cred,
+	}
+	if err := manager.endpoint.apply(config); err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a local inventory of bucket with the data files, the manifest is returned
+func writeInventory(t *testing.T, dir string, format string, schema string, files ...[]byte) string {
+	manifest := InventoryManifest{SourceBucket: "bucket", FileFormat: format, FileSchema: schema}
+	for i, data := range files {
+		name := "data" + strconv.Itoa(i)
+		writeFile(t, filepath.Join(dir, "data", name), string(data))
+		sum := md5.Sum(data)
+		manifest.Files = append(manifest.Files, struct {
+			Key         string `json:"key"`
+			Size        int64  `json:"size"`
+			MD5checksum string `json:"MD5checksum"`
+		}{"inventory/data/" + name, int64(len(data)), hex.EncodeToString(sum[:])})
+	}
+	b, _ := json.Marshal(manifest)
+	location := filepath.Join(dir, "2020-01-02T00-00Z", "manifest.json")
+	writeFile(t, location, string(b))
+	return location
+}
+
+func testGzip(t *testing.T, s string) []byte {
+	return testCompress(t, parquetGzip, []byte(s))
+}
+
+// files as "Id bucket name size class etag modified"
+func handleInventory(manifest string, prefix string) ([]string, error) {
+	var res []string
+	err := (&S3Manager{}).HandleInventory(manifest, "bucket", prefix, func(file *S3File) error {
+		res = append(res, strings.Join([]string{strconv.FormatInt(file.Id, 10), file.BucketName, file.Name,
+			inventoryValue(file.Size), file.StorageClass, file.ETag, inventoryValue(file.LastModified)}, " "))
+		return nil
+	})
+	return res, err
+}
+
+func TestHandleInventory(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	millis := modified.UnixNano() / int64(time.Millisecond)
+
+	// the second file of the CSV inventory continues the ids
+	csvManifest := writeInventory(t, filepath.Join(dir, "csv"), "CSV", "Bucket, Key, Size, LastModifiedDate, ETag, StorageClass, IsLatest",
+		testGzip(t, "bucket,logs%2Fa.txt,10,2020-01-01T00:00:00.000Z,e1,STANDARD,true\n"+
+			"bucket,logs%2Fold+file.txt,20,2020-01-01T00:00:00.000Z,e2,STANDARD,false\n"),
+		testGzip(t, "bucket,other.txt,30,2020-01-01T00:00:00.000Z,e3,GLACIER,true\n"+
+			"bucket,logs%2Fb+c.txt,40,2020-01-01T00:00:00.000Z,e4,GLACIER,true\n"))
+
+	texts := func(values ...string) []byte {
+		var args []interface{}
+		for _, v := range values {
+			args = append(args, v)
+		}
+		return testParquetValues(args...)
+	}
+	plain := func(values []byte) []testParquetPage {
+		return []testParquetPage{{pageType: parquetDataPage, entries: 4, encoding: parquetPlain, values: values}}
+	}
+	parquetManifest := writeInventory(t, filepath.Join(dir, "parquet"), "Parquet", "",
+		testParquetFile(t, 4, []testParquetColumn{
+			{name: "bucket", physicalType: parquetByteArray, convertedType: parquetUTF8,
+				pages: plain(texts("bucket", "bucket", "bucket", "bucket"))},
+			{name: "key", physicalType: parquetByteArray, convertedType: parquetUTF8,
+				pages: plain(texts("logs/a.txt", "logs/old file.txt", "other.txt", "logs/b c.txt"))},
+			{name: "size", physicalType: parquetInt64, convertedType: -1,
+				pages: plain(testParquetValues(int64(10), int64(20), int64(30), int64(40)))},
+			{name: "last_modified_date", physicalType: parquetInt64, convertedType: parquetTimestampMillis,
+				pages: plain(testParquetValues(millis, millis, millis, millis))},
+			{name: "e_tag", physicalType: parquetByteArray, convertedType: parquetUTF8,
+				pages: plain(texts("e1", "e2", "e3", "e4"))},
+			{name: "storage_class", physicalType: parquetByteArray, convertedType: parquetUTF8,
+				pages: plain(texts("STANDARD", "STANDARD", "GLACIER", "GLACIER"))},
+			// booleans are bit-packed, least significant bit first
+			{name: "is_latest", physicalType: parquetBoolean, convertedType: -1, pages: plain([]byte{0x0d})},
+		}))
+
+	seconds := modified.Sub(orcTimestampBase).Nanoseconds() / int64(time.Second)
+	lengths := func(values ...string) []byte {
+		var res []int64
+		for _, v := range values {
+			res = append(res, int64(len(v)))
+		}
+		return testORCIntegers(false, res...)
+	}
+	orcManifest := writeInventory(t, filepath.Join(dir, "orc"), "ORC", "",
+		testORCFile(t, []string{"bucket", "key", "size", "last_modified_date", "e_tag", "storage_class", "is_latest"},
+			[]uint64{orcString, orcString, orcLong, orcTimestamp, orcString, orcString, orcBoolean},
+			[]testORCStripe{{
+				rows:      4,
+				encodings: []uint64{orcDirect, orcDirectV2, orcDirectV2, orcDirectV2, orcDirectV2, orcDirectV2, orcDirectV2, orcDirect},
+				streams: []testORCStream{
+					{1, orcData, []byte("bucketbucketbucketbucket")},
+					{1, orcLength, lengths("bucket", "bucket", "bucket", "bucket")},
+					{2, orcData, []byte("logs/a.txtlogs/old file.txtother.txtlogs/b c.txt")},
+					{2, orcLength, lengths("logs/a.txt", "logs/old file.txt", "other.txt", "logs/b c.txt")},
+					{3, orcData, testORCIntegers(true, 10, 20, 30, 40)},
+					{4, orcData, testORCIntegers(true, seconds, seconds, seconds, seconds)},
+					{4, orcSecondary, testORCIntegers(false, 0, 0, 0, 0)},
+					{5, orcData, []byte("e1e2e3e4")},
+					{5, orcLength, lengths("e1", "e2", "e3", "e4")},
+					{6, orcData, []byte("STANDARDSTANDARDGLACIERGLACIER")},
+					{6, orcLength, lengths("STANDARD", "STANDARD", "GLACIER", "GLACIER")},
+					{7, orcData, testORCBooleans(true, false, true, true)},
+				},
+			}}))
+
+	want := []string{
+		"1 bucket logs/a.txt 10 STANDARD \"e1\" 2020-01-01T00:00:00Z",
+		"2 bucket logs/b c.txt 40 GLACIER \"e4\" 2020-01-01T00:00:00Z",
+	}
+	for _, manifest := range []string{csvManifest, parquetManifest, orcManifest} {
+		got, err := handleInventory(manifest, "logs/")
+		if err != nil {
+			t.Errorf("%v: %v", manifest, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got files %q, want %q", manifest, got, want)
+		}
+	}
+
+	// a data file changed since the manifest was written
+	writeFile(t, filepath.Join(dir, "orc", "data", "data0"), "changed")
+	if _, err := handleInventory(orcManifest, ""); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("got error %v for a changed data file", err)
+	}
+	if err := (&S3Manager{}).HandleInventory(csvManifest, "other", "", nil); err == nil {
+		t.Error("read the inventory of another bucket")
+	}
+	avro := writeInventory(t, filepath.Join(dir, "avro"), "Avro", "")
+	if _, err := handleInventory(avro, ""); err == nil || !strings.Contains(err.Error(), "unsupported inventory format") {
+		t.Errorf("got error %v for an Avro inventory", err)
+	}
+	noKey := writeInventory(t, filepath.Join(dir, "nokey"), "Parquet", "", testParquetFile(t, 1, []testParquetColumn{
+		{name: "bucket", physicalType: parquetByteArray, convertedType: parquetUTF8,
+			pages: []testParquetPage{{pageType: parquetDataPage, entries: 1, encoding: parquetPlain, values: texts("bucket")}}},
+	}))
+	if _, err := handleInventory(noKey, ""); err == nil || !strings.Contains(err.Error(), "no key column") {
+		t.Errorf("got error %v for an inventory without keys", err)
+	}
+}
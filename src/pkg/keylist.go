@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Key lists name the objects of a job explicitly instead of listing the bucket. They are CSV files
+// with a header row or JSONL files (.jsonl or .json) with one object per line, columns and fields
+// are named as below. Only key is required, bucket defaults to the job's bucket
+type KeyListEntry struct {
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	VersionId    string `json:"version_id"`
+	Size         *int64 `json:"size"`
+	StorageClass string `json:"storage_class"`
+}
+
+var keyListColumns = []string{"bucket", "key", "version_id", "size", "storage_class"}
+
+// read the entries of a key list in order
+func readKeyList(path string, handler func(entry *KeyListEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.HasSuffix(path, ".jsonl") || strings.HasSuffix(path, ".json") {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			entry := &KeyListEntry{}
+			if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+				return fmt.Errorf("line %v of %v, reason: %v", line, path, err)
+			}
+			if err := handler(entry); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("no header in %v, reason: %v", path, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["key"]; !ok {
+		return fmt.Errorf("no key column in %v, expected columns of %v", path, keyListColumns)
+	}
+	column := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read %v, reason: %v", path, err)
+		}
+		entry := &KeyListEntry{
+			Bucket:       column(record, "bucket"),
+			VersionId:    column(record, "version_id"),
+			StorageClass: column(record, "storage_class"),
+		}
+		// keys are taken as they are, spaces included
+		if i := columns["key"]; i < len(record) {
+			entry.Key = record[i]
+		}
+		if s := column(record, "size"); s != "" {
+			size, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid size %v of %v in %v", s, entry.Key, path)
+			}
+			entry.Size = &size
+		}
+		if err := handler(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// the entry's object if it belongs to bucket and prefix, nil otherwise
+func keyListFile(entry *KeyListEntry, bucket string, prefix string) *S3File {
+	if entry.Key == "" || !strings.HasPrefix(entry.Key, prefix) {
+		return nil
+	}
+	if entry.Bucket != "" && entry.Bucket != bucket {
+		GLogger.Warning("skipped %v of bucket %v, the job is for bucket %v", entry.Key, entry.Bucket, bucket)
+		return nil
+	}
+	return &S3File{BucketName: bucket, Name: entry.Key, VersionId: entry.VersionId}
+}
+
+// Iterate the objects of a key list which are in bucket under prefix. Objects whose size or
+// storage class isn't in the list are looked up with HEAD, objects which don't exist are skipped
+func (manager *S3Manager) HandleKeyList(path string, bucket string, prefix string, handler func(file *S3File) error) error {
+	var Id int64
+	err := readKeyList(path, func(entry *KeyListEntry) error {
+		file := keyListFile(entry, bucket, prefix)
+		if file == nil {
+			return nil
+		}
+		if entry.Size != nil && entry.StorageClass != "" {
+			file.Size, file.StorageClass = *entry.Size, entry.StorageClass
+		} else {
+			head, err := manager.GetFileVersion(file)
+			if err != nil {
+				GLogger.Warning("Exception in getting file %v of bucket %v, reason: %v", file.Name, bucket, err)
+				return nil
+			}
+			if head == nil {
+				GLogger.Warning("skipped %v, it doesn't exist in bucket %v", file.Name, bucket)
+				return nil
+			}
+			file = head
+		}
+		Id++
+		file.Id = Id
+		e := handler(file)
+		if e != nil && e != ErrStopHandling {
+			GLogger.Warning("Exception in handling file %v of bucket %v, reason: %v", file.Name, bucket, e)
+			return nil
+		}
+		return e
+	})
+	if err == ErrStopHandling {
+		return nil
+	}
+	return err
+}
+
+// Version histories of the keys of a key list, see HandleFileVersions. Version ids in the list
+// are ignored, every key is handled once with its full history
+func (manager *S3Manager) HandleKeyListVersions(path string, bucket string, prefix string, handler func(versions []*S3File) error) error {
+	var Id int64
+	handled := make(map[string]bool)
+	err := readKeyList(path, func(entry *KeyListEntry) error {
+		file := keyListFile(entry, bucket, prefix)
+		if file == nil || handled[file.Name] {
+			return nil
+		}
+		handled[file.Name] = true
+		var stopped bool
+		// keys with this key as prefix are listed after it, the listing stops once the key is handled
+		err := manager.HandleFileVersions(bucket, file.Name, func(versions []*S3File) error {
+			if versions[0].Name != file.Name {
+				return ErrStopHandling
+			}
+			for _, version := range versions {
+				Id++
+				version.Id = Id
+			}
+			e := handler(versions)
+			stopped = e == ErrStopHandling
+			if e != nil && !stopped {
+				GLogger.Warning("Exception in handling versions of file %v of bucket %v, reason: %v", file.Name, bucket, e)
+			}
+			return ErrStopHandling
+		})
+		if stopped {
+			return ErrStopHandling
+		}
+		return err
+	})
+	if err == ErrStopHandling {
+		return nil
+	}
+	return err
+}
+
+// Files of both buckets named by a key list, compared like CompareBuckets does with listings.
+// Keys are handled in the order of the list, keys which can't be looked up are skipped like
+// HandleKeyList does
+func CompareKeyList(path string, source *S3Manager, sourceBucket string, sourcePrefix string, dest *S3Manager, destBucket string, destPrefix string, handler func(src *S3File, dst *S3File) error) error {
+	err := readKeyList(path, func(entry *KeyListEntry) error {
+		file := keyListFile(entry, sourceBucket, sourcePrefix)
+		if file == nil {
+			return nil
+		}
+		src, err := source.GetFileVersion(file)
+		if err != nil {
+			GLogger.Warning("Exception in getting file %v of bucket %v, reason: %v", file.Name, sourceBucket, err)
+			return nil
+		}
+		destKey := destPrefix + strings.TrimPrefix(file.Name, sourcePrefix)
+		dst, err := dest.GetFile(destBucket, destKey)
+		if err != nil {
+			GLogger.Warning("Exception in getting file %v of bucket %v, reason: %v", destKey, destBucket, err)
+			return nil
+		}
+		if src == nil && dst == nil {
+			GLogger.Warning("skipped %v, it exists in neither bucket", file.Name)
+			return nil
+		}
+		e := handler(src, dst)
+		if e != nil && e != ErrStopHandling {
+			GLogger.Warning("Exception in comparing file %v, reason: %v", file.Name, e)
+			return nil
+		}
+		return e
+	})
+	if err == ErrStopHandling {
+		return nil
+	}
+	return err
+}
+
+// Whether a job source is a key list rather than an S3 Inventory manifest. Manifests on S3 are
+// named s3://bucket/key, local files are manifests if they are a JSON document of an inventory
+func IsKeyList(path string) bool {
+	if path == "" || strings.HasPrefix(path, "s3://") {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		// reading the key list reports the error
+		return true
+	}
+	defer f.Close()
+	inventory := &InventoryManifest{}
+	if json.NewDecoder(f).Decode(inventory) != nil {
+		return true
+	}
+	return inventory.SourceBucket == "" || inventory.Files == nil
+}
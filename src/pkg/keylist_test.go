@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadKeyList(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	size := func(v int64) *int64 { return &v }
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []KeyListEntry
+		err     string
+	}{
+		{"csv", "keys.csv", "key,size\na.txt,1\n b.txt ,\n",
+			[]KeyListEntry{{Key: "a.txt", Size: size(1)}, {Key: " b.txt "}}, ""},
+		{"csv columns", "keys.csv", " Bucket ,KEY,Version_Id,Storage_Class\nb1,a.txt,v1, GLACIER\nb2,c.txt\n",
+			[]KeyListEntry{{Bucket: "b1", Key: "a.txt", VersionId: "v1", StorageClass: "GLACIER"}, {Bucket: "b2", Key: "c.txt"}}, ""},
+		{"csv header only", "keys.csv", "key\n", nil, ""},
+		{"csv no header", "keys.csv", "", nil, "no header"},
+		{"csv no key column", "keys.csv", "name,size\na.txt,1\n", nil, "no key column"},
+		{"csv invalid size", "keys.csv", "key,size\na.txt,big\n", nil, "invalid size big of a.txt"},
+		{"jsonl", "keys.jsonl", "{\"key\":\"a.txt\",\"size\":1}\n\n{\"bucket\":\"b\",\"key\":\"c.txt\",\"version_id\":\"v1\"}\n",
+			[]KeyListEntry{{Key: "a.txt", Size: size(1)}, {Bucket: "b", Key: "c.txt", VersionId: "v1"}}, ""},
+		{"jsonl invalid line", "keys.json", "{\"key\":\"a.txt\"}\nkey\n", nil, "line 2"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name, test.file)
+		writeFile(t, path, test.content)
+		var got []KeyListEntry
+		err := readKeyList(path, func(entry *KeyListEntry) error {
+			got = append(got, *entry)
+			return nil
+		})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%v: got error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	if err := readKeyList(filepath.Join(dir, "missing.csv"), func(*KeyListEntry) error { return nil }); err == nil {
+		t.Errorf("missing key list: no error")
+	}
+}
+
+func TestIsKeyList(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"keys.csv":      "key\na.txt\n",
+		"keys.json":     "{\"key\":\"a.txt\"}\n",
+		"manifest.json": "{\"sourceBucket\":\"b\",\"destinationBucket\":\"arn:aws:s3:::d\",\"files\":[]}",
+		// a manifest of another name
+		"inventory": "{\"sourceBucket\":\"b\",\"files\":[{\"key\":\"data/a.csv.gz\"}]}",
+	}
+	for name, content := range files {
+		writeFile(t, filepath.Join(dir, name), content)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"", false},
+		{"s3://bucket/manifest.json", false},
+		{filepath.Join(dir, "keys.csv"), true},
+		{filepath.Join(dir, "keys.json"), true},
+		{filepath.Join(dir, "manifest.json"), false},
+		{filepath.Join(dir, "inventory"), false},
+		// reading the key list reports the error
+		{filepath.Join(dir, "missing.csv"), true},
+	}
+	for _, test := range tests {
+		if got := IsKeyList(test.path); got != test.want {
+			t.Errorf("IsKeyList(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
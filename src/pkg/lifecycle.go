@@ -0,0 +1,353 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	LintError   = "error"
+	LintDanger  = "danger"
+	LintWarning = "warning"
+	// actions due within this time are reported as soon
+	lifecycleSoon = 30 * 24 * time.Hour
+	// lifecycle doesn't transition objects below this size by default
+	minTransitionSize = 128 << 10
+)
+
+// S3 only transitions objects down this waterfall, e.g. never from GLACIER to STANDARD_IA
+var transitionOrder = map[string]int{
+	"STANDARD":            0,
+	"REDUCED_REDUNDANCY":  0,
+	"STANDARD_IA":         1,
+	"INTELLIGENT_TIERING": 2,
+	"ONEZONE_IA":          3,
+	"GLACIER_IR":          4,
+	"GLACIER":             5,
+	"DEEP_ARCHIVE":        6,
+}
+
+// days objects are billed at least in a storage class, deleting or moving them earlier costs the rest
+var minStorageDays = map[string]int64{
+	"STANDARD_IA":  30,
+	"ONEZONE_IA":   30,
+	"GLACIER_IR":   90,
+	"GLACIER":      90,
+	"DEEP_ARCHIVE": 180,
+}
+
+// Lifecycle rules of the bucket, nil if it has none
+func (manager *S3Manager) GetLifecycleRules(bucket string) ([]*s3.LifecycleRule, error) {
+	res, err := manager.s3cli.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchLifecycleConfiguration" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res.Rules, nil
+}
+
+// Read proposed rules from a JSON file like aws s3api get-bucket-lifecycle-configuration prints it
+func ReadLifecycleRules(path string) ([]*s3.LifecycleRule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &s3.BucketLifecycleConfiguration{}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("invalid lifecycle configuration %v, reason: %v", path, err)
+	}
+	return config.Rules, nil
+}
+
+// prefix of the objects a rule applies to, and whether it also filters by tags
+func ruleScope(rule *s3.LifecycleRule) (string, bool) {
+	prefix := aws.StringValue(rule.Prefix)
+	tagged := false
+	if filter := rule.Filter; filter != nil {
+		if filter.Prefix != nil {
+			prefix = aws.StringValue(filter.Prefix)
+		}
+		if filter.Tag != nil {
+			tagged = true
+		}
+		if filter.And != nil {
+			prefix = aws.StringValue(filter.And.Prefix)
+			tagged = tagged || len(filter.And.Tags) > 0
+		}
+	}
+	return prefix, tagged
+}
+
+func ruleName(rule *s3.LifecycleRule, i int) string {
+	if id := aws.StringValue(rule.ID); id != "" {
+		return id
+	}
+	return fmt.Sprintf("rule %v", i+1)
+}
+
+// When an action of Days days or on Date takes effect for an object last modified at modified.
+// S3 rounds the time up to the next midnight UTC
+func actionDue(modified time.Time, days *int64, date *time.Time) time.Time {
+	if date != nil {
+		return date.UTC()
+	}
+	t := modified.UTC().AddDate(0, 0, int(aws.Int64Value(days)))
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if midnight.Before(t) {
+		midnight = midnight.AddDate(0, 0, 1)
+	}
+	return midnight
+}
+
+// What an action of a rule would do to the current objects
+type LifecycleAction struct {
+	Rule string
+	// e.g. "transition to GLACIER after 30 days" or "expire on 2020-01-01"
+	Action       string
+	StorageClass string
+	days         *int64
+	date         *time.Time
+	// objects the action is already due for, which are changed as soon as the rules are applied
+	Due ScanCount
+	// due within 30 days and later
+	Soon  ScanCount
+	Later ScanCount
+	// objects in the rule's scope the action doesn't apply to, already in a colder class or too small
+	Skipped ScanCount
+	// when the action takes effect for the first and the last object
+	First time.Time
+	Last  time.Time
+}
+
+type lifecycleRuleActions struct {
+	name    string
+	prefix  string
+	actions []*LifecycleAction
+}
+
+// Simulates rules against the current objects of a bucket. Rules are simulated independently,
+// objects several rules apply to are counted for each rule. Tags of objects aren't listed, so rules
+// filtering by tag are simulated for every object with their prefix and overestimate
+type LifecycleSimulation struct {
+	rules []*lifecycleRuleActions
+	now   time.Time
+	// objects in the scope of more than one enabled rule
+	Overlapping ScanCount
+	// current objects simulated, noncurrent versions aren't
+	Objects ScanCount
+}
+
+func NewLifecycleSimulation(rules []*s3.LifecycleRule, now time.Time) *LifecycleSimulation {
+	sim := &LifecycleSimulation{now: now}
+	for i, rule := range rules {
+		if aws.StringValue(rule.Status) != s3.ExpirationStatusEnabled {
+			continue
+		}
+		prefix, _ := ruleScope(rule)
+		r := &lifecycleRuleActions{name: ruleName(rule, i), prefix: prefix}
+		when := func(days *int64, date *time.Time) string {
+			if date != nil {
+				return "on " + date.UTC().Format("2006-01-02")
+			}
+			return fmt.Sprintf("after %v days", aws.Int64Value(days))
+		}
+		for _, t := range rule.Transitions {
+			if t.Days == nil && t.Date == nil {
+				continue
+			}
+			class := aws.StringValue(t.StorageClass)
+			r.actions = append(r.actions, &LifecycleAction{Rule: r.name, StorageClass: class, days: t.Days, date: t.Date,
+				Action: "transition to " + class + " " + when(t.Days, t.Date)})
+		}
+		if e := rule.Expiration; e != nil && (e.Days != nil || e.Date != nil) {
+			r.actions = append(r.actions, &LifecycleAction{Rule: r.name, days: e.Days, date: e.Date,
+				Action: "expire " + when(e.Days, e.Date)})
+		}
+		sim.rules = append(sim.rules, r)
+	}
+	return sim
+}
+
+// Count what the rules would do to file, a current object
+func (sim *LifecycleSimulation) Add(file *S3File) {
+	count := ScanCount{Objects: 1, Bytes: file.Size}
+	sim.Objects.add(count)
+	class := file.StorageClass
+	if class == "" {
+		class = "STANDARD"
+	}
+	matched := 0
+	for _, r := range sim.rules {
+		if !strings.HasPrefix(file.Name, r.prefix) {
+			continue
+		}
+		matched++
+		for _, action := range r.actions {
+			if action.StorageClass != "" {
+				order, known := transitionOrder[class]
+				if known && order >= transitionOrder[action.StorageClass] || file.Size < minTransitionSize {
+					action.Skipped.add(count)
+					continue
+				}
+			}
+			due := actionDue(file.LastModified, action.days, action.date)
+			switch {
+			case !due.After(sim.now):
+				action.Due.add(count)
+			case due.Sub(sim.now) <= lifecycleSoon:
+				action.Soon.add(count)
+			default:
+				action.Later.add(count)
+			}
+			if action.First.IsZero() || due.Before(action.First) {
+				action.First = due
+			}
+			if due.After(action.Last) {
+				action.Last = due
+			}
+		}
+	}
+	if matched > 1 {
+		sim.Overlapping.add(count)
+	}
+}
+
+func (sim *LifecycleSimulation) Actions() []*LifecycleAction {
+	var res []*LifecycleAction
+	for _, r := range sim.rules {
+		res = append(res, r.actions...)
+	}
+	return res
+}
+
+type LifecycleFinding struct {
+	Rule     string
+	Severity string
+	Message  string
+}
+
+func (f *LifecycleFinding) String() string {
+	return fmt.Sprintf("[%v] %v: %v", f.Severity, f.Rule, f.Message)
+}
+
+// Check the rules for mistakes, and with the simulated objects for rules which would archive or
+// delete much at once. Disabled rules are only checked for mistakes
+func LintLifecycleRules(rules []*s3.LifecycleRule, sim *LifecycleSimulation) []*LifecycleFinding {
+	var res []*LifecycleFinding
+	add := func(rule string, severity string, format string, v ...interface{}) {
+		res = append(res, &LifecycleFinding{Rule: rule, Severity: severity, Message: fmt.Sprintf(format, v...)})
+	}
+	// past dates are past for the simulated objects
+	now := time.Now()
+	if sim != nil {
+		now = sim.now
+	}
+	for i, rule := range rules {
+		name := ruleName(rule, i)
+		prefix, tagged := ruleScope(rule)
+		whole := prefix == "" && !tagged
+		if aws.StringValue(rule.Status) != s3.ExpirationStatusEnabled {
+			add(name, LintWarning, "disabled, it isn't simulated")
+		}
+		if tagged {
+			add(name, LintWarning, "filters by tag, it is simulated for every object under %q", prefix)
+		}
+		for _, t := range rule.Transitions {
+			class := aws.StringValue(t.StorageClass)
+			days := aws.Int64Value(t.Days)
+			// the simulation would skip every object of an unknown class
+			if _, known := transitionOrder[class]; !known {
+				add(name, LintError, "transition to unknown storage class %q", class)
+			}
+			if t.Days != nil && days < 30 && (class == s3.TransitionStorageClassStandardIa || class == s3.TransitionStorageClassOnezoneIa) {
+				add(name, LintError, "transition to %v after %v days, S3 requires at least 30", class, days)
+			}
+			if IsArchived(class) && whole {
+				add(name, LintDanger, "transitions every object of the bucket to %v, restoring them takes hours and costs per request", class)
+			} else if IsArchived(class) && t.Days != nil && days < 30 {
+				add(name, LintDanger, "transitions objects to %v after only %v days", class, days)
+			}
+			if t.Date != nil && IsArchived(class) && !t.Date.After(now) {
+				add(name, LintDanger, "transition to %v on %v is in the past, every object under %q is archived at once", class, t.Date.UTC().Format("2006-01-02"), prefix)
+			}
+		}
+		if e := rule.Expiration; e != nil && (e.Days != nil || e.Date != nil) {
+			if whole {
+				add(name, LintDanger, "expires every object of the bucket")
+			}
+			if e.Days != nil && aws.Int64Value(e.Days) < 30 {
+				add(name, LintDanger, "expires objects after only %v days", aws.Int64Value(e.Days))
+			}
+			if e.Date != nil && !e.Date.After(now) {
+				add(name, LintDanger, "expiration on %v is in the past, every object under %q is expired at once", e.Date.UTC().Format("2006-01-02"), prefix)
+			}
+			// objects deleted before their minimum storage duration are billed for it anyway
+			for _, t := range rule.Transitions {
+				class := aws.StringValue(t.StorageClass)
+				stored := aws.Int64Value(e.Days) - aws.Int64Value(t.Days)
+				if e.Days != nil && t.Days != nil && stored > 0 && stored < minStorageDays[class] {
+					add(name, LintWarning, "expires objects %v days after moving them to %v, which bills at least %v days",
+						stored, class, minStorageDays[class])
+				}
+			}
+		}
+		for _, t := range rule.NoncurrentVersionTransitions {
+			class := aws.StringValue(t.StorageClass)
+			if _, known := transitionOrder[class]; !known {
+				add(name, LintError, "noncurrent version transition to unknown storage class %q", class)
+			}
+		}
+		if e := rule.NoncurrentVersionExpiration; e != nil && aws.Int64Value(e.NoncurrentDays) < 7 {
+			add(name, LintDanger, "permanently deletes noncurrent versions after %v days, overwritten or deleted objects can hardly be recovered", aws.Int64Value(e.NoncurrentDays))
+		}
+		if rule.Expiration == nil && len(rule.Transitions) == 0 && rule.NoncurrentVersionExpiration == nil &&
+			len(rule.NoncurrentVersionTransitions) == 0 && rule.AbortIncompleteMultipartUpload == nil {
+			add(name, LintWarning, "has no actions")
+		}
+	}
+	if sim == nil {
+		return res
+	}
+	for _, action := range sim.Actions() {
+		if action.Due.Objects == 0 {
+			continue
+		}
+		severity := LintWarning
+		if action.StorageClass == "" || IsArchived(action.StorageClass) {
+			severity = LintDanger
+		}
+		add(action.Rule, severity, "%v is already due for %v objects(%v bytes), they change as soon as the rule is applied",
+			action.Action, action.Due.Objects, action.Due.Bytes)
+	}
+	if sim.Overlapping.Objects > 0 {
+		add("rules", LintWarning, "%v objects(%v bytes) are in the scope of several rules, the expiration or the coldest transition wins",
+			sim.Overlapping.Objects, sim.Overlapping.Bytes)
+	}
+	return res
+}
+
+func (sim *LifecycleSimulation) WriteTable(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "rule\taction\tdue objects\tdue bytes\tsoon objects\tsoon bytes\tlater objects\tlater bytes\tskipped\tfirst\tlast")
+	day := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02")
+	}
+	for _, a := range sim.Actions() {
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", a.Rule, a.Action, a.Due.Objects, a.Due.Bytes,
+			a.Soon.Objects, a.Soon.Bytes, a.Later.Objects, a.Later.Bytes, a.Skipped.Objects, day(a.First), day(a.Last))
+	}
+	return table.Flush()
+}
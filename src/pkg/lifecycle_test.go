@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func lifecycleRule(id string, prefix string) *s3.LifecycleRule {
+	return &s3.LifecycleRule{ID: aws.String(id), Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(prefix)}}
+}
+
+func lintMessages(findings []*LifecycleFinding) []string {
+	var res []string
+	for _, f := range findings {
+		res = append(res, f.String())
+	}
+	return res
+}
+
+func TestLintLifecycleRules(t *testing.T) {
+	transition := func(days int64, class string) []*s3.Transition {
+		return []*s3.Transition{{Days: aws.Int64(days), StorageClass: aws.String(class)}}
+	}
+	expiration := &s3.LifecycleExpiration{Days: aws.Int64(365)}
+	tests := []struct {
+		name  string
+		setup func(rule *s3.LifecycleRule)
+		want  []string
+	}{
+		{"unknown", func(rule *s3.LifecycleRule) { rule.Transitions = transition(60, "COLD") },
+			[]string{`[error] unknown: transition to unknown storage class "COLD"`}},
+		{"ia", func(rule *s3.LifecycleRule) { rule.Transitions = transition(10, "STANDARD_IA") },
+			[]string{"[error] ia: transition to STANDARD_IA after 10 days, S3 requires at least 30"}},
+		{"early", func(rule *s3.LifecycleRule) { rule.Transitions = transition(7, "GLACIER") },
+			[]string{"[danger] early: transitions objects to GLACIER after only 7 days"}},
+		{"whole", func(rule *s3.LifecycleRule) {
+			rule.Filter.Prefix = aws.String("")
+			rule.Expiration = expiration
+		}, []string{"[danger] whole: expires every object of the bucket"}},
+		{"short", func(rule *s3.LifecycleRule) {
+			rule.Transitions = transition(30, "GLACIER")
+			rule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(60)}
+		}, []string{"[warning] short: expires objects 30 days after moving them to GLACIER, which bills at least 90 days"}},
+		{"noncurrent", func(rule *s3.LifecycleRule) {
+			rule.NoncurrentVersionTransitions = []*s3.NoncurrentVersionTransition{{NoncurrentDays: aws.Int64(30), StorageClass: aws.String("COLD")}}
+		}, []string{`[error] noncurrent: noncurrent version transition to unknown storage class "COLD"`}},
+		{"versions", func(rule *s3.LifecycleRule) {
+			rule.NoncurrentVersionExpiration = &s3.NoncurrentVersionExpiration{NoncurrentDays: aws.Int64(1)}
+		}, []string{"[danger] versions: permanently deletes noncurrent versions after 1 days, overwritten or deleted objects can hardly be recovered"}},
+		{"disabled", func(rule *s3.LifecycleRule) {
+			rule.Status = aws.String(s3.ExpirationStatusDisabled)
+			rule.Expiration = expiration
+		}, []string{"[warning] disabled: disabled, it isn't simulated"}},
+		{"tagged", func(rule *s3.LifecycleRule) {
+			rule.Filter = &s3.LifecycleRuleFilter{Tag: &s3.Tag{Key: aws.String("k"), Value: aws.String("v")}}
+			rule.Expiration = expiration
+		}, []string{`[warning] tagged: filters by tag, it is simulated for every object under ""`}},
+		{"empty", func(rule *s3.LifecycleRule) {}, []string{"[warning] empty: has no actions"}},
+		{"fine", func(rule *s3.LifecycleRule) {
+			rule.Transitions = transition(30, "STANDARD_IA")
+			rule.Expiration = expiration
+		}, nil},
+	}
+	for _, test := range tests {
+		rule := lifecycleRule(test.name, "logs/")
+		test.setup(rule)
+		got := lintMessages(LintLifecycleRules([]*s3.LifecycleRule{rule}, nil))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// dates are past for the simulation time
+	rule := lifecycleRule("dated", "logs/")
+	rule.Transitions = []*s3.Transition{{Date: aws.Time(time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)), StorageClass: aws.String("GLACIER")}}
+	rule.Expiration = &s3.LifecycleExpiration{Date: aws.Time(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))}
+	transitionPast := `[danger] dated: transition to GLACIER on 2020-03-01 is in the past, every object under "logs/" is archived at once`
+	expirationPast := `[danger] dated: expiration on 2020-06-01 is in the past, every object under "logs/" is expired at once`
+	dates := []struct {
+		now  time.Time
+		want []string
+	}{
+		{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), nil},
+		{time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), []string{transitionPast}},
+		{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), []string{transitionPast, expirationPast}},
+	}
+	for _, test := range dates {
+		sim := NewLifecycleSimulation([]*s3.LifecycleRule{rule}, test.now)
+		got := lintMessages(LintLifecycleRules([]*s3.LifecycleRule{rule}, sim))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("at %v: got %q, want %q", test.now, got, test.want)
+		}
+	}
+}
+
+func TestLifecycleSimulation(t *testing.T) {
+	day := func(month time.Month, d int) time.Time { return time.Date(2020, month, d, 12, 0, 0, 0, time.UTC) }
+	archive := lifecycleRule("archive", "logs/")
+	archive.Transitions = []*s3.Transition{{Days: aws.Int64(30), StorageClass: aws.String("GLACIER")}}
+	archive.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(365)}
+	all := lifecycleRule("all", "")
+	all.Transitions = []*s3.Transition{{Days: aws.Int64(30), StorageClass: aws.String("STANDARD_IA")}}
+	disabled := lifecycleRule("disabled", "")
+	disabled.Status = aws.String(s3.ExpirationStatusDisabled)
+	disabled.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(1)}
+
+	sim := NewLifecycleSimulation([]*s3.LifecycleRule{archive, all, disabled}, day(3, 1))
+	const mb = 1 << 20
+	for _, file := range []*S3File{
+		{Name: "logs/old", Size: mb, LastModified: day(1, 1), StorageClass: "STANDARD"},
+		{Name: "logs/new", Size: mb, LastModified: day(2, 15), StorageClass: "STANDARD"},
+		// too small to transition
+		{Name: "logs/small", Size: 10, LastModified: day(1, 1), StorageClass: "STANDARD"},
+		// already colder
+		{Name: "logs/cold", Size: mb, LastModified: day(1, 1), StorageClass: "DEEP_ARCHIVE"},
+		{Name: "data/a", Size: mb, LastModified: day(1, 1)},
+		{Name: "data/b", Size: mb, LastModified: day(2, 25), StorageClass: "STANDARD"},
+	} {
+		sim.Add(file)
+	}
+
+	type counts struct {
+		Action                    string
+		Due, Soon, Later, Skipped ScanCount
+		First, Last               time.Time
+	}
+	// due at the midnight after the modification time plus the days
+	midnight := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	want := []counts{
+		{"transition to GLACIER after 30 days", ScanCount{1, mb}, ScanCount{1, mb}, ScanCount{}, ScanCount{2, mb + 10},
+			midnight(2020, 2, 1), midnight(2020, 3, 17)},
+		{"expire after 365 days", ScanCount{}, ScanCount{}, ScanCount{4, 3*mb + 10}, ScanCount{},
+			midnight(2021, 1, 1), midnight(2021, 2, 15)},
+		{"transition to STANDARD_IA after 30 days", ScanCount{2, 2 * mb}, ScanCount{2, 2 * mb}, ScanCount{}, ScanCount{2, mb + 10},
+			midnight(2020, 2, 1), midnight(2020, 3, 27)},
+	}
+	var got []counts
+	for _, a := range sim.Actions() {
+		got = append(got, counts{a.Action, a.Due, a.Soon, a.Later, a.Skipped, a.First, a.Last})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got actions\n%+v\nwant\n%+v", got, want)
+	}
+	if sim.Objects != (ScanCount{6, 5*mb + 10}) {
+		t.Errorf("simulated %+v", sim.Objects)
+	}
+	if sim.Overlapping != (ScanCount{4, 3*mb + 10}) {
+		t.Errorf("overlapping %+v", sim.Overlapping)
+	}
+}
@@ -0,0 +1,235 @@
+package pkg
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sort"
+)
+
+const (
+	// ranges keep being split while there are fewer than this many per listing thread
+	rangesPerThread = 4
+	// how deep the prefix tree is discovered
+	maxDiscoveryDepth = 3
+	// a prefix with too many sub prefixes or files to discover is split at these characters
+	splitCharacters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// Keys under Prefix after After up to and including Until, empty bounds are unbounded
+type ListRange struct {
+	Prefix string
+	After  string
+	Until  string
+}
+
+// List one page of a range, more is false if the range has no more files. Files have no ids yet
+type PageLister func(bucketName string, r *ListRange) (files []*S3File, more bool, err error)
+
+// a file found while discovering, or a range still to list
+type listItem struct {
+	file *S3File
+	r    *ListRange
+}
+
+func (item *listItem) key() string {
+	if item.file != nil {
+		return item.file.Name
+	}
+	return item.r.Prefix
+}
+
+// list the first page of a range with ListObjectsV2, see PageLister
+func (manager *S3Manager) ListRangePage(bucketName string, r *ListRange) ([]*S3File, bool, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(r.Prefix),
+	}
+	if r.After != "" {
+		input.StartAfter = aws.String(r.After)
+	}
+	page, err := manager.s3cli.ListObjectsV2(input)
+	if err != nil {
+		return nil, false, err
+	}
+	var files []*S3File
+	for _, object := range page.Contents {
+		key := aws.StringValue(object.Key)
+		if r.Until != "" && key > r.Until {
+			return files, false, nil
+		}
+		files = append(files, listedFile(bucketName, object))
+	}
+	return files, aws.BoolValue(page.IsTruncated) && len(files) > 0, nil
+}
+
+// The direct files and sub prefixes of prefix in key order, nil if there are more than a page of them
+func (manager *S3Manager) discoverPrefix(bucketName string, prefix string) ([]*listItem, error) {
+	page, err := manager.s3cli.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucketName),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	if err != nil || aws.BoolValue(page.IsTruncated) {
+		return nil, err
+	}
+	var items []*listItem
+	for _, object := range page.Contents {
+		items = append(items, &listItem{file: listedFile(bucketName, object)})
+	}
+	for _, p := range page.CommonPrefixes {
+		items = append(items, &listItem{r: &ListRange{Prefix: aws.StringValue(p.Prefix)}})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].key() < items[j].key()
+	})
+	return items, nil
+}
+
+// ranges of prefix split at the character following it
+func splitRange(prefix string) []*listItem {
+	var items []*listItem
+	after := ""
+	for _, c := range splitCharacters {
+		items = append(items, &listItem{r: &ListRange{Prefix: prefix, After: after, Until: prefix + string(c)}})
+		after = prefix + string(c)
+	}
+	return append(items, &listItem{r: &ListRange{Prefix: prefix, After: after}})
+}
+
+// Split the keyspace under prefix into ranges. Prefixes found with the "/" delimiter are discovered
+// further while there are too few ranges, prefixes with too many entries are split by characters
+func (manager *S3Manager) splitKeyspace(bucketName string, prefix string, parallelism int) ([]*listItem, error) {
+	items := []*listItem{{r: &ListRange{Prefix: prefix}}}
+	for depth := 0; depth < maxDiscoveryDepth; depth++ {
+		ranges := 0
+		for _, item := range items {
+			if item.r != nil {
+				ranges++
+			}
+		}
+		if ranges >= parallelism*rangesPerThread {
+			break
+		}
+		var res []*listItem
+		split := false
+		for _, item := range items {
+			// only whole prefixes are split
+			if item.r == nil || item.r.After != "" || item.r.Until != "" {
+				res = append(res, item)
+				continue
+			}
+			children, err := manager.discoverPrefix(bucketName, item.r.Prefix)
+			if err != nil {
+				return nil, err
+			}
+			if children == nil {
+				children = splitRange(item.r.Prefix)
+			}
+			res = append(res, children...)
+			split = true
+		}
+		items = res
+		if !split {
+			break
+		}
+	}
+	return items, nil
+}
+
+// HandleFiles with ranges of the keyspace listed concurrently, see splitKeyspace. Files are
+// handled one by one in key order with the same ids HandleFiles gives them. lister lists
+// pages of ranges, e.g. on workers, manager lists them itself if lister is nil
+func (manager *S3Manager) HandleFilesParallel(bucketName string, prefix string, parallelism int, lister PageLister, handler func(file *S3File) error) error {
+	if IsLocal(bucketName) || parallelism <= 1 {
+		return manager.HandleFiles(bucketName, prefix, handler)
+	}
+	if lister == nil {
+		lister = manager.ListRangePage
+	}
+	items, err := manager.splitKeyspace(bucketName, prefix, parallelism)
+	if err != nil {
+		return err
+	}
+	GLogger.Debug("listing %v/%v in %v parts", bucketName, prefix, len(items))
+
+	type shard struct {
+		pages chan []*S3File
+		// set before pages is closed
+		err error
+	}
+	shards := make([]*shard, len(items))
+	for i := range items {
+		shards[i] = &shard{pages: make(chan []*S3File, 10)}
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	// ranges are started in key order, so the range handled next is always being listed
+	threads := make(chan struct{}, parallelism)
+	go func() {
+		for i, item := range items {
+			if item.r == nil {
+				continue
+			}
+			select {
+			case threads <- struct{}{}:
+			case <-stop:
+				return
+			}
+			go func(s *shard, r ListRange) {
+				defer func() { <-threads }()
+				defer close(s.pages)
+				for {
+					files, more, err := lister(bucketName, &r)
+					if err != nil {
+						s.err = err
+						return
+					}
+					if len(files) > 0 {
+						select {
+						case s.pages <- files:
+						case <-stop:
+							return
+						}
+						r.After = files[len(files)-1].Name
+					}
+					if !more {
+						return
+					}
+				}
+			}(shards[i], *item.r)
+		}
+	}()
+
+	var Id int64
+	handle := func(file *S3File) bool {
+		Id++
+		file.Id = Id
+		e := handler(file)
+		if e == ErrStopHandling {
+			return false
+		}
+		if e != nil {
+			GLogger.Warning("Exception in handling file %v of bucket %v, reason: %v", file.Name, bucketName, e)
+		}
+		return true
+	}
+	for i, item := range items {
+		if item.file != nil {
+			if !handle(item.file) {
+				return nil
+			}
+			continue
+		}
+		for files := range shards[i].pages {
+			for _, file := range files {
+				if !handle(file) {
+					return nil
+				}
+			}
+		}
+		if shards[i].err != nil {
+			return shards[i].err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// files by name, ranges as "prefix(after,until]"
+func describeItems(items []*listItem) []string {
+	var res []string
+	for _, item := range items {
+		if item.file != nil {
+			res = append(res, item.file.Name)
+		} else {
+			res = append(res, item.r.Prefix+"("+item.r.After+","+item.r.Until+"]")
+		}
+	}
+	return res
+}
+
+func TestSplitRange(t *testing.T) {
+	items := splitRange("p/")
+	if len(items) != len(splitCharacters)+1 {
+		t.Fatalf("%v ranges, want %v", len(items), len(splitCharacters)+1)
+	}
+	got := describeItems(items)
+	if got[0] != "p/(,p/0]" || got[1] != "p/(p/0,p/1]" || got[len(got)-1] != "p/(p/z,]" {
+		t.Errorf("got ranges %v", got)
+	}
+	// the ranges follow each other
+	for i := 1; i < len(items); i++ {
+		if items[i].r.After != items[i-1].r.Until || items[i].r.Prefix != "p/" {
+			t.Errorf("range %v doesn't follow %v", got[i], got[i-1])
+		}
+	}
+}
+
+func TestSplitKeyspace(t *testing.T) {
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	keys := []string{"a.txt", "d1/a.txt", "d1/b.txt", "d1/e/f.txt", "d2/a.txt", "d3/x/y/z/a.txt", "d4/a.txt", "d5/a.txt", "z.txt"}
+	tests := []struct {
+		name        string
+		prefix      string
+		parallelism int
+		want        []string
+	}{
+		// enough ranges for 1 thread
+		{"one level", "", 1, []string{"a.txt", "d1/(,]", "d2/(,]", "d3/(,]", "d4/(,]", "d5/(,]", "z.txt"}},
+		{"max depth", "", 2, []string{"a.txt", "d1/a.txt", "d1/b.txt", "d1/e/f.txt", "d2/a.txt", "d3/x/y/(,]", "d4/a.txt", "d5/a.txt", "z.txt"}},
+		{"under prefix", "d3/", 1, []string{"d3/x/y/z/(,]"}},
+		{"nothing to split", "d1/", 1, []string{"d1/a.txt", "d1/b.txt", "d1/e/f.txt"}},
+	}
+	for _, test := range tests {
+		fake := newFakeS3()
+		for _, key := range keys {
+			fake.put(key, key, modified)
+		}
+		items, err := fake.manager().splitKeyspace("bucket", test.prefix, test.parallelism)
+		fake.server.Close()
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if got := describeItems(items); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// a prefix with more than a page of entries is split by characters
+	fake := newFakeS3()
+	defer fake.server.Close()
+	fake.pageSize = 2
+	for _, key := range keys {
+		fake.put(key, key, modified)
+	}
+	manager := fake.manager()
+	items, err := manager.splitKeyspace("bucket", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describeItems(items); !reflect.DeepEqual(got, describeItems(splitRange(""))) {
+		t.Errorf("truncated discovery: got %v", got)
+	}
+
+	// the ranges list every key once and in order
+	var got []string
+	err = manager.HandleFilesParallel("bucket", "", 4, nil, func(file *S3File) error {
+		got = append(got, file.Name)
+		if file.Id != int64(len(got)) {
+			t.Errorf("file %v has id %v", file.Name, file.Id)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("listed %v, want %v", strings.Join(got, " "), strings.Join(keys, " "))
+	}
+}
+
+func TestHandleFilesFrom(t *testing.T) {
+	fake := newFakeS3()
+	defer fake.server.Close()
+	fake.pageSize = 2
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		fake.put(key, key, time.Now())
+	}
+	manager := fake.manager()
+	list := func(options *ListOptions) ([]string, []ListPosition) {
+		var names []string
+		var positions []ListPosition
+		options.OnPage = func(position *ListPosition) {
+			positions = append(positions, *position)
+		}
+		err := manager.HandleFilesFrom("bucket", "", options, func(file *S3File) error {
+			names = append(names, file.Name+":"+file.Owner)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names, positions
+	}
+
+	names, positions := list(&ListOptions{})
+	if want := []string{"a:", "b:", "c:", "d:", "e:"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listed %v, want %v", names, want)
+	}
+	want := []ListPosition{{1, 2, "b", "b"}, {2, 4, "d", "d"}, {3, 5, "e", ""}}
+	if !reflect.DeepEqual(positions, want) {
+		t.Errorf("got positions %+v, want %+v", positions, want)
+	}
+
+	// resuming after the first page lists the rest, the start key is ignored then
+	names, _ = list(&ListOptions{ContinuationToken: positions[0].ContinuationToken, StartAfter: "d"})
+	if want := []string{"c:", "d:", "e:"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("resumed listing %v, want %v", names, want)
+	}
+	names, _ = list(&ListOptions{StartAfter: "c", FetchOwner: true})
+	if want := []string{"d:owner", "e:owner"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listed %v after c, want %v", names, want)
+	}
+}
@@ -0,0 +1,771 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// ORC files are read without a library: columns of a flat struct of booleans, integers, strings and
+// timestamps, uncompressed, zlib or snappy compressed, in any of the version 1 and 2 encodings, see
+// https://orc.apache.org/specification/ORCv1/
+const (
+	// compression kinds
+	orcNone   = 0
+	orcZlib   = 1
+	orcSnappy = 2
+	// type kinds
+	orcBoolean          = 0
+	orcByte             = 1
+	orcShort            = 2
+	orcInt              = 3
+	orcLong             = 4
+	orcString           = 7
+	orcBinary           = 8
+	orcTimestamp        = 9
+	orcStruct           = 12
+	orcVarchar          = 16
+	orcChar             = 17
+	orcTimestampInstant = 18
+	// stream kinds
+	orcPresent        = 0
+	orcData           = 1
+	orcLength         = 2
+	orcDictionaryData = 3
+	orcSecondary      = 5
+	// column encodings
+	orcDirect       = 0
+	orcDictionary   = 1
+	orcDirectV2     = 2
+	orcDictionaryV2 = 3
+)
+
+// timestamps are seconds since then in the writer's time zone
+var orcTimestampBase = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type orcColumn struct {
+	name string
+	id   int
+	kind uint64
+}
+
+// Read the rows of an ORC file of size bytes, only the columns named by names are decoded. Values
+// are bool, int64, string or time.Time, nil if null. The handler returns false to stop
+func readORC(r io.ReaderAt, size int64, names []string, handler func(row map[string]interface{}) bool) error {
+	if size < 4 {
+		return errors.New("not an ORC file, too short")
+	}
+	last := make([]byte, 1)
+	if _, err := r.ReadAt(last, size-1); err != nil {
+		return err
+	}
+	psLength := int64(last[0])
+	if psLength == 0 || psLength+1 > size {
+		return errors.New("not an ORC file, invalid postscript length")
+	}
+	postscript, err := readProto(r, size-1-psLength, psLength)
+	if err != nil {
+		return fmt.Errorf("invalid ORC postscript, reason: %v", err)
+	}
+	if string(postscript.bytes(8000)) != "ORC" {
+		return errors.New("not an ORC file, no magic in the postscript")
+	}
+	compression := postscript.uint(2)
+	footerLength := int64(postscript.uint(1))
+	if footerLength > size-1-psLength {
+		return errors.New("invalid ORC footer length")
+	}
+	footer, err := readORCMessage(r, size-1-psLength-footerLength, footerLength, compression)
+	if err != nil {
+		return fmt.Errorf("invalid ORC footer, reason: %v", err)
+	}
+	types, err := footer.messages(4)
+	if err != nil || len(types) == 0 || types[0].uint(1) != orcStruct {
+		return errors.New("ORC file isn't a struct of columns")
+	}
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var columns []*orcColumn
+	subtypes, fieldNames := types[0].uints(2), types[0].strings(3)
+	for i, id := range subtypes {
+		if i >= len(fieldNames) || !wanted[fieldNames[i]] || id >= uint64(len(types)) {
+			continue
+		}
+		columns = append(columns, &orcColumn{name: fieldNames[i], id: int(id), kind: types[id].uint(1)})
+	}
+	stripes, err := footer.messages(3)
+	if err != nil {
+		return err
+	}
+	for _, stripe := range stripes {
+		offset, indexLength, dataLength := int64(stripe.uint(1)), int64(stripe.uint(2)), int64(stripe.uint(3))
+		rows := int(stripe.uint(5))
+		stripeFooter, err := readORCMessage(r, offset+indexLength+dataLength, int64(stripe.uint(4)), compression)
+		if err != nil {
+			return fmt.Errorf("invalid ORC stripe footer, reason: %v", err)
+		}
+		streams, err := stripeFooter.messages(1)
+		if err != nil {
+			return err
+		}
+		encodings, err := stripeFooter.messages(2)
+		if err != nil {
+			return err
+		}
+		location := time.UTC
+		if zone := string(stripeFooter.bytes(3)); zone != "" {
+			if location, err = time.LoadLocation(zone); err != nil {
+				return fmt.Errorf("unknown ORC writer time zone %v", zone)
+			}
+		}
+		// streams follow each other from the start of the stripe
+		type streamPosition struct{ offset, length int64 }
+		positions := make(map[[2]uint64]streamPosition)
+		position := offset
+		for _, stream := range streams {
+			length := int64(stream.uint(3))
+			positions[[2]uint64{stream.uint(2), stream.uint(1)}] = streamPosition{position, length}
+			position += length
+		}
+		values := make(map[string][]interface{})
+		for _, column := range columns {
+			if column.id >= len(encodings) {
+				return fmt.Errorf("no encoding of ORC column %v", column.name)
+			}
+			stream := func(kind uint64) ([]byte, error) {
+				p, ok := positions[[2]uint64{uint64(column.id), kind}]
+				if !ok {
+					return nil, nil
+				}
+				b := make([]byte, p.length)
+				if _, err := r.ReadAt(b, p.offset); err != nil {
+					return nil, err
+				}
+				return orcDecompress(compression, b)
+			}
+			encoding := encodings[column.id]
+			timeBase := orcTimestampBase
+			if column.kind == orcTimestamp {
+				timeBase = time.Date(2015, 1, 1, 0, 0, 0, 0, location)
+			}
+			v, err := readORCColumn(column, encoding.uint(1), int(encoding.uint(2)), rows, timeBase, stream)
+			if err != nil {
+				return fmt.Errorf("cannot read ORC column %v, reason: %v", column.name, err)
+			}
+			values[column.name] = v
+		}
+		for i := 0; i < rows; i++ {
+			row := make(map[string]interface{}, len(values))
+			for name, v := range values {
+				row[name] = v[i]
+			}
+			if !handler(row) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// values of a column of a stripe of rows rows
+func readORCColumn(column *orcColumn, encoding uint64, dictionarySize int, rows int, timeBase time.Time, stream func(kind uint64) ([]byte, error)) ([]interface{}, error) {
+	present, err := stream(orcPresent)
+	if err != nil {
+		return nil, err
+	}
+	count := rows
+	var defined []bool
+	if present != nil {
+		if defined, err = orcBooleans(present, rows); err != nil {
+			return nil, err
+		}
+		count = 0
+		for _, d := range defined {
+			if d {
+				count++
+			}
+		}
+	}
+	data, err := stream(orcData)
+	if err != nil {
+		return nil, err
+	}
+	v2 := encoding == orcDirectV2 || encoding == orcDictionaryV2
+	var decoded []interface{}
+	switch column.kind {
+	case orcBoolean:
+		bools, err := orcBooleans(data, count)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range bools {
+			decoded = append(decoded, b)
+		}
+	case orcByte:
+		values, err := orcByteRLE(data, count)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range values {
+			decoded = append(decoded, int64(int8(b)))
+		}
+	case orcShort, orcInt, orcLong:
+		ints, err := orcIntegers(data, count, true, v2)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range ints {
+			decoded = append(decoded, i)
+		}
+	case orcString, orcBinary, orcVarchar, orcChar:
+		lengthData, err := stream(orcLength)
+		if err != nil {
+			return nil, err
+		}
+		if encoding == orcDictionary || encoding == orcDictionaryV2 {
+			dictionaryData, err := stream(orcDictionaryData)
+			if err != nil {
+				return nil, err
+			}
+			dictionary, err := orcStrings(dictionaryData, lengthData, dictionarySize, v2)
+			if err != nil {
+				return nil, err
+			}
+			indices, err := orcIntegers(data, count, false, v2)
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range indices {
+				if i < 0 || i >= int64(len(dictionary)) {
+					return nil, errors.New("dictionary index out of range")
+				}
+				decoded = append(decoded, dictionary[i])
+			}
+		} else {
+			values, err := orcStrings(data, lengthData, count, v2)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range values {
+				decoded = append(decoded, s)
+			}
+		}
+	case orcTimestamp, orcTimestampInstant:
+		secondary, err := stream(orcSecondary)
+		if err != nil {
+			return nil, err
+		}
+		seconds, err := orcIntegers(data, count, true, v2)
+		if err != nil {
+			return nil, err
+		}
+		nanos, err := orcIntegers(secondary, count, false, v2)
+		if err != nil {
+			return nil, err
+		}
+		for i := range seconds {
+			// the lowest 3 bits are the trailing decimal zeros dropped from the nanoseconds, less 1
+			n := nanos[i] >> 3
+			if zeros := nanos[i] & 7; zeros > 0 {
+				for j := int64(0); j <= zeros; j++ {
+					n *= 10
+				}
+			}
+			decoded = append(decoded, timeBase.Add(time.Duration(seconds[i])*time.Second+time.Duration(n)).UTC())
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ORC type %v", column.kind)
+	}
+	if len(decoded) != count {
+		return nil, fmt.Errorf("%v values of %v", len(decoded), count)
+	}
+	values := make([]interface{}, 0, rows)
+	for i, j := 0, 0; i < rows; i++ {
+		if defined != nil && !defined[i] {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, decoded[j])
+		j++
+	}
+	return values, nil
+}
+
+// count strings of data with their lengths in the integer stream lengths
+func orcStrings(data []byte, lengths []byte, count int, v2 bool) ([]string, error) {
+	sizes, err := orcIntegers(lengths, count, false, v2)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, count)
+	for _, size := range sizes {
+		if size < 0 || size > int64(len(data)) {
+			return nil, errors.New("string exceeds its stream")
+		}
+		res = append(res, string(data[:size]))
+		data = data[size:]
+	}
+	return res, nil
+}
+
+// count bytes of the byte run length encoding
+func orcByteRLE(b []byte, count int) ([]byte, error) {
+	res := make([]byte, 0, count)
+	for len(res) < count {
+		if len(b) < 2 {
+			return nil, errors.New("truncated byte run")
+		}
+		if control := int8(b[0]); control >= 0 {
+			for i := 0; i < int(control)+3; i++ {
+				res = append(res, b[1])
+			}
+			b = b[2:]
+		} else {
+			n := -int(control)
+			if len(b) < 1+n {
+				return nil, errors.New("truncated byte literals")
+			}
+			res = append(res, b[1:1+n]...)
+			b = b[1+n:]
+		}
+	}
+	return res[:count], nil
+}
+
+// count booleans, bits of run length encoded bytes with the most significant bit first
+func orcBooleans(b []byte, count int) ([]bool, error) {
+	packed, err := orcByteRLE(b, (count+7)/8)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]bool, count)
+	for i := range res {
+		res[i] = packed[i/8]>>(7-uint(i)%8)&1 == 1
+	}
+	return res, nil
+}
+
+// count integers of the run length encoding of version 1 or 2, signed ones are zigzag encoded
+func orcIntegers(b []byte, count int, signed bool, v2 bool) ([]int64, error) {
+	reader := &orcIntReader{b: b, signed: signed}
+	res := make([]int64, 0, count)
+	for len(res) < count {
+		if len(reader.b) == 0 {
+			return nil, fmt.Errorf("%v integers of %v", len(res), count)
+		}
+		var err error
+		if v2 {
+			res, err = reader.runV2(res)
+		} else {
+			res, err = reader.runV1(res)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res[:count], nil
+}
+
+type orcIntReader struct {
+	b      []byte
+	signed bool
+}
+
+var errORCTruncated = errors.New("truncated integer run")
+
+func (reader *orcIntReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(reader.b)
+	if n <= 0 {
+		return 0, errORCTruncated
+	}
+	reader.b = reader.b[n:]
+	return v, nil
+}
+
+// a varint, zigzag decoded if the integers are signed
+func (reader *orcIntReader) varint(signed bool) (int64, error) {
+	v, err := reader.uvarint()
+	if signed {
+		return unzigzag(v), err
+	}
+	return int64(v), err
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (reader *orcIntReader) value(v uint64) int64 {
+	if reader.signed {
+		return unzigzag(v)
+	}
+	return int64(v)
+}
+
+func (reader *orcIntReader) runV1(res []int64) ([]int64, error) {
+	header := int8(reader.b[0])
+	reader.b = reader.b[1:]
+	if header < 0 {
+		for i := 0; i < -int(header); i++ {
+			v, err := reader.varint(reader.signed)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+		return res, nil
+	}
+	if len(reader.b) < 1 {
+		return nil, errORCTruncated
+	}
+	delta := int64(int8(reader.b[0]))
+	reader.b = reader.b[1:]
+	base, err := reader.varint(reader.signed)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(header)+3; i++ {
+		res = append(res, base+int64(i)*delta)
+	}
+	return res, nil
+}
+
+// bit widths of the 5 bit codes of version 2 runs
+func orcWidth(code byte) int {
+	switch {
+	case code < 24:
+		return int(code) + 1
+	case code < 28:
+		return 26 + int(code-24)*2
+	}
+	return 40 + int(code-28)*8
+}
+
+// the smallest width of the codes holding n bits
+func orcClosestWidth(n int) int {
+	for code := byte(0); code < 32; code++ {
+		if width := orcWidth(code); width >= n {
+			return width
+		}
+	}
+	return 64
+}
+
+// count values of width bits, most significant bit first. The run ends at a byte boundary
+func (reader *orcIntReader) bits(width int, count int) ([]uint64, error) {
+	size := (width*count + 7) / 8
+	if len(reader.b) < size {
+		return nil, errORCTruncated
+	}
+	res := make([]uint64, count)
+	pos := 0
+	for i := range res {
+		var v uint64
+		for bit := 0; bit < width; bit++ {
+			v = v<<1 | uint64(reader.b[pos/8]>>(7-uint(pos)%8)&1)
+			pos++
+		}
+		res[i] = v
+	}
+	reader.b = reader.b[size:]
+	return res, nil
+}
+
+func (reader *orcIntReader) runV2(res []int64) ([]int64, error) {
+	header := reader.b[0]
+	switch header >> 6 {
+	case 0:
+		// short repeat: a value of 1 to 8 bytes repeated 3 to 10 times
+		width := int(header>>3&7) + 1
+		if len(reader.b) < 1+width {
+			return nil, errORCTruncated
+		}
+		var v uint64
+		for _, b := range reader.b[1 : 1+width] {
+			v = v<<8 | uint64(b)
+		}
+		reader.b = reader.b[1+width:]
+		for i := 0; i < int(header&7)+3; i++ {
+			res = append(res, reader.value(v))
+		}
+		return res, nil
+	case 1:
+		// direct: bit packed values
+		if len(reader.b) < 2 {
+			return nil, errORCTruncated
+		}
+		width := orcWidth(header >> 1 & 0x1f)
+		length := int(header&1)<<8 | int(reader.b[1]) + 1
+		reader.b = reader.b[2:]
+		values, err := reader.bits(width, length)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			res = append(res, reader.value(v))
+		}
+		return res, nil
+	case 2:
+		// patched base: bit packed values above a base, a few of them patched with higher bits
+		if len(reader.b) < 4 {
+			return nil, errORCTruncated
+		}
+		width := orcWidth(header >> 1 & 0x1f)
+		length := int(header&1)<<8 | int(reader.b[1]) + 1
+		baseWidth := int(reader.b[2]>>5) + 1
+		patchWidth := orcWidth(reader.b[2] & 0x1f)
+		gapWidth := int(reader.b[3]>>5) + 1
+		patches := int(reader.b[3] & 0x1f)
+		reader.b = reader.b[4:]
+		if len(reader.b) < baseWidth {
+			return nil, errORCTruncated
+		}
+		var base uint64
+		for _, b := range reader.b[:baseWidth] {
+			base = base<<8 | uint64(b)
+		}
+		reader.b = reader.b[baseWidth:]
+		// the most significant bit is the sign
+		sign := uint64(1) << uint(baseWidth*8-1)
+		signedBase := int64(base &^ sign)
+		if base&sign != 0 {
+			signedBase = -signedBase
+		}
+		values, err := reader.bits(width, length)
+		if err != nil {
+			return nil, err
+		}
+		list, err := reader.bits(orcClosestWidth(gapWidth+patchWidth), patches)
+		if err != nil {
+			return nil, err
+		}
+		i := 0
+		for _, patch := range list {
+			i += int(patch >> uint(patchWidth))
+			if i >= length {
+				return nil, errors.New("patch out of range")
+			}
+			values[i] |= (patch & (1<<uint(patchWidth) - 1)) << uint(width)
+		}
+		for _, v := range values {
+			res = append(res, signedBase+int64(v))
+		}
+		return res, nil
+	}
+	// delta: a base and a delta, then bit packed deltas with the sign of the first one
+	if len(reader.b) < 2 {
+		return nil, errORCTruncated
+	}
+	width := 0
+	if code := header >> 1 & 0x1f; code != 0 {
+		width = orcWidth(code)
+	}
+	length := int(header&1)<<8 | int(reader.b[1]) + 1
+	reader.b = reader.b[2:]
+	base, err := reader.varint(reader.signed)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := reader.varint(true)
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, base)
+	if length == 1 {
+		return res, nil
+	}
+	if width == 0 {
+		for i := 1; i < length; i++ {
+			res = append(res, base+int64(i)*delta)
+		}
+		return res, nil
+	}
+	res = append(res, base+delta)
+	deltas, err := reader.bits(width, length-2)
+	if err != nil {
+		return nil, err
+	}
+	v := base + delta
+	for _, d := range deltas {
+		if delta < 0 {
+			v -= int64(d)
+		} else {
+			v += int64(d)
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+// Streams are compressed in chunks of up to the compression block size, each with a 3 byte header
+// of its length and whether it was stored uncompressed
+func orcDecompress(compression uint64, b []byte) ([]byte, error) {
+	if compression == orcNone {
+		return b, nil
+	}
+	var res []byte
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, errors.New("truncated compression chunk")
+		}
+		header := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		length := header >> 1
+		if len(b) < 3+length {
+			return nil, errors.New("truncated compression chunk")
+		}
+		chunk := b[3 : 3+length]
+		b = b[3+length:]
+		if header&1 == 1 {
+			res = append(res, chunk...)
+			continue
+		}
+		switch compression {
+		case orcZlib:
+			inflated, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(chunk)))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, inflated...)
+		case orcSnappy:
+			decoded, err := snappyDecode(chunk)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, decoded...)
+		default:
+			return nil, fmt.Errorf("unsupported ORC compression %v", compression)
+		}
+	}
+	return res, nil
+}
+
+func readORCMessage(r io.ReaderAt, offset int64, length int64, compression uint64) (protoFields, error) {
+	b := make([]byte, length)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	b, err := orcDecompress(compression, b)
+	if err != nil {
+		return nil, err
+	}
+	return parseProto(b)
+}
+
+func readProto(r io.ReaderAt, offset int64, length int64) (protoFields, error) {
+	b := make([]byte, length)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	return parseProto(b)
+}
+
+// fields of a protobuf message by number, values are uint64 or []byte in the order they came
+type protoFields map[uint64][]interface{}
+
+func parseProto(b []byte) (protoFields, error) {
+	fields := make(protoFields)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("truncated protobuf field")
+		}
+		b = b[n:]
+		number := key >> 3
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("truncated protobuf varint")
+			}
+			b = b[n:]
+			fields[number] = append(fields[number], v)
+		case 1:
+			if len(b) < 8 {
+				return nil, errors.New("truncated protobuf field")
+			}
+			fields[number] = append(fields[number], binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case 2:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return nil, errors.New("truncated protobuf field")
+			}
+			fields[number] = append(fields[number], b[n:n+int(length)])
+			b = b[n+int(length):]
+		case 5:
+			if len(b) < 4 {
+				return nil, errors.New("truncated protobuf field")
+			}
+			fields[number] = append(fields[number], uint64(binary.LittleEndian.Uint32(b)))
+			b = b[4:]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %v", key&7)
+		}
+	}
+	return fields, nil
+}
+
+// the last value of a varint field, 0 if there is none
+func (fields protoFields) uint(number uint64) uint64 {
+	values := fields[number]
+	if len(values) == 0 {
+		return 0
+	}
+	v, _ := values[len(values)-1].(uint64)
+	return v
+}
+
+func (fields protoFields) bytes(number uint64) []byte {
+	values := fields[number]
+	if len(values) == 0 {
+		return nil
+	}
+	v, _ := values[len(values)-1].([]byte)
+	return v
+}
+
+// a repeated varint field, packed or not
+func (fields protoFields) uints(number uint64) []uint64 {
+	var res []uint64
+	for _, value := range fields[number] {
+		switch v := value.(type) {
+		case uint64:
+			res = append(res, v)
+		case []byte:
+			for u, n := binary.Uvarint(v); n > 0; u, n = binary.Uvarint(v) {
+				res = append(res, u)
+				v = v[n:]
+			}
+		}
+	}
+	return res
+}
+
+func (fields protoFields) strings(number uint64) []string {
+	var res []string
+	for _, value := range fields[number] {
+		v, _ := value.([]byte)
+		res = append(res, string(v))
+	}
+	return res
+}
+
+func (fields protoFields) messages(number uint64) ([]protoFields, error) {
+	var res []protoFields
+	for _, value := range fields[number] {
+		v, _ := value.([]byte)
+		message, err := parseProto(v)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, message)
+	}
+	return res, nil
+}
@@ -0,0 +1,334 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// a protobuf message of varint and length delimited fields
+type testProto struct {
+	bytes.Buffer
+}
+
+func (p *testProto) varint(v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	p.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func (p *testProto) uint(number uint64, v uint64) {
+	p.varint(number << 3)
+	p.varint(v)
+}
+
+func (p *testProto) bytes(number uint64, b []byte) {
+	p.varint(number<<3 | 2)
+	p.varint(uint64(len(b)))
+	p.Write(b)
+}
+
+// byte runs of literals
+func testORCBytes(b []byte) []byte {
+	var res []byte
+	for len(b) > 0 {
+		n := len(b)
+		if n > 128 {
+			n = 128
+		}
+		res = append(res, byte(-int8(n-1)-1))
+		res = append(res, b[:n]...)
+		b = b[n:]
+	}
+	return res
+}
+
+func testORCBooleans(values ...bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+	for i, v := range values {
+		if v {
+			packed[i/8] |= 0x80 >> (uint(i) % 8)
+		}
+	}
+	return testORCBytes(packed)
+}
+
+// integers of a version 2 DIRECT run of 64 bit values
+func testORCIntegers(signed bool, values ...int64) []byte {
+	res := []byte{0x40 | 31<<1 | byte((len(values)-1)>>8), byte(len(values) - 1)}
+	for _, v := range values {
+		u := uint64(v)
+		if signed {
+			u = zigzag(v)
+		}
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, u)
+		res = append(res, b...)
+	}
+	return res
+}
+
+// integers of a version 1 literal run
+func testORCIntegersV1(values ...int64) []byte {
+	p := &testProto{}
+	p.WriteByte(byte(-int8(len(values)-1) - 1))
+	for _, v := range values {
+		p.varint(uint64(v))
+	}
+	return p.Bytes()
+}
+
+// zlib chunks of a stream, short ones stay uncompressed
+func testORCCompress(t *testing.T, b []byte) []byte {
+	if len(b) < 16 {
+		return append([]byte{byte(len(b))<<1 | 1, 0, 0}, b...)
+	}
+	buf := &bytes.Buffer{}
+	writer, _ := flate.NewWriter(buf, flate.BestCompression)
+	writer.Write(b)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	length := buf.Len() << 1
+	return append([]byte{byte(length), byte(length >> 8), byte(length >> 16)}, buf.Bytes()...)
+}
+
+type testORCStream struct {
+	column int
+	kind   uint64
+	data   []byte
+}
+
+type testORCStripe struct {
+	rows      int
+	streams   []testORCStream
+	encodings []uint64
+	// size of the dictionary of the key column
+	dictionary int
+}
+
+// a zlib compressed ORC file of the struct of the types
+func testORCFile(t *testing.T, names []string, kinds []uint64, stripes []testORCStripe) []byte {
+	buf := bytes.NewBufferString("ORC")
+	var infos [][]byte
+	total := 0
+	for _, stripe := range stripes {
+		offset := buf.Len()
+		footer := &testProto{}
+		for _, stream := range stripe.streams {
+			data := testORCCompress(t, stream.data)
+			buf.Write(data)
+			s := &testProto{}
+			s.uint(1, stream.kind)
+			s.uint(2, uint64(stream.column))
+			s.uint(3, uint64(len(data)))
+			footer.bytes(1, s.Bytes())
+		}
+		for _, kind := range stripe.encodings {
+			e := &testProto{}
+			e.uint(1, kind)
+			if kind == orcDictionaryV2 {
+				e.uint(2, uint64(stripe.dictionary))
+			}
+			footer.bytes(2, e.Bytes())
+		}
+		dataLength := buf.Len() - offset
+		compressed := testORCCompress(t, footer.Bytes())
+		buf.Write(compressed)
+		info := &testProto{}
+		info.uint(1, uint64(offset))
+		info.uint(2, 0)
+		info.uint(3, uint64(dataLength))
+		info.uint(4, uint64(len(compressed)))
+		info.uint(5, uint64(stripe.rows))
+		infos = append(infos, info.Bytes())
+		total += stripe.rows
+	}
+	footer := &testProto{}
+	footer.uint(1, 3)
+	footer.uint(2, uint64(buf.Len()))
+	for _, info := range infos {
+		footer.bytes(3, info)
+	}
+	root := &testProto{}
+	root.uint(1, orcStruct)
+	subtypes := &testProto{}
+	for i := range kinds {
+		subtypes.varint(uint64(i + 1))
+	}
+	root.bytes(2, subtypes.Bytes())
+	for _, name := range names {
+		root.bytes(3, []byte(name))
+	}
+	footer.bytes(4, root.Bytes())
+	for _, kind := range kinds {
+		column := &testProto{}
+		column.uint(1, kind)
+		footer.bytes(4, column.Bytes())
+	}
+	footer.uint(6, uint64(total))
+	compressed := testORCCompress(t, footer.Bytes())
+	buf.Write(compressed)
+	postscript := &testProto{}
+	postscript.uint(1, uint64(len(compressed)))
+	postscript.uint(2, orcZlib)
+	postscript.uint(3, 262144)
+	postscript.bytes(4, []byte{0, 12})
+	postscript.bytes(8000, []byte("ORC"))
+	buf.Write(postscript.Bytes())
+	buf.WriteByte(byte(postscript.Len()))
+	return buf.Bytes()
+}
+
+func TestReadORC(t *testing.T) {
+	names := []string{"bucket", "key", "size", "is_latest", "last_modified_date", "storage_class", "e_tag"}
+	kinds := []uint64{orcString, orcString, orcLong, orcBoolean, orcTimestamp, orcString, orcString}
+	// seconds since 2015 of 2020-01-01
+	seconds := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Sub(orcTimestampBase).Nanoseconds() / int64(time.Second)
+	file := testORCFile(t, names, kinds, []testORCStripe{{
+		rows:       3,
+		dictionary: 2,
+		encodings:  []uint64{orcDirect, orcDirectV2, orcDictionaryV2, orcDirectV2, orcDirect, orcDirectV2, orcDirect, orcDirectV2},
+		streams: []testORCStream{
+			{1, orcData, []byte("bbbbbb")},
+			{1, orcLength, testORCIntegers(false, 2, 2, 2)},
+			{2, orcData, testORCIntegers(false, 1, 0, 1)},
+			{2, orcDictionaryData, []byte("a/1b/2")},
+			{2, orcLength, testORCIntegers(false, 3, 3)},
+			{3, orcPresent, testORCBooleans(true, false, true)},
+			{3, orcData, testORCIntegers(true, 10, -30)},
+			{4, orcData, testORCBooleans(true, false, true)},
+			// 500 milliseconds and 123 microseconds without their trailing zeros
+			{5, orcData, testORCIntegers(true, seconds, seconds, -1)},
+			{5, orcSecondary, testORCIntegers(false, 5<<3|7, 123<<3|2, 0)},
+			{6, orcData, []byte("STANDARDGLACIER")},
+			{6, orcLength, testORCIntegersV1(8, 7, 0)},
+			{7, orcData, []byte("e1e2e3")},
+			{7, orcLength, testORCIntegers(false, 2, 2, 2)},
+		},
+	}, {
+		rows:       1,
+		dictionary: 1,
+		encodings:  []uint64{orcDirect, orcDirectV2, orcDictionaryV2, orcDirectV2, orcDirect, orcDirectV2, orcDirect, orcDirectV2},
+		streams: []testORCStream{
+			{1, orcData, []byte("b")},
+			{1, orcLength, testORCIntegers(false, 1)},
+			{2, orcData, testORCIntegers(false, 0)},
+			{2, orcDictionaryData, []byte("c/3")},
+			{2, orcLength, testORCIntegers(false, 3)},
+			{3, orcData, testORCIntegers(true, 1<<40)},
+			{4, orcData, testORCBooleans(false)},
+			{5, orcData, testORCIntegers(true, 0)},
+			{5, orcSecondary, testORCIntegers(false, 0)},
+			{6, orcData, []byte("")},
+			{6, orcLength, testORCIntegersV1(0)},
+			{7, orcData, []byte("e4")},
+			{7, orcLength, testORCIntegers(false, 2)},
+		},
+	}})
+	var rows []map[string]interface{}
+	err := readORC(bytes.NewReader(file), int64(len(file)), []string{"bucket", "key", "size", "is_latest", "last_modified_date", "storage_class"},
+		func(row map[string]interface{}) bool {
+			rows = append(rows, row)
+			return true
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"bucket=bb is_latest=true key=b/2 last_modified_date=2020-01-01T00:00:00.5Z size=10 storage_class=STANDARD ",
+		"bucket=bb is_latest=false key=a/1 last_modified_date=2020-01-01T00:00:00.000123Z size= storage_class=GLACIER ",
+		"bucket=bb is_latest=true key=b/2 last_modified_date=2014-12-31T23:59:59Z size=-30 storage_class= ",
+		"bucket=b is_latest=false key=c/3 last_modified_date=2015-01-01T00:00:00Z size=1099511627776 storage_class= ",
+	}
+	if got := describeRows(rows); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %q, want %q", got, want)
+	}
+
+	count := 0
+	readORC(bytes.NewReader(file), int64(len(file)), []string{"key"}, func(row map[string]interface{}) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("handled %v rows after stopping, want 1", count)
+	}
+
+	for _, broken := range [][]byte{file[:2], file[:len(file)-1], append(file[:len(file)-1:len(file)-1], 0xff)} {
+		if err := readORC(bytes.NewReader(broken), int64(len(broken)), []string{"key"}, func(row map[string]interface{}) bool { return true }); err == nil {
+			t.Errorf("read a broken file of %v bytes", len(broken))
+		}
+	}
+}
+
+func TestORCIntegers(t *testing.T) {
+	tests := []struct {
+		name   string
+		b      []byte
+		count  int
+		signed bool
+		v2     bool
+		want   []int64
+	}{
+		// the examples of the specification
+		{"v1 run", []byte{0x61, 0x00, 0x07}, 100, false, false, repeatInt64(7, 100)},
+		{"v1 decreasing run", []byte{0x61, 0xff, 0x64}, 3, false, false, []int64{100, 99, 98}},
+		{"v1 literals", []byte{0xfb, 0x02, 0x03, 0x06, 0x07, 0x0b}, 5, false, false, []int64{2, 3, 6, 7, 11}},
+		{"v1 signed literals", []byte{0xfe, 0x03, 0x04}, 2, true, false, []int64{-2, 2}},
+		{"short repeat", []byte{0x0a, 0x27, 0x10}, 5, false, true, repeatInt64(10000, 5)},
+		{"direct", []byte{0x5e, 0x03, 0x5c, 0xa1, 0xab, 0x1e, 0xde, 0xad, 0xbe, 0xef}, 4, false, true,
+			[]int64{23713, 43806, 57005, 48879}},
+		{"patched base", []byte{0x8e, 0x13, 0x2b, 0x21, 0x07, 0xd0, 0x1e, 0x00, 0x14, 0x70, 0x28, 0x32, 0x3c, 0x46, 0x50,
+			0x5a, 0x64, 0x6e, 0x78, 0x82, 0x8c, 0x96, 0xa0, 0xaa, 0xb4, 0xbe, 0xfc, 0xe8}, 20, false, true,
+			[]int64{2030, 2000, 2020, 1000000, 2040, 2050, 2060, 2070, 2080, 2090, 2100, 2110, 2120, 2130, 2140, 2150,
+				2160, 2170, 2180, 2190}},
+		{"delta", []byte{0xc6, 0x09, 0x02, 0x02, 0x22, 0x42, 0x42, 0x46}, 10, false, true,
+			[]int64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29}},
+		{"fixed delta", []byte{0xc0, 0x03, 0x0a, 0x03}, 4, false, true, []int64{10, 8, 6, 4}},
+		{"signed short repeat", []byte{0x00, 0x03}, 3, true, true, []int64{-2, -2, -2}},
+		{"runs", []byte{0x0a, 0x27, 0x10, 0x00, 0x01}, 8, false, true, append(repeatInt64(10000, 5), 1, 1, 1)},
+	}
+	for _, test := range tests {
+		got, err := orcIntegers(test.b, test.count, test.signed, test.v2)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+	if _, err := orcIntegers([]byte{0x5e, 0x03, 0x5c}, 4, false, true); err == nil {
+		t.Error("decoded a truncated run")
+	}
+	if _, err := orcIntegers([]byte{0x0a, 0x27, 0x10}, 6, false, true); err == nil {
+		t.Error("decoded more integers than the runs have")
+	}
+}
+
+func repeatInt64(v int64, n int) []int64 {
+	res := make([]int64, n)
+	for i := range res {
+		res[i] = v
+	}
+	return res
+}
+
+func TestORCBooleans(t *testing.T) {
+	got, err := orcBooleans([]byte{0xff, 0x80}, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, false, false, false, false, false, false, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	run, err := orcByteRLE([]byte{0x61, 0x00, 0xfe, 0x44, 0x45}, 102)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(run, append(make([]byte, 100), 0x44, 0x45)) {
+		t.Errorf("got bytes %v", run)
+	}
+}
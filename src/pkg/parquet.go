@@ -0,0 +1,860 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Parquet files are written without a library: a flat schema of required columns, PLAIN encoded
+// and uncompressed, one data page per column of a row group. That is all readers need, see
+// https://github.com/apache/parquet-format
+const (
+	parquetMagic = "PAR1"
+	// rows of a row group, they are held in memory until the group is written
+	parquetRowGroupRows = 100000
+	// physical types
+	parquetInt64     = 2
+	parquetByteArray = 6
+	// converted types, -1 for none
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+	parquetTimestampMicros = 10
+)
+
+type parquetColumn struct {
+	name          string
+	physicalType  int32
+	convertedType int32
+}
+
+type parquetColumnChunk struct {
+	offset int64
+	size   int64
+}
+
+type parquetRowGroup struct {
+	chunks []parquetColumnChunk
+	rows   int64
+	size   int64
+}
+
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []parquetColumn
+	// encoded values of the row group being written, by column
+	values []*bytes.Buffer
+	rows   int64
+	groups []parquetRowGroup
+	total  int64
+}
+
+func newParquetWriter(w io.Writer, columns []parquetColumn) (*parquetWriter, error) {
+	writer := &parquetWriter{w: w, columns: columns}
+	for range columns {
+		writer.values = append(writer.values, &bytes.Buffer{})
+	}
+	return writer, writer.write([]byte(parquetMagic))
+}
+
+func (writer *parquetWriter) write(b []byte) error {
+	n, err := writer.w.Write(b)
+	writer.offset += int64(n)
+	return err
+}
+
+// Write a row, values are int64 or string in the order of the columns
+func (writer *parquetWriter) Write(row []interface{}) error {
+	for i, value := range row {
+		buf := writer.values[i]
+		switch v := value.(type) {
+		case int64:
+			binary.Write(buf, binary.LittleEndian, v)
+		case string:
+			binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		}
+	}
+	writer.rows++
+	if writer.rows >= parquetRowGroupRows {
+		return writer.flush()
+	}
+	return nil
+}
+
+// write the rows so far as a row group
+func (writer *parquetWriter) flush() error {
+	if writer.rows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: writer.rows}
+	for _, values := range writer.values {
+		header := newThriftWriter()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(values.Len()))
+		header.i32(3, int32(values.Len()))
+		header.structField(5)
+		header.i32(1, int32(writer.rows))
+		header.i32(2, 0) // PLAIN
+		header.i32(3, 3) // RLE levels, required columns have none
+		header.i32(4, 3)
+		header.end()
+		header.end()
+		chunk := parquetColumnChunk{offset: writer.offset, size: int64(header.Len() + values.Len())}
+		if err := writer.write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := writer.write(values.Bytes()); err != nil {
+			return err
+		}
+		values.Reset()
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.size
+	}
+	writer.groups = append(writer.groups, group)
+	writer.total += writer.rows
+	writer.rows = 0
+	return nil
+}
+
+// Write the remaining rows and the footer, the underlying writer isn't closed
+func (writer *parquetWriter) Close() error {
+	if err := writer.flush(); err != nil {
+		return err
+	}
+	footer := newThriftWriter()
+	footer.i32(1, 1)
+	footer.list(2, thriftStruct, len(writer.columns)+1)
+	footer.begin()
+	footer.binary(4, "schema")
+	footer.i32(5, int32(len(writer.columns)))
+	footer.end()
+	for _, column := range writer.columns {
+		footer.begin()
+		footer.i32(1, column.physicalType)
+		footer.i32(3, 0) // REQUIRED
+		footer.binary(4, column.name)
+		if column.convertedType >= 0 {
+			footer.i32(6, column.convertedType)
+		}
+		footer.end()
+	}
+	footer.i64(3, writer.total)
+	footer.list(4, thriftStruct, len(writer.groups))
+	for _, group := range writer.groups {
+		footer.begin()
+		footer.list(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			footer.begin()
+			footer.i64(2, chunk.offset)
+			footer.structField(3)
+			footer.i32(1, writer.columns[i].physicalType)
+			footer.list(2, thriftI32, 1)
+			footer.varint(zigzag(0)) // PLAIN
+			footer.list(3, thriftBinary, 1)
+			footer.varint(uint64(len(writer.columns[i].name)))
+			footer.WriteString(writer.columns[i].name)
+			footer.i32(4, 0) // UNCOMPRESSED
+			footer.i64(5, group.rows)
+			footer.i64(6, chunk.size)
+			footer.i64(7, chunk.size)
+			footer.i64(9, chunk.offset)
+			footer.end()
+			footer.end()
+		}
+		footer.i64(2, group.size)
+		footer.i64(3, group.rows)
+		footer.end()
+	}
+	footer.binary(6, "crazys3")
+	footer.end()
+	if err := writer.write(footer.Bytes()); err != nil {
+		return err
+	}
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(footer.Len()))
+	if err := writer.write(length); err != nil {
+		return err
+	}
+	return writer.write([]byte(parquetMagic))
+}
+
+// types of the thrift compact protocol Parquet metadata is written with
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+type thriftWriter struct {
+	bytes.Buffer
+	// id of the last field of every open struct, fields are written as deltas
+	last []int16
+}
+
+// writer of a struct, end closes it
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	for v >= 0x80 {
+		t.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	t.WriteByte(byte(v))
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	n := len(t.last) - 1
+	if delta := id - t.last[n]; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.WriteByte(kind)
+		t.varint(zigzag(int64(id)))
+	}
+	t.last[n] = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+// a list field of size elements, which are written next
+func (t *thriftWriter) list(id int16, kind byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.WriteByte(byte(size)<<4 | kind)
+	} else {
+		t.WriteByte(0xf0 | kind)
+		t.varint(uint64(size))
+	}
+}
+
+// a struct field, end closes it
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// a struct element of a list, end closes it
+func (t *thriftWriter) begin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) end() {
+	t.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// Parquet files are read the same way: flat columns of the common physical types, PLAIN or dictionary
+// encoded, uncompressed, snappy or gzip compressed, in v1 or v2 data pages. Columns nested in groups
+// or repeated are skipped
+const (
+	parquetBoolean  = 0
+	parquetInt32    = 1
+	parquetInt96    = 3
+	parquetFloat    = 4
+	parquetDouble   = 5
+	parquetFixedLen = 7
+	// repetition types
+	parquetOptional = 1
+	parquetRepeated = 2
+	// encodings
+	parquetPlain           = 0
+	parquetPlainDictionary = 2
+	parquetRLE             = 3
+	parquetRLEDictionary   = 8
+	// compression codecs
+	parquetUncompressed = 0
+	parquetSnappy       = 1
+	parquetGzip         = 2
+	// page types
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+)
+
+type parquetLeaf struct {
+	name         string
+	physicalType int64
+	typeLength   int
+	optional     bool
+	// nanoseconds of a unit of timestamps, 0 if the column holds no timestamps
+	timeUnit int64
+}
+
+// Read the rows of a Parquet file of size bytes, only the columns named by names are decoded. Values
+// are bool, int64, float64, string or time.Time, nil if null. The handler returns false to stop
+func readParquet(r io.ReaderAt, size int64, names []string, handler func(row map[string]interface{}) bool) error {
+	tail := make([]byte, 8)
+	if size < 12 {
+		return errors.New("not a Parquet file, too short")
+	}
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return err
+	}
+	if string(tail[4:]) != parquetMagic {
+		return errors.New("not a Parquet file, no magic at the end")
+	}
+	length := int64(binary.LittleEndian.Uint32(tail))
+	if length <= 0 || length > size-12 {
+		return fmt.Errorf("invalid Parquet footer length %v", length)
+	}
+	footer := make([]byte, length)
+	if _, err := r.ReadAt(footer, size-8-length); err != nil {
+		return err
+	}
+	reader := &thriftReader{b: footer}
+	meta := reader.readStruct()
+	if reader.err != nil {
+		return fmt.Errorf("invalid Parquet footer, reason: %v", reader.err)
+	}
+	leaves := make(map[string]*parquetLeaf)
+	for _, name := range names {
+		leaves[name] = nil
+	}
+	for _, e := range meta.list(2) {
+		element, _ := e.(thriftFields)
+		// the root and groups have children, their columns are nested
+		if element.i64(5) > 0 {
+			continue
+		}
+		name := element.str(4)
+		if _, wanted := leaves[name]; !wanted {
+			continue
+		}
+		if element.i64(3) == parquetRepeated {
+			continue
+		}
+		leaf := &parquetLeaf{name: name, physicalType: element.i64(1), typeLength: int(element.i64(2)),
+			optional: element.i64(3) == parquetOptional}
+		switch {
+		case leaf.physicalType == parquetInt96:
+			leaf.timeUnit = 1
+		case leaf.physicalType != parquetInt64:
+		case element.has(10) && element.fields(10).has(8):
+			unit := element.fields(10).fields(8).fields(2)
+			leaf.timeUnit = int64(time.Millisecond)
+			if unit.has(2) {
+				leaf.timeUnit = int64(time.Microsecond)
+			} else if unit.has(3) {
+				leaf.timeUnit = 1
+			}
+		case element.i64(6) == parquetTimestampMillis:
+			leaf.timeUnit = int64(time.Millisecond)
+		case element.i64(6) == parquetTimestampMicros:
+			leaf.timeUnit = int64(time.Microsecond)
+		}
+		leaves[name] = leaf
+	}
+	for _, g := range meta.list(4) {
+		group, _ := g.(thriftFields)
+		rows := int(group.i64(3))
+		columns := make(map[string][]interface{})
+		for _, c := range group.list(1) {
+			chunk, _ := c.(thriftFields)
+			column := chunk.fields(3)
+			path := column.list(3)
+			if len(path) != 1 {
+				continue
+			}
+			name, _ := path[0].([]byte)
+			leaf := leaves[string(name)]
+			if leaf == nil {
+				continue
+			}
+			values, err := readParquetChunk(r, column, leaf, rows)
+			if err != nil {
+				return fmt.Errorf("cannot read Parquet column %v, reason: %v", leaf.name, err)
+			}
+			columns[leaf.name] = values
+		}
+		for i := 0; i < rows; i++ {
+			row := make(map[string]interface{}, len(columns))
+			for name, values := range columns {
+				row[name] = values[i]
+			}
+			if !handler(row) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// values of a column chunk of a row group of rows rows
+func readParquetChunk(r io.ReaderAt, column thriftFields, leaf *parquetLeaf, rows int) ([]interface{}, error) {
+	offset := column.i64(9)
+	if dictionary := column.i64(11); dictionary > 0 && dictionary < offset {
+		offset = dictionary
+	}
+	data := make([]byte, column.i64(7))
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	codec := column.i64(4)
+	var dictionary []interface{}
+	values := make([]interface{}, 0, rows)
+	for len(values) < rows {
+		reader := &thriftReader{b: data}
+		header := reader.readStruct()
+		if reader.err != nil {
+			return nil, reader.err
+		}
+		size := int(header.i64(3))
+		if size < 0 || reader.pos+size > len(data) {
+			return nil, errors.New("page exceeds the column chunk")
+		}
+		page := data[reader.pos : reader.pos+size]
+		data = data[reader.pos+size:]
+		switch header.i64(1) {
+		case parquetDictionaryPage:
+			page, err := parquetDecompress(codec, page, int(header.i64(2)))
+			if err != nil {
+				return nil, err
+			}
+			dictionary, _, err = parquetPlainValues(page, leaf, int(header.fields(7).i64(1)))
+			if err != nil {
+				return nil, err
+			}
+		case parquetDataPage:
+			page, err := parquetDecompress(codec, page, int(header.i64(2)))
+			if err != nil {
+				return nil, err
+			}
+			h := header.fields(5)
+			count := int(h.i64(1))
+			var defined []uint64
+			if leaf.optional {
+				if len(page) < 4 {
+					return nil, errors.New("truncated definition levels")
+				}
+				n := int(binary.LittleEndian.Uint32(page))
+				if 4+n > len(page) {
+					return nil, errors.New("truncated definition levels")
+				}
+				defined, err = parquetHybrid(page[4:4+n], 1, count)
+				if err != nil {
+					return nil, err
+				}
+				page = page[4+n:]
+			}
+			if values, err = parquetPage(values, page, h.i64(2), leaf, dictionary, count, defined); err != nil {
+				return nil, err
+			}
+		case parquetDataPageV2:
+			h := header.fields(8)
+			count := int(h.i64(1))
+			levels := int(h.i64(5) + h.i64(6))
+			if levels > len(page) {
+				return nil, errors.New("truncated levels")
+			}
+			var defined []uint64
+			if leaf.optional {
+				var err error
+				defined, err = parquetHybrid(page[h.i64(6):levels], 1, count)
+				if err != nil {
+					return nil, err
+				}
+			}
+			page = page[levels:]
+			if !h.has(7) || h.bool(7) {
+				var err error
+				if page, err = parquetDecompress(codec, page, int(header.i64(2))-levels); err != nil {
+					return nil, err
+				}
+			}
+			var err error
+			if values, err = parquetPage(values, page, h.i64(4), leaf, dictionary, count, defined); err != nil {
+				return nil, err
+			}
+		}
+		if len(data) == 0 && len(values) < rows {
+			return nil, fmt.Errorf("%v values of %v rows", len(values), rows)
+		}
+	}
+	return values, nil
+}
+
+// append the count values of a data page, defined are the definition levels of optional columns
+func parquetPage(values []interface{}, page []byte, encoding int64, leaf *parquetLeaf, dictionary []interface{}, count int, defined []uint64) ([]interface{}, error) {
+	present := count
+	if defined != nil {
+		present = 0
+		for _, level := range defined {
+			present += int(level)
+		}
+	}
+	var decoded []interface{}
+	switch encoding {
+	case parquetPlain:
+		var err error
+		if decoded, _, err = parquetPlainValues(page, leaf, present); err != nil {
+			return nil, err
+		}
+	case parquetPlainDictionary, parquetRLEDictionary:
+		if len(page) == 0 {
+			if present > 0 {
+				return nil, errors.New("no dictionary indices")
+			}
+			break
+		}
+		indices, err := parquetHybrid(page[1:], int(page[0]), present)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range indices {
+			if i >= uint64(len(dictionary)) {
+				return nil, errors.New("dictionary index out of range")
+			}
+			decoded = append(decoded, dictionary[i])
+		}
+	case parquetRLE:
+		if leaf.physicalType != parquetBoolean || len(page) < 4 {
+			return nil, errors.New("RLE encoding of a column other than booleans")
+		}
+		bits, err := parquetHybrid(page[4:], 1, present)
+		if err != nil {
+			return nil, err
+		}
+		for _, bit := range bits {
+			decoded = append(decoded, bit == 1)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %v", encoding)
+	}
+	for i, j := 0, 0; i < count; i++ {
+		if defined != nil && defined[i] == 0 {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, decoded[j])
+		j++
+	}
+	return values, nil
+}
+
+// count PLAIN encoded values, and the bytes they took
+func parquetPlainValues(b []byte, leaf *parquetLeaf, count int) ([]interface{}, int, error) {
+	values := make([]interface{}, 0, count)
+	pos := 0
+	need := func(n int) bool { return pos+n <= len(b) }
+	for i := 0; i < count; i++ {
+		switch leaf.physicalType {
+		case parquetBoolean:
+			if i/8 >= len(b) {
+				return nil, 0, errors.New("truncated values")
+			}
+			values = append(values, b[i/8]>>(uint(i)%8)&1 == 1)
+			continue
+		case parquetInt32:
+			if !need(4) {
+				return nil, 0, errors.New("truncated values")
+			}
+			values = append(values, int64(int32(binary.LittleEndian.Uint32(b[pos:]))))
+			pos += 4
+		case parquetInt64:
+			if !need(8) {
+				return nil, 0, errors.New("truncated values")
+			}
+			v := int64(binary.LittleEndian.Uint64(b[pos:]))
+			pos += 8
+			if leaf.timeUnit > 0 {
+				values = append(values, time.Unix(0, 0).UTC().Add(time.Duration(v*leaf.timeUnit)))
+			} else {
+				values = append(values, v)
+			}
+		case parquetInt96:
+			if !need(12) {
+				return nil, 0, errors.New("truncated values")
+			}
+			// nanoseconds of the day and the julian day
+			nanos := int64(binary.LittleEndian.Uint64(b[pos:]))
+			day := int64(binary.LittleEndian.Uint32(b[pos+8:]))
+			pos += 12
+			values = append(values, time.Unix((day-2440588)*86400, nanos).UTC())
+		case parquetFloat:
+			if !need(4) {
+				return nil, 0, errors.New("truncated values")
+			}
+			values = append(values, float64(math.Float32frombits(binary.LittleEndian.Uint32(b[pos:]))))
+			pos += 4
+		case parquetDouble:
+			if !need(8) {
+				return nil, 0, errors.New("truncated values")
+			}
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(b[pos:])))
+			pos += 8
+		case parquetByteArray:
+			if !need(4) {
+				return nil, 0, errors.New("truncated values")
+			}
+			n := int(binary.LittleEndian.Uint32(b[pos:]))
+			pos += 4
+			if n < 0 || !need(n) {
+				return nil, 0, errors.New("truncated values")
+			}
+			values = append(values, string(b[pos:pos+n]))
+			pos += n
+		case parquetFixedLen:
+			if !need(leaf.typeLength) {
+				return nil, 0, errors.New("truncated values")
+			}
+			values = append(values, string(b[pos:pos+leaf.typeLength]))
+			pos += leaf.typeLength
+		default:
+			return nil, 0, fmt.Errorf("unsupported physical type %v", leaf.physicalType)
+		}
+	}
+	return values, pos, nil
+}
+
+// count values of the RLE/bit-packing hybrid encoding of levels, dictionary indices and booleans
+func parquetHybrid(b []byte, width int, count int) ([]uint64, error) {
+	if width > 64 {
+		return nil, fmt.Errorf("invalid bit width %v", width)
+	}
+	values := make([]uint64, 0, count)
+	for len(values) < count {
+		header, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("truncated run")
+		}
+		b = b[n:]
+		if header&1 == 0 {
+			// a run of one value in the next bytes
+			bytes := (width + 7) / 8
+			if len(b) < bytes {
+				return nil, errors.New("truncated run")
+			}
+			var v uint64
+			for i := bytes - 1; i >= 0; i-- {
+				v = v<<8 | uint64(b[i])
+			}
+			b = b[bytes:]
+			for i := uint64(0); i < header>>1 && len(values) < count; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+		// groups of 8 values of width bits, least significant bit first
+		n = int(header>>1) * 8
+		if len(b) < n*width/8 {
+			return nil, errors.New("truncated bit-packed run")
+		}
+		for i := 0; i < n; i++ {
+			var v uint64
+			for bit := 0; bit < width; bit++ {
+				pos := i*width + bit
+				v |= uint64(b[pos/8]>>(uint(pos)%8)&1) << uint(bit)
+			}
+			if len(values) < count {
+				values = append(values, v)
+			}
+		}
+		b = b[n*width/8:]
+	}
+	return values, nil
+}
+
+func parquetDecompress(codec int64, b []byte, size int) ([]byte, error) {
+	switch codec {
+	case parquetUncompressed:
+		return b, nil
+	case parquetSnappy:
+		return snappyDecode(b)
+	case parquetGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		res := make([]byte, 0, size)
+		buf := bytes.NewBuffer(res)
+		_, err = io.Copy(buf, reader)
+		return buf.Bytes(), err
+	}
+	return nil, fmt.Errorf("unsupported compression codec %v", codec)
+}
+
+// fields of a thrift struct by id. Values are int64, bool, float64, []byte, []interface{} or thriftFields
+type thriftFields map[int16]interface{}
+
+func (fields thriftFields) has(id int16) bool {
+	_, ok := fields[id]
+	return ok
+}
+
+func (fields thriftFields) i64(id int16) int64 {
+	v, _ := fields[id].(int64)
+	return v
+}
+
+func (fields thriftFields) bool(id int16) bool {
+	v, _ := fields[id].(bool)
+	return v
+}
+
+func (fields thriftFields) str(id int16) string {
+	v, _ := fields[id].([]byte)
+	return string(v)
+}
+
+// an empty struct if there is none, so lookups can be chained
+func (fields thriftFields) fields(id int16) thriftFields {
+	v, _ := fields[id].(thriftFields)
+	return v
+}
+
+func (fields thriftFields) list(id int16) []interface{} {
+	v, _ := fields[id].([]interface{})
+	return v
+}
+
+// reads the thrift compact protocol without a schema, the first error stops it
+type thriftReader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+var errThriftTruncated = errors.New("truncated thrift data")
+
+func (t *thriftReader) byte() byte {
+	if t.pos >= len(t.b) {
+		t.err = errThriftTruncated
+		return 0
+	}
+	t.pos++
+	return t.b[t.pos-1]
+}
+
+func (t *thriftReader) uvarint() uint64 {
+	if t.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(t.b[t.pos:])
+	if n <= 0 {
+		t.err = errThriftTruncated
+		return 0
+	}
+	t.pos += n
+	return v
+}
+
+func (t *thriftReader) varint() int64 {
+	v := t.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (t *thriftReader) readStruct() thriftFields {
+	fields := make(thriftFields)
+	var id int16
+	for t.err == nil {
+		header := t.byte()
+		kind := header & 0x0f
+		if kind == 0 {
+			break
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(t.varint())
+		}
+		switch kind {
+		case thriftTrue, thriftFalse:
+			// booleans are in the type of their field
+			fields[id] = kind == thriftTrue
+		default:
+			fields[id] = t.readValue(kind)
+		}
+	}
+	return fields
+}
+
+func (t *thriftReader) readValue(kind byte) interface{} {
+	switch kind {
+	case thriftTrue, thriftFalse:
+		return t.byte() == thriftTrue
+	case thriftByte:
+		return int64(int8(t.byte()))
+	case thriftI16, thriftI32, thriftI64:
+		return t.varint()
+	case thriftDouble:
+		if t.pos+8 > len(t.b) {
+			t.err = errThriftTruncated
+			return float64(0)
+		}
+		t.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(t.b[t.pos-8:]))
+	case thriftBinary:
+		n := t.uvarint()
+		if t.err != nil || uint64(len(t.b)-t.pos) < n {
+			t.err = errThriftTruncated
+			return []byte(nil)
+		}
+		t.pos += int(n)
+		return t.b[t.pos-int(n) : t.pos]
+	case thriftList, thriftSet:
+		header := t.byte()
+		size := uint64(header >> 4)
+		if size == 15 {
+			size = t.uvarint()
+		}
+		if size > uint64(len(t.b)) {
+			t.err = errThriftTruncated
+			return []interface{}(nil)
+		}
+		var list []interface{}
+		for i := uint64(0); i < size && t.err == nil; i++ {
+			list = append(list, t.readValue(header&0x0f))
+		}
+		return list
+	case thriftMap:
+		// maps aren't used by Parquet metadata, their entries are skipped
+		size := t.uvarint()
+		if size > 0 {
+			kinds := t.byte()
+			for i := uint64(0); i < size && t.err == nil; i++ {
+				t.readValue(kinds >> 4)
+				t.readValue(kinds & 0x0f)
+			}
+		}
+		return nil
+	case thriftStruct:
+		return t.readStruct()
+	}
+	t.err = fmt.Errorf("unknown thrift type %v", kind)
+	return nil
+}
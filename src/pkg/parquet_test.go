@@ -0,0 +1,373 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// a page of a test column, values holds the encoded values and entries the number of values of
+// a data page or of entries of a dictionary page
+type testParquetPage struct {
+	pageType int32
+	entries  int
+	encoding int32
+	// definition levels of optional columns
+	defined []uint64
+	values  []byte
+}
+
+type testParquetColumn struct {
+	name         string
+	physicalType int32
+	// -1 for none
+	convertedType int32
+	optional      bool
+	codec         int32
+	// microsecond timestamps as a logical type
+	micros bool
+	pages  []testParquetPage
+}
+
+// levels and indices as runs of single values
+func testHybrid(values []uint64, width int) []byte {
+	var b []byte
+	for _, v := range values {
+		b = append(b, 2)
+		for i := 0; i < (width+7)/8; i++ {
+			b = append(b, byte(v>>(8*uint(i))))
+		}
+	}
+	return b
+}
+
+// snappy blocks of literals only
+func testSnappy(b []byte) []byte {
+	res := make([]byte, binary.MaxVarintLen64)
+	res = res[:binary.PutUvarint(res, uint64(len(b)))]
+	for len(b) > 0 {
+		n := len(b)
+		if n > 60 {
+			n = 60
+		}
+		res = append(res, byte(n-1)<<2)
+		res = append(res, b[:n]...)
+		b = b[n:]
+	}
+	return res
+}
+
+func testCompress(t *testing.T, codec int32, b []byte) []byte {
+	switch codec {
+	case parquetSnappy:
+		return testSnappy(b)
+	case parquetGzip:
+		buf := &bytes.Buffer{}
+		writer := gzip.NewWriter(buf)
+		writer.Write(b)
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	return b
+}
+
+func testParquetValues(values ...interface{}) []byte {
+	buf := &bytes.Buffer{}
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		default:
+			binary.Write(buf, binary.LittleEndian, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+// header and body of a page
+func (page testParquetPage) encode(t *testing.T, codec int32) []byte {
+	header := newThriftWriter()
+	var body []byte
+	if page.pageType == parquetDataPageV2 {
+		levels := testHybrid(page.defined, 1)
+		compressed := testCompress(t, codec, page.values)
+		body = append(levels, compressed...)
+		header.i32(1, parquetDataPageV2)
+		header.i32(2, int32(len(levels)+len(page.values)))
+		header.i32(3, int32(len(body)))
+		header.structField(8)
+		header.i32(1, int32(page.entries))
+		header.i32(3, int32(page.entries))
+		header.i32(4, page.encoding)
+		header.i32(5, int32(len(levels)))
+		header.i32(6, 0)
+		header.end()
+		header.end()
+		return append(header.Bytes(), body...)
+	}
+	if page.defined != nil {
+		levels := testHybrid(page.defined, 1)
+		body = testParquetValues(uint32(len(levels)))
+		body = append(body, levels...)
+	}
+	body = append(body, page.values...)
+	compressed := testCompress(t, codec, body)
+	header.i32(1, page.pageType)
+	header.i32(2, int32(len(body)))
+	header.i32(3, int32(len(compressed)))
+	if page.pageType == parquetDictionaryPage {
+		header.structField(7)
+		header.i32(1, int32(page.entries))
+		header.i32(2, parquetPlain)
+	} else {
+		header.structField(5)
+		header.i32(1, int32(page.entries))
+		header.i32(2, page.encoding)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+	}
+	header.end()
+	header.end()
+	return append(header.Bytes(), compressed...)
+}
+
+// a Parquet file of one row group with the pages of the columns
+func testParquetFile(t *testing.T, rows int64, columns []testParquetColumn) []byte {
+	buf := bytes.NewBufferString(parquetMagic)
+	// offsets of the first data page and of the dictionary page
+	type chunk struct{ offset, dictionary, size int64 }
+	var chunks []chunk
+	for _, column := range columns {
+		start := int64(buf.Len())
+		var c chunk
+		for _, page := range column.pages {
+			if page.pageType == parquetDictionaryPage {
+				c.dictionary = int64(buf.Len())
+			} else if c.offset == 0 {
+				c.offset = int64(buf.Len())
+			}
+			buf.Write(page.encode(t, column.codec))
+		}
+		c.size = int64(buf.Len()) - start
+		chunks = append(chunks, c)
+	}
+	footer := newThriftWriter()
+	footer.i32(1, 1)
+	footer.list(2, thriftStruct, len(columns)+1)
+	footer.begin()
+	footer.binary(4, "schema")
+	footer.i32(5, int32(len(columns)))
+	footer.end()
+	for _, column := range columns {
+		footer.begin()
+		footer.i32(1, column.physicalType)
+		if column.optional {
+			footer.i32(3, parquetOptional)
+		} else {
+			footer.i32(3, 0)
+		}
+		footer.binary(4, column.name)
+		if column.convertedType >= 0 {
+			footer.i32(6, column.convertedType)
+		}
+		if column.micros {
+			// LogicalType TIMESTAMP with the unit MICROS
+			footer.structField(10)
+			footer.structField(8)
+			footer.structField(2)
+			footer.structField(2)
+			footer.end()
+			footer.end()
+			footer.end()
+			footer.end()
+		}
+		footer.end()
+	}
+	footer.i64(3, rows)
+	footer.list(4, thriftStruct, 1)
+	footer.begin()
+	footer.list(1, thriftStruct, len(columns))
+	for i, column := range columns {
+		footer.begin()
+		footer.i64(2, chunks[i].offset)
+		footer.structField(3)
+		footer.i32(1, column.physicalType)
+		footer.list(2, thriftI32, 1)
+		footer.varint(zigzag(parquetPlain))
+		footer.list(3, thriftBinary, 1)
+		footer.varint(uint64(len(column.name)))
+		footer.WriteString(column.name)
+		footer.i32(4, column.codec)
+		footer.i64(5, rows)
+		footer.i64(6, chunks[i].size)
+		footer.i64(7, chunks[i].size)
+		footer.i64(9, chunks[i].offset)
+		if chunks[i].dictionary > 0 {
+			footer.i64(11, chunks[i].dictionary)
+		}
+		footer.end()
+		footer.end()
+	}
+	footer.i64(2, int64(buf.Len()))
+	footer.i64(3, rows)
+	footer.end()
+	footer.end()
+	buf.Write(footer.Bytes())
+	buf.Write(testParquetValues(uint32(footer.Len())))
+	buf.WriteString(parquetMagic)
+	return buf.Bytes()
+}
+
+// rows as the values inventories have, by column name in order
+func describeRows(rows []map[string]interface{}) []string {
+	var res []string
+	for _, row := range rows {
+		var names []string
+		for name := range row {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		s := ""
+		for _, name := range names {
+			s += name + "=" + inventoryValue(row[name]) + " "
+		}
+		res = append(res, s)
+	}
+	return res
+}
+
+func TestReadParquet(t *testing.T) {
+	micros := int64(1577836800123456)
+	file := testParquetFile(t, 5, []testParquetColumn{
+		{name: "key", physicalType: parquetByteArray, convertedType: parquetUTF8, optional: true, codec: parquetSnappy,
+			pages: []testParquetPage{
+				{pageType: parquetDictionaryPage, entries: 2, values: testParquetValues("a/1", "b/2")},
+				{pageType: parquetDataPage, entries: 5, encoding: parquetRLEDictionary, defined: []uint64{1, 1, 0, 1, 1},
+					values: append([]byte{1}, testHybrid([]uint64{0, 1, 1, 0}, 1)...)},
+			}},
+		{name: "size", physicalType: parquetInt64, convertedType: -1, optional: true, codec: parquetGzip,
+			pages: []testParquetPage{
+				{pageType: parquetDataPageV2, entries: 3, encoding: parquetPlain, defined: []uint64{1, 0, 1},
+					values: testParquetValues(int64(10), int64(30))},
+				{pageType: parquetDataPageV2, entries: 2, encoding: parquetPlain, defined: []uint64{1, 1},
+					values: testParquetValues(int64(40), int64(50))},
+			}},
+		{name: "is_latest", physicalType: parquetBoolean, convertedType: -1,
+			pages: []testParquetPage{
+				{pageType: parquetDataPageV2, entries: 5, encoding: parquetRLE,
+					values: append(testParquetValues(uint32(10)), testHybrid([]uint64{1, 0, 1, 1, 0}, 1)...)},
+			}},
+		{name: "last_modified_date", physicalType: parquetInt64, convertedType: -1, micros: true,
+			pages: []testParquetPage{
+				{pageType: parquetDataPage, entries: 5, encoding: parquetPlain,
+					values: testParquetValues(micros, micros, micros, micros, micros)},
+			}},
+		{name: "e_tag", physicalType: parquetByteArray, convertedType: parquetUTF8,
+			pages: []testParquetPage{
+				{pageType: parquetDataPage, entries: 5, encoding: parquetPlain,
+					values: testParquetValues("e1", "e2", "e3", "e4", "e5")},
+			}},
+	})
+	var rows []map[string]interface{}
+	err := readParquet(bytes.NewReader(file), int64(len(file)), []string{"key", "size", "is_latest", "last_modified_date"},
+		func(row map[string]interface{}) bool {
+			rows = append(rows, row)
+			return true
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := "last_modified_date=2020-01-01T00:00:00.123456Z"
+	want := []string{
+		"is_latest=true key=a/1 " + modified + " size=10 ",
+		"is_latest=false key=b/2 " + modified + " size= ",
+		"is_latest=true key= " + modified + " size=30 ",
+		"is_latest=true key=b/2 " + modified + " size=40 ",
+		"is_latest=false key=a/1 " + modified + " size=50 ",
+	}
+	if got := describeRows(rows); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %q, want %q", got, want)
+	}
+
+	// the handler stops the iteration
+	count := 0
+	readParquet(bytes.NewReader(file), int64(len(file)), []string{"key"}, func(row map[string]interface{}) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("handled %v rows after stopping, want 1", count)
+	}
+
+	for _, broken := range [][]byte{file[:8], file[:len(file)-1], append(file[:len(file)-8:len(file)-8], 0xff, 0xff, 0, 0, 'P', 'A', 'R', '1')} {
+		if err := readParquet(bytes.NewReader(broken), int64(len(broken)), []string{"key"}, func(row map[string]interface{}) bool { return true }); err == nil {
+			t.Errorf("read a broken file of %v bytes", len(broken))
+		}
+	}
+}
+
+func TestParquetHybrid(t *testing.T) {
+	tests := []struct {
+		name  string
+		b     []byte
+		width int
+		count int
+		want  []uint64
+	}{
+		{"run", []byte{10, 3}, 2, 5, []uint64{3, 3, 3, 3, 3}},
+		{"wide run", []byte{4, 0x34, 0x12}, 16, 2, []uint64{0x1234, 0x1234}},
+		// the example of the specification
+		{"bit-packed", []byte{3, 0x88, 0xc6, 0xfa}, 3, 8, []uint64{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"padded group", []byte{3, 0x88, 0xc6, 0xfa}, 3, 5, []uint64{0, 1, 2, 3, 4}},
+		{"run and group", []byte{4, 1, 3, 0x88, 0xc6, 0xfa}, 3, 4, []uint64{1, 1, 0, 1}},
+	}
+	for _, test := range tests {
+		got, err := parquetHybrid(test.b, test.width, test.count)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+	if _, err := parquetHybrid([]byte{3, 0x88}, 3, 8); err == nil {
+		t.Error("decoded a truncated group")
+	}
+}
+
+func TestSnappyDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"literal", []byte{3, 2 << 2, 'a', 'b', 'c'}, "abc"},
+		// a copy with a 1 byte offset overlapping what it appends
+		{"overlapping copy", []byte{12, 2 << 2, 'a', 'b', 'c', 5<<2 | 1, 3}, "abcabcabcabc"},
+		// copies with 2 and 4 byte offsets
+		{"long offsets", []byte{8, 1 << 2, 'x', 'y', 3<<2 | 2, 2, 0, 1<<2 | 3, 4, 0, 0, 0}, "xyxyxyxy"},
+		{"long literal", append([]byte{61, 60 << 2, 60}, bytes.Repeat([]byte{'z'}, 61)...), string(bytes.Repeat([]byte{'z'}, 61))},
+	}
+	for _, test := range tests {
+		got, err := snappyDecode(test.b)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+	}
+	for _, broken := range [][]byte{{}, {4, 2 << 2, 'a'}, {4, 0, 'a', 5<<2 | 1, 2}, {5, 0, 'a'}} {
+		if _, err := snappyDecode(broken); err == nil {
+			t.Errorf("decoded the corrupt block %v", broken)
+		}
+	}
+}
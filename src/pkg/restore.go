@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"regexp"
+	"time"
+)
+
+var (
+	restoreOngoing = regexp.MustCompile(`ongoing-request="true"`)
+	restoreExpiry  = regexp.MustCompile(`expiry-date="([^"]+)"`)
+)
+
+// Parse the x-amz-restore header of HEAD, e.g.
+// ongoing-request="false", expiry-date="Fri, 23 Dec 2012 00:00:00 GMT".
+// requested is false if the file was never restored, ongoing while the restoration is running
+func ParseRestore(header string) (requested bool, ongoing bool, expiry time.Time) {
+	if header == "" {
+		return false, false, time.Time{}
+	}
+	ongoing = restoreOngoing.MatchString(header)
+	if m := restoreExpiry.FindStringSubmatch(header); m != nil {
+		expiry, _ = time.Parse(time.RFC1123, m[1])
+	}
+	return true, ongoing, expiry
+}
+
+// archived files must be restored before they can be copied
+func IsArchived(storageClass string) bool {
+	return storageClass == "GLACIER" || storageClass == "DEEP_ARCHIVE"
+}
+
+const (
+	// actions of the restore monitor on restored copies about to expire
+	MonitorReport  = "report"
+	MonitorExtend  = "extend"
+	MonitorRecover = "recover"
+	// restore status of archived files
+	RestoreNone     = "not_restored"
+	RestoreOngoing  = "ongoing"
+	RestoreValid    = "restored"
+	RestoreExpiring = "expiring"
+)
+
+// Restore status of head, an archived file returned by HEAD. Restored copies expiring before deadline are expiring
+func RestoreStatus(head *S3File, deadline time.Time) (status string, expiry time.Time) {
+	requested, ongoing, expiry := ParseRestore(head.Restore)
+	switch {
+	case !requested:
+		return RestoreNone, expiry
+	case ongoing:
+		return RestoreOngoing, expiry
+	case !expiry.IsZero() && expiry.Before(deadline):
+		return RestoreExpiring, expiry
+	default:
+		return RestoreValid, expiry
+	}
+}
+
+// Restore status of the archived files of a bucket. Workers report the files they checked, master merges them
+type RestoreMonitorReport struct {
+	NotRestored ScanCount `json:"not_restored"`
+	Ongoing     ScanCount `json:"ongoing"`
+	// restored copies expiring after the threshold
+	Restored ScanCount `json:"restored"`
+	// restored copies expiring within the threshold, also those extended or recovered
+	Expiring  ScanCount `json:"expiring"`
+	Extended  ScanCount `json:"extended"`
+	Recovered ScanCount `json:"recovered"`
+	// earliest expiry of restored copies which were neither extended nor recovered
+	NextExpiry time.Time `json:"next_expiry"`
+}
+
+// Count head of RestoreStatus, done is the action taken on it, MonitorExtend, MonitorRecover or empty
+func (report *RestoreMonitorReport) Add(head *S3File, status string, expiry time.Time, done string) {
+	count := ScanCount{Objects: 1, Bytes: head.Size}
+	switch status {
+	case RestoreNone:
+		report.NotRestored.add(count)
+	case RestoreOngoing:
+		report.Ongoing.add(count)
+	case RestoreValid:
+		report.Restored.add(count)
+	case RestoreExpiring:
+		report.Expiring.add(count)
+	}
+	switch done {
+	case MonitorExtend:
+		report.Extended.add(count)
+	case MonitorRecover:
+		report.Recovered.add(count)
+	case "":
+		if (status == RestoreValid || status == RestoreExpiring) && !expiry.IsZero() {
+			report.nextExpiry(expiry)
+		}
+	}
+}
+
+func (report *RestoreMonitorReport) nextExpiry(expiry time.Time) {
+	if report.NextExpiry.IsZero() || expiry.Before(report.NextExpiry) {
+		report.NextExpiry = expiry
+	}
+}
+
+func (report *RestoreMonitorReport) Merge(other *RestoreMonitorReport) {
+	report.NotRestored.add(other.NotRestored)
+	report.Ongoing.add(other.Ongoing)
+	report.Restored.add(other.Restored)
+	report.Expiring.add(other.Expiring)
+	report.Extended.add(other.Extended)
+	report.Recovered.add(other.Recovered)
+	if !other.NextExpiry.IsZero() {
+		report.nextExpiry(other.NextExpiry)
+	}
+}
@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRestore(t *testing.T) {
+	expiry := time.Date(2012, 12, 23, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header    string
+		requested bool
+		ongoing   bool
+		expiry    time.Time
+	}{
+		{"", false, false, time.Time{}},
+		{`ongoing-request="true"`, true, true, time.Time{}},
+		{`ongoing-request="false", expiry-date="Sun, 23 Dec 2012 00:00:00 GMT"`, true, false, expiry},
+		{`ongoing-request="false", expiry-date="invalid"`, true, false, time.Time{}},
+	}
+	for _, test := range tests {
+		requested, ongoing, got := ParseRestore(test.header)
+		if requested != test.requested || ongoing != test.ongoing || !got.Equal(test.expiry) {
+			t.Errorf("ParseRestore(%q) = %v, %v, %v", test.header, requested, ongoing, got)
+		}
+	}
+}
+
+func TestRestoreStatus(t *testing.T) {
+	deadline := time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", RestoreNone},
+		{`ongoing-request="true"`, RestoreOngoing},
+		{`ongoing-request="false", expiry-date="Thu, 02 Jan 2020 00:00:00 GMT"`, RestoreExpiring},
+		{`ongoing-request="false", expiry-date="Fri, 03 Jan 2020 00:00:00 GMT"`, RestoreValid},
+		{`ongoing-request="false", expiry-date="Sat, 04 Jan 2020 00:00:00 GMT"`, RestoreValid},
+		// restored without a known expiry
+		{`ongoing-request="false"`, RestoreValid},
+	}
+	for _, test := range tests {
+		got, _ := RestoreStatus(&S3File{Restore: test.header}, deadline)
+		if got != test.want {
+			t.Errorf("RestoreStatus(%q) = %v, want %v", test.header, got, test.want)
+		}
+	}
+}
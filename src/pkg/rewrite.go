@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	RewritePrefix   = "prefix"
+	RewriteRegex    = "regex"
+	RewriteTemplate = "template"
+)
+
+// One rule of a rules file, a line is "<kind> <args...>":
+//
+//	prefix old/prefix/ new/prefix/
+//	regex ^logs/(\d+)/(.*)$ archive/$1/$2
+//	template {yyyy}/{mm}/{dd}/{key}
+//
+// templates know {key}, {dir}, {base} and {yyyy}, {mm}, {dd}, {hh} of the file's LastModified in UTC
+type RewriteRule struct {
+	Kind   string
+	From   string
+	To     string
+	regexp *regexp.Regexp
+}
+
+// compute destination keys of a migration, the first matching rule wins and
+// keys which match no rule are kept
+type KeyRewriter struct {
+	Rules []*RewriteRule
+}
+
+func ParseRewriteRule(line string) (*RewriteRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, errors.New("empty rewrite rule")
+	}
+	rule := &RewriteRule{Kind: fields[0]}
+	switch rule.Kind {
+	case RewritePrefix, RewriteRegex:
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%v rule needs 2 arguments: %v", rule.Kind, line)
+		}
+		rule.From, rule.To = fields[1], fields[2]
+		if rule.Kind == RewriteRegex {
+			exp, err := regexp.Compile(rule.From)
+			if err != nil {
+				return nil, err
+			}
+			rule.regexp = exp
+		}
+	case RewriteTemplate:
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("template rule needs 1 argument: %v", line)
+		}
+		rule.To = fields[1]
+	default:
+		return nil, fmt.Errorf("unknown rewrite rule %v", rule.Kind)
+	}
+	return rule, nil
+}
+
+// load rules from a file, blank lines and lines starting with # are ignored
+func LoadKeyRewriter(filePath string) (*KeyRewriter, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	rewriter := &KeyRewriter{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := ParseRewriteRule(line)
+		if err != nil {
+			return nil, err
+		}
+		rewriter.Rules = append(rewriter.Rules, rule)
+	}
+	return rewriter, scanner.Err()
+}
+
+// destination key of the file. A nil rewriter keeps the key
+func (rewriter *KeyRewriter) Rewrite(file *S3File) string {
+	if rewriter == nil {
+		return file.Name
+	}
+	for _, rule := range rewriter.Rules {
+		switch rule.Kind {
+		case RewritePrefix:
+			if strings.HasPrefix(file.Name, rule.From) {
+				return rule.To + strings.TrimPrefix(file.Name, rule.From)
+			}
+		case RewriteRegex:
+			if rule.regexp.MatchString(file.Name) {
+				return rule.regexp.ReplaceAllString(file.Name, rule.To)
+			}
+		case RewriteTemplate:
+			t := file.LastModified.UTC()
+			dir, base := path.Split(file.Name)
+			return strings.NewReplacer(
+				"{key}", file.Name,
+				"{dir}", strings.TrimSuffix(dir, "/"),
+				"{base}", base,
+				"{yyyy}", t.Format("2006"),
+				"{mm}", t.Format("01"),
+				"{dd}", t.Format("02"),
+				"{hh}", t.Format("15"),
+			).Replace(rule.To)
+		}
+	}
+	return file.Name
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRewriteRule(t *testing.T) {
+	tests := []struct {
+		line string
+		kind string
+		from string
+		to   string
+		ok   bool
+	}{
+		{"prefix old/ new/", RewritePrefix, "old/", "new/", true},
+		{"  prefix   old/   new/  ", RewritePrefix, "old/", "new/", true},
+		{`regex ^logs/(\d+)/(.*)$ archive/$1/$2`, RewriteRegex, `^logs/(\d+)/(.*)$`, "archive/$1/$2", true},
+		{"template {yyyy}/{key}", RewriteTemplate, "", "{yyyy}/{key}", true},
+		{"", "", "", "", false},
+		{"prefix old/", "", "", "", false},
+		{"prefix a b c", "", "", "", false},
+		{"regex ([ x", "", "", "", false},
+		{"template a b", "", "", "", false},
+		{"rename a b", "", "", "", false},
+	}
+	for _, test := range tests {
+		rule, err := ParseRewriteRule(test.line)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseRewriteRule(%q): error %v, want ok=%v", test.line, err, test.ok)
+			continue
+		}
+		if err == nil && (rule.Kind != test.kind || rule.From != test.from || rule.To != test.to) {
+			t.Errorf("ParseRewriteRule(%q) = %v %q %q", test.line, rule.Kind, rule.From, rule.To)
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	rewriter := &KeyRewriter{}
+	for _, line := range []string{
+		"prefix old/ new/",
+		`regex ^logs/(\d+)/(.*)$ archive/$1/$2`,
+		"template {yyyy}/{mm}/{dd}/{hh}/{dir}/{base}",
+	} {
+		rule, err := ParseRewriteRule(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rewriter.Rules = append(rewriter.Rules, rule)
+	}
+	modified := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		rewriter *KeyRewriter
+		key      string
+		want     string
+	}{
+		{rewriter, "old/a.txt", "new/a.txt"},
+		{rewriter, "logs/42/x/y.log", "archive/42/x/y.log"},
+		// the first matching rule wins
+		{rewriter, "old/logs/1/a", "new/logs/1/a"},
+		// templates match every key
+		{rewriter, "data/img/a.png", "2019/03/04/05/data/img/a.png"},
+		{&KeyRewriter{Rules: rewriter.Rules[:2]}, "other/a.txt", "other/a.txt"},
+		{nil, "old/a.txt", "old/a.txt"},
+	}
+	for _, test := range tests {
+		got := test.rewriter.Rewrite(&S3File{Name: test.key, LastModified: modified})
+		if got != test.want {
+			t.Errorf("Rewrite(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
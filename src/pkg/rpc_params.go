@@ -6,6 +6,16 @@ type MigrationRequest struct {
 	DestBucket   string
 	DestFileName string
 	Finished     bool
+	// delete DestFileName instead of copying, used by mirror sync
+	Delete bool
+	// what to do if DestFileName already exists, see ConflictOverwrite
+	ConflictPolicy string
+	// storage class of the copy, STANDARD if empty
+	StorageClass string
+	// changes of metadata and tags, nil keeps them
+	Metadata *MetadataOptions
+	// version history of the file's key oldest first, replayed instead of copying File
+	Versions []*S3File
 }
 
 type RestorationRequest struct {
@@ -20,6 +30,99 @@ type RecoveryRequest struct {
 	File     *S3File
 	Finished bool
 	Bucket   string
+	// storage class of the recovered file, STANDARD if empty
+	StorageClass string
+	// changes of metadata and tags, nil keeps them
+	Metadata *MetadataOptions
+	// version history of the file's key from the first version to recover on, oldest first.
+	// Replayed instead of copying File
+	Versions []*S3File
+	// delete the replayed versions once they are copied, see RecoverFileVersions
+	DeleteReplayed bool
+}
+
+type ArchiveRequest struct {
+	File     *S3File
+	Finished bool
+	Bucket   string
+	// archive storage class, e.g. DEEP_ARCHIVE
+	StorageClass string
+}
+
+type ScanRequest struct {
+	File     *S3File
+	Finished bool
+	Bucket   string
+	// prefix of the scan, files are counted by their top level prefix below it
+	Prefix string
+}
+
+// check the restored copy of File, an archived file, see RestoreStatus
+type RestoreMonitorRequest struct {
+	File     *S3File
+	Finished bool
+	Bucket   string
+	// restored copies expiring within Threshold days are expiring
+	Threshold int64
+	// what to do with expiring copies, MonitorReport, MonitorExtend or MonitorRecover
+	Action string
+	// expiring copies are extended to expire Days days from now
+	Days int64
+	// storage class and metadata of recovered copies like in RecoveryRequest
+	StorageClass string
+	Metadata     *MetadataOptions
+}
+
+// File is a delete marker to remove
+type UndeleteRequest struct {
+	File     *S3File
+	Finished bool
+}
+
+// make File, a noncurrent version, current again or delete the file if Delete
+type RollbackRequest struct {
+	File     *S3File
+	Finished bool
+	Delete   bool
+	// restoration of archived versions
+	Days  int64
+	Speed string
+}
+
+type VerificationRequest struct {
+	File         *S3File
+	Finished     bool
+	SourceBucket string
+	DestBucket   string
+	DestFileName string
+}
+
+// counters of the last job on a worker
+type JobSummary struct {
+	Processed int64
+	Skipped   int64
+	Failed    int64
+}
+
+// credentials renewed by master before the previous ones expire, empty if unchanged
+type CredentialsRequest struct {
+	AwsKey     string
+	AwsSecret  string
+	AwsToken   string
+	AwsKey2    string
+	AwsSecret2 string
+	AwsToken2  string
+}
+
+// a page of a range listed by a worker for master, see PageLister
+type ListRangeRequest struct {
+	Bucket string
+	Range  ListRange
+}
+
+type ListRangeResponse struct {
+	Files []*S3File
+	More  bool
 }
 
 type S3InfoRequest struct {
@@ -28,4 +131,16 @@ type S3InfoRequest struct {
 	Region2   string
 	AwsKey    string
 	AwsSecret string
+	// session token of temporary credentials
+	AwsToken string
+	// credentials of Region2 buckets if they belong to another account
+	AwsKey2    string
+	AwsSecret2 string
+	AwsToken2  string
+	// endpoints of Region1 and Region2 buckets, nil for AWS
+	Endpoint1 *Endpoint
+	Endpoint2 *Endpoint
+	// server side encryption of the job's copies, nil leaves it to the bucket default. SSE-C keys are
+	// sent once per job with the credentials, net/rpc doesn't encrypt them
+	Encryption *EncryptionOptions
 }
@@ -2,19 +2,24 @@ package pkg
 
 import (
 	"context"
+	"errors"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-	"sync/atomic"
+	"os"
+	"strings"
+	"time"
 )
 
 type S3Manager struct {
-	s3cli   *s3.S3
-	profile string
-	region  string
-	cred    *credentials.Credentials
+	s3cli    *s3.S3
+	profile  string
+	region   string
+	cred     *credentials.Credentials
+	endpoint *Endpoint
 }
 
 type S3File struct {
@@ -23,68 +28,106 @@ type S3File struct {
 	Name         string
 	Size         int64
 	StorageClass string
+	ETag         string
+	LastModified time.Time
+	// empty unless listed by HandleFileVersions
+	VersionId      string
+	IsLatest       bool
+	IsDeleteMarker bool
+	// x-amz-restore header, only set by HEAD, see ParseRestore
+	Restore string
+	// canonical ID of the owner, only listed if asked for, see ListOptions
+	Owner string
 }
 
-// make sure you have ~/.aws/credentials
-func NewS3Manager(region, profile string) (*S3Manager, error) {
-	cred := credentials.NewSharedCredentials("", profile)
-	sess, err := session.NewSession(&aws.Config{
+// returned by a HandleFiles handler to stop listing without reporting an error
+var ErrStopHandling = errors.New("stop handling files")
+
+// make sure you have ~/.aws/credentials or ~/.aws/config. endpoint is nil for AWS.
+// Managers of the same profile share credentials, so a role is assumed only once
+func NewS3Manager(region, profile string, endpoint *Endpoint) (*S3Manager, error) {
+	profileCredsMutex.Lock()
+	defer profileCredsMutex.Unlock()
+	config := &aws.Config{
 		Region:      aws.String(region),
-		Credentials: cred,
-	})
+		Credentials: profileCreds[profile],
+	}
+	if err := endpoint.apply(config); err != nil {
+		return nil, err
+	}
+	sess, err := newProfileSession(profile, config)
 	if err != nil {
 		return nil, err
 	}
+	profileCreds[profile] = sess.Config.Credentials
 	manager := &S3Manager{
-		s3cli:   s3.New(sess),
-		profile: profile,
-		region:  region,
-		cred:    cred,
+		s3cli:    s3.New(sess),
+		profile:  profile,
+		region:   region,
+		cred:     sess.Config.Credentials,
+		endpoint: endpoint,
 	}
 	return manager, nil
 }
 
-func NewS3ManagerWithKey(region, key, secret string) (*S3Manager, error) {
-	cred := credentials.NewStaticCredentials(key, secret, "")
-	sess, err := session.NewSession(&aws.Config{
+// manager of a worker, see PushedCredentials
+func NewS3ManagerWithCredentials(region string, pushed *PushedCredentials, endpoint *Endpoint) (*S3Manager, error) {
+	cred := pushed.cred
+	config := &aws.Config{
 		Region:      aws.String(region),
 		Credentials: cred,
-	})
+	}
+	if err := endpoint.apply(config); err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(config)
 	if err != nil {
 		return nil, err
 	}
 	manager := &S3Manager{
-		s3cli:  s3.New(sess),
-		region: region,
-		cred:   cred,
+		s3cli:    s3.New(sess),
+		region:   region,
+		cred:     cred,
+		endpoint: endpoint,
 	}
 	return manager, nil
 }
 
-func (manager *S3Manager) GetCredential() (key, secret string) {
-	val, err := manager.cred.Get()
-	if err != nil {
-		return "", ""
-	}
-	return val.AccessKeyID, val.SecretAccessKey
-}
-
 // list all buckets in the account. region doesn't impact the result
 func (manager *S3Manager) ListBuckets() ([]*s3.Bucket, error) {
 	res, err := manager.s3cli.ListBuckets(&s3.ListBucketsInput{})
 	return res.Buckets, err
 }
 
-// get bucket's region
+// get bucket's region. S3 compatible services don't always answer the anonymous lookup,
+// they are asked for the bucket location instead
 func (manager *S3Manager) GetBucketRegion(bucket string) (string, error) {
+	if IsLocal(bucket) {
+		return manager.region, nil
+	}
+	if manager.endpoint != nil && manager.endpoint.URL != "" {
+		res, err := manager.s3cli.GetBucketLocation(&s3.GetBucketLocationInput{
+			Bucket: aws.String(bucket),
+		})
+		if err != nil {
+			return "", err
+		}
+		return s3.NormalizeBucketLocation(aws.StringValue(res.LocationConstraint)), nil
+	}
 	return s3manager.GetBucketRegionWithClient(context.Background(), manager.s3cli, bucket)
 }
 
 // bucket should grant permission for the account to GET acl
 func (manager *S3Manager) GetFileAcls(bucket string, fileName string) (*s3.GetObjectAclOutput, error) {
+	return manager.getFileAcls(&S3File{BucketName: bucket, Name: fileName})
+}
+
+// acls of the file's version, or of the current version if the file has no version id
+func (manager *S3Manager) getFileAcls(file *S3File) (*s3.GetObjectAclOutput, error) {
 	input := &s3.GetObjectAclInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(fileName),
+		Bucket:    aws.String(file.BucketName),
+		Key:       aws.String(file.Name),
+		VersionId: versionId(file),
 	}
 	res, err := manager.s3cli.GetObjectAcl(input)
 	return res, err
@@ -104,31 +147,86 @@ func (manager *S3Manager) PutFileAcls(bucket string, fileName string, acl *s3.Ge
 	return err
 }
 
+// iterate files under prefix in key order, bucketName may also be a directory, see LocalScheme
 func (manager *S3Manager) HandleFiles(bucketName string, prefix string, handler func(file *S3File) error) error {
-	param := &s3.ListObjectsInput{
-		Bucket: aws.String(bucketName),
-		Prefix: aws.String(prefix),
-	}
-	var (
-		Id      int64
-		pageNum int
-	)
-	err := manager.s3cli.ListObjectsPages(param,
-		func(page *s3.ListObjectsOutput, lastPage bool) bool {
-			pageNum++
-			for i := 0; i < len(page.Contents); i++ {
-				atomic.AddInt64(&Id, 1)
-				s3file := &S3File{
-					Id:           Id,
-					BucketName:   bucketName,
-					Name:         *page.Contents[i].Key,
-					Size:         *page.Contents[i].Size,
-					StorageClass: *page.Contents[i].StorageClass,
-				}
+	return manager.HandleFilesFrom(bucketName, prefix, nil, handler)
+}
+
+// Where a listing is after a page, a listing resumes after it with ListOptions
+type ListPosition struct {
+	Page int
+	// files handled so far
+	Files int64
+	// key of the last file of the page
+	LastKey string
+	// continues the listing after the page, empty after the last page
+	ContinuationToken string
+}
+
+type ListOptions struct {
+	// start after this key, ignored if ContinuationToken is set
+	StartAfter        string
+	ContinuationToken string
+	// list the owner of every file. Checksums other than ETags aren't listed by this SDK version
+	FetchOwner bool
+	// called after every page is handled
+	OnPage func(position *ListPosition)
+}
+
+func listedFile(bucketName string, object *s3.Object) *S3File {
+	file := &S3File{
+		BucketName:   bucketName,
+		Name:         aws.StringValue(object.Key),
+		Size:         aws.Int64Value(object.Size),
+		StorageClass: aws.StringValue(object.StorageClass),
+		ETag:         aws.StringValue(object.ETag),
+		LastModified: aws.TimeValue(object.LastModified),
+	}
+	if object.Owner != nil {
+		file.Owner = aws.StringValue(object.Owner.ID)
+	}
+	return file
+}
+
+// HandleFiles with ListObjectsV2, starting or resuming where options say. options may be nil,
+// directories are always walked from the start
+func (manager *S3Manager) HandleFilesFrom(bucketName string, prefix string, options *ListOptions, handler func(file *S3File) error) error {
+	if IsLocal(bucketName) {
+		return handleLocalFiles(bucketName, prefix, handler)
+	}
+	if options == nil {
+		options = &ListOptions{}
+	}
+	param := &s3.ListObjectsV2Input{
+		Bucket:     aws.String(bucketName),
+		Prefix:     aws.String(prefix),
+		FetchOwner: aws.Bool(options.FetchOwner),
+	}
+	if options.ContinuationToken != "" {
+		param.ContinuationToken = aws.String(options.ContinuationToken)
+	} else if options.StartAfter != "" {
+		param.StartAfter = aws.String(options.StartAfter)
+	}
+	position := &ListPosition{}
+	err := manager.s3cli.ListObjectsV2Pages(param,
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			position.Page++
+			for _, object := range page.Contents {
+				position.Files++
+				s3file := listedFile(bucketName, object)
+				s3file.Id = position.Files
 				e := handler(s3file)
+				if e == ErrStopHandling {
+					return false
+				}
 				if e != nil {
 					GLogger.Warning("Exception in handling file %v of bucket %v, reason: %v", s3file.Name, bucketName, e)
 				}
+				position.LastKey = s3file.Name
+			}
+			position.ContinuationToken = aws.StringValue(page.NextContinuationToken)
+			if options.OnPage != nil {
+				options.OnPage(position)
 			}
 			return !lastPage
 		})
@@ -138,33 +236,56 @@ func (manager *S3Manager) HandleFiles(bucketName string, prefix string, handler
 // Copy a file object from source bucket to destination
 // It CAN preserve ACLS
 // wiki: https://docs.aws.amazon.com/AmazonS3/latest/dev/acl-overview.html
-// Source bucket region should be the primitive region
-// cannot migrate two buckets that their regions are different
-// sourceManager's region should be same with source bucket
-func (manager *S3Manager) CopyFile(sourceBucket string, sourceFileName string, destBucket string, destFileName string, sourceManager *S3Manager) error {
-	input := &s3.CopyObjectInput{
-		Bucket:       aws.String(destBucket),
-		CopySource:   aws.String("/" + sourceBucket + "/" + sourceFileName),
-		Key:          aws.String(destFileName),
-		StorageClass: aws.String("STANDARD"),
-	}
-	acl, err := sourceManager.GetFileAcls(sourceBucket, sourceFileName)
+// manager reads the source, destManager writes the destination and may use other credentials
+// or another endpoint. Server side copy needs destManager to be allowed to read the source,
+// if it is denied or the endpoints differ the file is streamed through this machine
+func (manager *S3Manager) CopyFile(file *S3File, destBucket string, destFileName string, options *CopyOptions, destManager *S3Manager) error {
+	// directories have no acls
+	if IsLocal(file.BucketName) {
+		return destManager.uploadFile(file, destBucket, destFileName, options)
+	}
+	if IsLocal(destBucket) {
+		return manager.downloadFile(file, destBucket, destFileName, options)
+	}
+	acl, err := manager.getFileAcls(file)
+	if err != nil {
+		return err
+	}
+	sameAccount, err := manager.sameAccount(destManager)
 	if err != nil {
 		return err
 	}
-	res, err := sourceManager.s3cli.CopyObject(input)
+	if manager.sameEndpoint(destManager) {
+		err = destManager.copyObject(file, destBucket, destFileName, options)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessDenied" && !sameAccount {
+			GLogger.Debug("server side copy of %v denied, streaming it, reason: %v", file.BucketName+"/"+file.Name, err)
+			err = manager.streamObject(file, destBucket, destFileName, options, destManager)
+		}
+	} else {
+		err = manager.streamObject(file, destBucket, destFileName, options, destManager)
+	}
 	if err != nil {
 		return err
 	}
-	GLogger.Debug("copied file %v to %v, res=%v", sourceBucket+"/"+sourceFileName, destBucket+"/"+destFileName, res)
-	err = manager.PutFileAcls(destBucket, destFileName, acl)
+	if !sameAccount {
+		// grants are kept, but the copy is owned by the destination account
+		destAcl, err := destManager.getFileAcls(&S3File{BucketName: destBucket, Name: destFileName})
+		if err != nil {
+			return err
+		}
+		acl.Owner = destAcl.Owner
+	}
+	err = destManager.PutFileAcls(destBucket, destFileName, acl)
 	return err
 }
 
-func (manager *S3Manager) RestoreFile(bucket string, fileName string, days int64, speed string) error {
+// restore the file's version, or the current version if the file has no version id
+func (manager *S3Manager) RestoreFile(file *S3File, days int64, speed string) error {
+	bucket, fileName := file.BucketName, file.Name
 	input := &s3.RestoreObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(fileName),
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(fileName),
+		VersionId: versionId(file),
 		RestoreRequest: &s3.RestoreRequest{
 			Days: aws.Int64(days),
 			GlacierJobParameters: &s3.GlacierJobParameters{
@@ -176,34 +297,99 @@ func (manager *S3Manager) RestoreFile(bucket string, fileName string, days int64
 	if err != nil {
 		return err
 	}
-	GLogger.Debug("restored %v/%v for %v days, speed=%v, res=%v", bucket, fileName, days, speed, result)
+	GLogger.Debug("restored %v/%v(%v) for %v days, speed=%v, res=%v", bucket, fileName, file.VersionId, days, speed, result)
 	return nil
 }
 
 // the prerequisite of recovery is that the file is restored.
-func (manager *S3Manager) RecoverFile(bucket string, fileName string) error {
-	input := &s3.CopyObjectInput{
-		Bucket:       aws.String(bucket),
-		CopySource:   aws.String("/" + bucket + "/" + fileName),
-		Key:          aws.String(fileName),
-		StorageClass: aws.String("STANDARD"),
-	}
-	acl, err := manager.GetFileAcls(bucket, fileName)
+// the file is copied in place, with STANDARD storage class unless options say otherwise.
+// copying a noncurrent version makes it the current version, see CopyFileVersions
+func (manager *S3Manager) RecoverFile(file *S3File, options *CopyOptions) error {
+	acl, err := manager.getFileAcls(file)
 	if err != nil {
 		return err
 	}
-	res, err := manager.s3cli.CopyObject(input)
+	err = manager.copyObject(file, file.BucketName, file.Name, options)
 	if err != nil {
 		return err
 	}
-	GLogger.Debug("copied file %v to %v, res=%v", bucket+"/"+fileName, bucket+"/"+fileName, res)
-	err = manager.PutFileAcls(bucket, fileName, acl)
+	err = manager.PutFileAcls(file.BucketName, file.Name, acl)
 	return err
 }
 
+// HEAD a file, returns nil if the file doesn't exist
+func (manager *S3Manager) GetFile(bucket string, fileName string) (*S3File, error) {
+	return manager.GetFileVersion(&S3File{BucketName: bucket, Name: fileName})
+}
+
+// HEAD the file's version, or the current version if the file has no version id
+func (manager *S3Manager) GetFileVersion(file *S3File) (*S3File, error) {
+	bucket, fileName := file.BucketName, file.Name
+	if IsLocal(bucket) {
+		return getLocalFile(bucket, fileName)
+	}
+	input := &s3.HeadObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(fileName),
+		VersionId: versionId(file),
+	}
+	res, err := manager.s3cli.HeadObject(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	head := &S3File{
+		BucketName:   bucket,
+		Name:         fileName,
+		Size:         aws.Int64Value(res.ContentLength),
+		StorageClass: aws.StringValue(res.StorageClass),
+		ETag:         aws.StringValue(res.ETag),
+		LastModified: aws.TimeValue(res.LastModified),
+		VersionId:    aws.StringValue(res.VersionId),
+		Restore:      aws.StringValue(res.Restore),
+	}
+	// HEAD omits the storage class of STANDARD objects
+	if head.StorageClass == "" {
+		head.StorageClass = s3.StorageClassStandard
+	}
+	return head, nil
+}
+
+func (manager *S3Manager) DeleteFile(bucket string, fileName string) error {
+	if IsLocal(bucket) {
+		return deleteLocalFile(bucket, fileName)
+	}
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fileName),
+	}
+	res, err := manager.s3cli.DeleteObject(input)
+	if err != nil {
+		return err
+	}
+	GLogger.Debug("deleted file %v, res=%v", bucket+"/"+fileName, res)
+	return nil
+}
+
+// whether versioning is or was enabled on the bucket
+func (manager *S3Manager) IsVersioned(bucket string) (bool, error) {
+	res, err := manager.s3cli.GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return false, err
+	}
+	return aws.StringValue(res.Status) != "", nil
+}
+
 func (manager *S3Manager) BucketExists(bucket string) bool {
-	input := &s3.ListBucketsInput{
+	if IsLocal(bucket) {
+		info, err := os.Stat(strings.TrimPrefix(bucket, LocalScheme))
+		return err == nil && info.IsDir()
 	}
+	input := &s3.ListBucketsInput{}
 	res, err := manager.s3cli.ListBuckets(input)
 	if err != nil {
 		return false
@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// upper bounds of the size histogram, objects below 128 KB are billed as 128 KB in infrequent
+// access and glacier storage classes
+var sizeBuckets = []struct {
+	Label string
+	Below int64
+}{
+	{"0 B", 1},
+	{"< 128 KB", 128 << 10},
+	{"< 1 MB", 1 << 20},
+	{"< 16 MB", 16 << 20},
+	{"< 128 MB", 128 << 20},
+	{"< 1 GB", 1 << 30},
+	{"< 5 GB", 5 << 30},
+	{">= 5 GB", 1<<63 - 1},
+}
+
+func sizeBucket(size int64) string {
+	for _, b := range sizeBuckets {
+		if size < b.Below {
+			return b.Label
+		}
+	}
+	return sizeBuckets[len(sizeBuckets)-1].Label
+}
+
+type ScanCount struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (count *ScanCount) add(other ScanCount) {
+	count.Objects += other.Objects
+	count.Bytes += other.Bytes
+}
+
+// Statistics of the objects of a bucket. Workers report the objects they scanned, master merges them
+type ScanReport struct {
+	Total ScanCount `json:"total"`
+	// by storage class, top level prefix below the scanned prefix, size and month of last modification
+	StorageClasses map[string]*ScanCount `json:"storage_classes"`
+	Prefixes       map[string]*ScanCount `json:"prefixes"`
+	Sizes          map[string]*ScanCount `json:"sizes"`
+	Months         map[string]*ScanCount `json:"months"`
+	// archived objects being restored and objects with a restored copy
+	Restoring ScanCount `json:"restoring"`
+	Restored  ScanCount `json:"restored"`
+}
+
+func NewScanReport() *ScanReport {
+	return &ScanReport{
+		StorageClasses: make(map[string]*ScanCount),
+		Prefixes:       make(map[string]*ScanCount),
+		Sizes:          make(map[string]*ScanCount),
+		Months:         make(map[string]*ScanCount),
+	}
+}
+
+func addCount(counts map[string]*ScanCount, key string, count ScanCount) {
+	if counts[key] == nil {
+		counts[key] = &ScanCount{}
+	}
+	counts[key].add(count)
+}
+
+// Count file, which was listed under prefix. The restore status is only known of files returned by HEAD
+func (report *ScanReport) Add(file *S3File, prefix string) {
+	count := ScanCount{Objects: 1, Bytes: file.Size}
+	report.Total.add(count)
+	storageClass := file.StorageClass
+	if storageClass == "" {
+		storageClass = "STANDARD"
+	}
+	addCount(report.StorageClasses, storageClass, count)
+	// files directly under prefix are counted under prefix itself
+	top := prefix
+	if i := strings.Index(strings.TrimPrefix(file.Name, prefix), "/"); i >= 0 {
+		top = file.Name[:len(prefix)+i+1]
+	}
+	addCount(report.Prefixes, top, count)
+	addCount(report.Sizes, sizeBucket(file.Size), count)
+	addCount(report.Months, file.LastModified.UTC().Format("2006-01"), count)
+	requested, ongoing, _ := ParseRestore(file.Restore)
+	if ongoing {
+		report.Restoring.add(count)
+	} else if requested {
+		report.Restored.add(count)
+	}
+}
+
+func (report *ScanReport) Merge(other *ScanReport) {
+	report.Total.add(other.Total)
+	report.Restoring.add(other.Restoring)
+	report.Restored.add(other.Restored)
+	for _, m := range []struct{ to, from map[string]*ScanCount }{
+		{report.StorageClasses, other.StorageClasses},
+		{report.Prefixes, other.Prefixes},
+		{report.Sizes, other.Sizes},
+		{report.Months, other.Months},
+	} {
+		for key, count := range m.from {
+			addCount(m.to, key, *count)
+		}
+	}
+}
+
+type scanRow struct {
+	dimension string
+	value     string
+	count     ScanCount
+}
+
+// rows of the report in the order they are printed, sizes ascending and everything else by key
+func (report *ScanReport) rows() []scanRow {
+	rows := []scanRow{{"total", "", report.Total}}
+	sorted := func(dimension string, counts map[string]*ScanCount) {
+		var keys []string
+		for key := range counts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			rows = append(rows, scanRow{dimension, key, *counts[key]})
+		}
+	}
+	sorted("storage_class", report.StorageClasses)
+	sorted("prefix", report.Prefixes)
+	for _, b := range sizeBuckets {
+		if count, ok := report.Sizes[b.Label]; ok {
+			rows = append(rows, scanRow{"size", b.Label, *count})
+		}
+	}
+	sorted("month", report.Months)
+	return append(rows, scanRow{"restore", "restoring", report.Restoring}, scanRow{"restore", "restored", report.Restored})
+}
+
+func (report *ScanReport) WriteTable(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "\t\tobjects\tbytes\t")
+	for _, row := range report.rows() {
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t\n", row.dimension, row.value, row.count.Objects, row.count.Bytes)
+	}
+	return table.Flush()
+}
+
+// one row per dimension and value with columns dimension, value, objects and bytes
+func (report *ScanReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"dimension", "value", "objects", "bytes"})
+	for _, row := range report.rows() {
+		writer.Write([]string{row.dimension, row.value, strconv.FormatInt(row.count.Objects, 10), strconv.FormatInt(row.count.Bytes, 10)})
+	}
+	writer.Flush()
+	return writer.Error()
+}
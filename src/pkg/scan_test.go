@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// the keys a report of a single file counts it under, in the order class, prefix, size and month
+func reportKeys(report *ScanReport) []string {
+	var res []string
+	for _, counts := range []map[string]*ScanCount{report.StorageClasses, report.Prefixes, report.Sizes, report.Months} {
+		for key := range counts {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+func TestScanReportAdd(t *testing.T) {
+	modified := time.Date(2020, 3, 31, 23, 0, 0, 0, time.FixedZone("CET", -3600))
+	tests := []struct {
+		name   string
+		prefix string
+		file   S3File
+		want   []string
+	}{
+		{"no class", "", S3File{Name: "a.txt"}, []string{"STANDARD", "", "0 B", "2020-04"}},
+		{"top level prefix", "", S3File{Name: "a/b/c.txt", StorageClass: "GLACIER", Size: 1}, []string{"GLACIER", "a/", "< 128 KB", "2020-04"}},
+		{"directly under prefix", "logs/", S3File{Name: "logs/a.txt", Size: 128<<10 - 1}, []string{"STANDARD", "logs/", "< 128 KB", "2020-04"}},
+		{"below prefix", "logs/", S3File{Name: "logs/2020/01/a.txt", Size: 128 << 10}, []string{"STANDARD", "logs/2020/", "< 1 MB", "2020-04"}},
+		{"folder marker", "logs/", S3File{Name: "logs/2020/", Size: 1 << 20}, []string{"STANDARD", "logs/2020/", "< 16 MB", "2020-04"}},
+		{"partial prefix", "lo", S3File{Name: "logs/a.txt", Size: 16 << 20}, []string{"STANDARD", "logs/", "< 128 MB", "2020-04"}},
+		{"below 5 GB", "", S3File{Name: "a", Size: 5<<30 - 1}, []string{"STANDARD", "", "< 5 GB", "2020-04"}},
+		{"5 GB", "", S3File{Name: "a", Size: 5 << 30}, []string{"STANDARD", "", ">= 5 GB", "2020-04"}},
+		{"5 TB", "", S3File{Name: "a", Size: 5 << 40}, []string{"STANDARD", "", ">= 5 GB", "2020-04"}},
+	}
+	for _, test := range tests {
+		file := test.file
+		file.LastModified = modified
+		report := NewScanReport()
+		report.Add(&file, test.prefix)
+		if got := reportKeys(report); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: counted under %q, want %q", test.name, got, test.want)
+		}
+		if report.Total != (ScanCount{1, file.Size}) || *report.Prefixes[test.want[1]] != report.Total {
+			t.Errorf("%v: counted %+v", test.name, report.Total)
+		}
+		if report.Restoring != (ScanCount{}) || report.Restored != (ScanCount{}) {
+			t.Errorf("%v: counted a restore", test.name)
+		}
+	}
+
+	report := NewScanReport()
+	for _, restore := range []string{`ongoing-request="true"`, `ongoing-request="false", expiry-date="Sun, 23 Dec 2012 00:00:00 GMT"`, "", `ongoing-request="true"`} {
+		report.Add(&S3File{Name: "logs/a.txt", Size: 10, StorageClass: "GLACIER", Restore: restore}, "")
+	}
+	if report.Restoring != (ScanCount{2, 20}) || report.Restored != (ScanCount{1, 10}) || report.Total != (ScanCount{4, 40}) {
+		t.Errorf("got restoring %+v, restored %+v of %+v", report.Restoring, report.Restored, report.Total)
+	}
+	if len(report.Prefixes) != 1 || *report.Prefixes["logs/"] != (ScanCount{4, 40}) {
+		t.Errorf("got prefixes %v", report.Prefixes)
+	}
+}
+
+func TestScanReportMerge(t *testing.T) {
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []*S3File{
+		{Name: "a/1", Size: 10, LastModified: modified},
+		{Name: "a/2", Size: 20, LastModified: modified, StorageClass: "GLACIER", Restore: `ongoing-request="true"`},
+		{Name: "b/1", Size: 1 << 20, LastModified: modified.AddDate(0, 1, 0)},
+		{Name: "c", Size: 0, LastModified: modified},
+	}
+	// the merge of reports of parts of the files equals the report of all files
+	all := reportOf(files)
+	for split := 0; split <= len(files); split++ {
+		first, second := NewScanReport(), NewScanReport()
+		for i, file := range files {
+			if i < split {
+				first.Add(file, "")
+			} else {
+				second.Add(file, "")
+			}
+		}
+		merged := NewScanReport()
+		merged.Merge(first)
+		merged.Merge(second)
+		if !reflect.DeepEqual(merged, all) {
+			t.Errorf("split at %v: merged %+v, want %+v", split, merged, all)
+		}
+		// counts of the merged reports aren't shared
+		merged.Merge(first)
+		if !reflect.DeepEqual(first, reportOf(files[:split])) {
+			t.Errorf("split at %v: merging changed the merged report", split)
+		}
+	}
+}
+
+func reportOf(files []*S3File) *ScanReport {
+	report := NewScanReport()
+	for _, file := range files {
+		report.Add(file, "")
+	}
+	return report
+}
+
+func TestScanReportWriteCSV(t *testing.T) {
+	report := NewScanReport()
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	report.Add(&S3File{Name: "b/1", Size: 6 << 30, LastModified: modified}, "")
+	report.Add(&S3File{Name: "a/1", Size: 0, StorageClass: "GLACIER", LastModified: modified.AddDate(0, 1, 0)}, "")
+	report.Add(&S3File{Name: "a/2", Size: 100, StorageClass: "DEEP_ARCHIVE", LastModified: modified, Restore: `ongoing-request="true"`}, "")
+	buf := &bytes.Buffer{}
+	if err := report.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+	// sizes are in ascending order, the other dimensions by key
+	want := "dimension,value,objects,bytes\n" +
+		"total,,3,6442451044\n" +
+		"storage_class,DEEP_ARCHIVE,1,100\n" +
+		"storage_class,GLACIER,1,0\n" +
+		"storage_class,STANDARD,1,6442450944\n" +
+		"prefix,a/,2,100\n" +
+		"prefix,b/,1,6442450944\n" +
+		"size,0 B,1,0\n" +
+		"size,< 128 KB,1,100\n" +
+		"size,>= 5 GB,1,6442450944\n" +
+		"month,2020-01,2,6442451044\n" +
+		"month,2020-02,1,0\n" +
+		"restore,restoring,1,100\n" +
+		"restore,restored,0,0\n"
+	if buf.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", buf.String(), want)
+	}
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errSnappyCorrupt = errors.New("corrupt snappy block")
+
+// Decode a block of the raw snappy format Parquet and ORC compress with, see
+// https://github.com/google/snappy/blob/main/format_description.txt
+func snappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length > 1<<32 {
+		return nil, errSnappyCorrupt
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		tag := src[0]
+		var size, offset int
+		switch tag & 3 {
+		case 0:
+			// literal, longer ones have their length in the next 1 to 4 bytes
+			size = int(tag>>2) + 1
+			src = src[1:]
+			if size > 60 {
+				bytes := size - 60
+				if len(src) < bytes {
+					return nil, errSnappyCorrupt
+				}
+				size = 0
+				for i := bytes - 1; i >= 0; i-- {
+					size = size<<8 | int(src[i])
+				}
+				size++
+				src = src[bytes:]
+			}
+			if size <= 0 || len(src) < size {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+			continue
+		case 1:
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			size = 4 + int(tag>>2)&7
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2:
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 3:
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			size = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, errSnappyCorrupt
+		}
+		// copies may overlap what they append
+		start := len(dst) - offset
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var StorageClasses = []string{"STANDARD", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"}
+
+// objects of at least MinSize bytes are written with StorageClass
+type StorageClassRule struct {
+	MinSize      int64
+	StorageClass string
+}
+
+// decide the storage class of a copied object
+type StorageClassPolicy struct {
+	Default string
+	// sorted by MinSize, descending
+	Rules []*StorageClassRule
+}
+
+// Parse rules like "128KB:STANDARD_IA,1GB:GLACIER", the largest matching MinSize wins
+func NewStorageClassPolicy(defaultClass string, rules string) (*StorageClassPolicy, error) {
+	if defaultClass == "" {
+		defaultClass = "STANDARD"
+	}
+	if !IsStorageClass(defaultClass) {
+		return nil, errors.New("unknown storage class " + defaultClass)
+	}
+	policy := &StorageClassPolicy{Default: defaultClass}
+	for _, r := range strings.Split(rules, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		parts := strings.Split(r, ":")
+		if len(parts) != 2 {
+			return nil, errors.New("storage class rule should be <size>:<class>, got " + r)
+		}
+		size, err := ParseSize(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if !IsStorageClass(parts[1]) {
+			return nil, errors.New("unknown storage class " + parts[1])
+		}
+		policy.Rules = append(policy.Rules, &StorageClassRule{MinSize: size, StorageClass: parts[1]})
+	}
+	sort.Slice(policy.Rules, func(i, j int) bool {
+		return policy.Rules[i].MinSize > policy.Rules[j].MinSize
+	})
+	return policy, nil
+}
+
+// storage class of the file, a nil policy means STANDARD
+func (policy *StorageClassPolicy) Resolve(file *S3File) string {
+	if policy == nil {
+		return "STANDARD"
+	}
+	for _, rule := range policy.Rules {
+		if file.Size >= rule.MinSize {
+			return rule.StorageClass
+		}
+	}
+	return policy.Default
+}
+
+func IsStorageClass(class string) bool {
+	for _, c := range StorageClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// parse sizes like "1024", "128KB", "1.5GB". units are powers of 1024
+func ParseSize(size string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1},
+	}
+	s := strings.ToUpper(strings.TrimSpace(size))
+	factor := 1.0
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %v", size)
+	}
+	return int64(n * factor), nil
+}
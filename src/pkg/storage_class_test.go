@@ -0,0 +1,67 @@
+package pkg
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+		ok   bool
+	}{
+		{"1024", 1024, true},
+		{"0", 0, true},
+		{"10B", 10, true},
+		{"128KB", 128 << 10, true},
+		{"128kb", 128 << 10, true},
+		{" 1.5 GB ", 3 << 29, true},
+		{"2MB", 2 << 20, true},
+		{"1TB", 1 << 40, true},
+		{"", 0, false},
+		{"KB", 0, false},
+		{"-1KB", 0, false},
+		{"12XB", 0, false},
+	}
+	for _, test := range tests {
+		got, err := ParseSize(test.size)
+		if (err == nil) != test.ok || got != test.want {
+			t.Errorf("ParseSize(%q) = %v, %v, want %v, ok=%v", test.size, got, err, test.want, test.ok)
+		}
+	}
+}
+
+func TestNewStorageClassPolicy(t *testing.T) {
+	tests := []struct {
+		defaultClass string
+		rules        string
+		ok           bool
+		// storage class by file size
+		want map[int64]string
+	}{
+		{"", "", true, map[int64]string{0: "STANDARD", 1 << 40: "STANDARD"}},
+		{"STANDARD_IA", "", true, map[int64]string{0: "STANDARD_IA"}},
+		{"STANDARD", "128KB:STANDARD_IA, 1GB:GLACIER", true, map[int64]string{
+			0: "STANDARD", 128<<10 - 1: "STANDARD", 128 << 10: "STANDARD_IA", 1<<30 - 1: "STANDARD_IA", 1 << 30: "GLACIER"}},
+		// the largest matching size wins whatever the order of the rules
+		{"STANDARD", "1GB:DEEP_ARCHIVE,1MB:GLACIER_IR", true, map[int64]string{1 << 20: "GLACIER_IR", 2 << 30: "DEEP_ARCHIVE"}},
+		{"COLD", "", false, nil},
+		{"STANDARD", "1MB:COLD", false, nil},
+		{"STANDARD", "1MB", false, nil},
+		{"STANDARD", "xMB:GLACIER", false, nil},
+	}
+	for _, test := range tests {
+		policy, err := NewStorageClassPolicy(test.defaultClass, test.rules)
+		if (err == nil) != test.ok {
+			t.Errorf("NewStorageClassPolicy(%q, %q): error %v, want ok=%v", test.defaultClass, test.rules, err, test.ok)
+			continue
+		}
+		for size, want := range test.want {
+			if got := policy.Resolve(&S3File{Size: size}); got != want {
+				t.Errorf("policy %q, %q: size %v resolves to %v, want %v", test.defaultClass, test.rules, size, got, want)
+			}
+		}
+	}
+	var policy *StorageClassPolicy
+	if got := policy.Resolve(&S3File{}); got != "STANDARD" {
+		t.Errorf("nil policy resolves to %v", got)
+	}
+}
@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"io"
+	"sync"
+)
+
+const (
+	// a stream holds at most StreamConcurrency+1 parts in memory, larger files use larger parts
+	// so they fit into the 10000 parts of an upload, up to 525 MB for 5 TB
+	StreamPartSize    = 16 << 20
+	StreamConcurrency = 2
+	// parts held by all streams of a process, streams wait until their parts fit. A stream whose
+	// parts alone exceed it takes all of it, so the bound is the larger of both
+	StreamMemory = 1 << 30
+)
+
+var streamBudget = &memoryBudget{cond: sync.NewCond(&sync.Mutex{}), free: StreamMemory}
+
+// bytes which can be taken by streams
+type memoryBudget struct {
+	cond *sync.Cond
+	free int64
+}
+
+// block until n bytes, at most all of the budget, are free and take them. Returns the bytes taken
+func (budget *memoryBudget) acquire(n int64) int64 {
+	if n > StreamMemory {
+		n = StreamMemory
+	}
+	budget.cond.L.Lock()
+	for budget.free < n {
+		budget.cond.Wait()
+	}
+	budget.free -= n
+	budget.cond.L.Unlock()
+	return n
+}
+
+func (budget *memoryBudget) release(n int64) {
+	budget.cond.L.Lock()
+	budget.free += n
+	budget.cond.L.Unlock()
+	budget.cond.Broadcast()
+}
+
+// whether server side copies between the managers are possible at all
+func (manager *S3Manager) sameEndpoint(other *S3Manager) bool {
+	url := func(m *S3Manager) string {
+		if m.endpoint == nil {
+			return ""
+		}
+		return m.endpoint.URL
+	}
+	return url(manager) == url(other)
+}
+
+// account ids by access key id, looked up once
+var accountIds sync.Map
+
+// The account of the manager's credentials, looked up with STS. S3 compatible services have no
+// STS, their users are told apart by access key id
+func (manager *S3Manager) accountId() (string, error) {
+	val, err := manager.GetCredential()
+	if err != nil {
+		return "", err
+	}
+	if manager.endpoint != nil && manager.endpoint.URL != "" {
+		return val.AccessKeyID, nil
+	}
+	if id, ok := accountIds.Load(val.AccessKeyID); ok {
+		return id.(string), nil
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(manager.region), Credentials: manager.cred})
+	if err != nil {
+		return "", err
+	}
+	res, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("cannot look up the account of access key %v, reason: %v", val.AccessKeyID, err)
+	}
+	accountIds.Store(val.AccessKeyID, aws.StringValue(res.Account))
+	return aws.StringValue(res.Account), nil
+}
+
+// whether the managers act as the same account, copies made by other belong to another account if not
+func (manager *S3Manager) sameAccount(other *S3Manager) (bool, error) {
+	if !manager.sameEndpoint(other) {
+		return false, nil
+	}
+	account1, err := manager.accountId()
+	if err != nil {
+		return false, err
+	}
+	account2, err := other.accountId()
+	if err != nil {
+		return false, err
+	}
+	return account1 == account2, nil
+}
+
+type byteCounter int64
+
+func (counter *byteCounter) Write(p []byte) (int, error) {
+	*counter += byteCounter(len(p))
+	return len(p), nil
+}
+
+// Copy file through this worker, reading with manager and writing with destManager. Parts are
+// uploaded while the source is read, the streamed data is checked against the source's length and,
+// if the source ETag is its MD5, against the ETag. A copy failing the check is deleted
+func (manager *S3Manager) streamObject(file *S3File, destBucket string, destFileName string, options *CopyOptions, destManager *S3Manager) error {
+	if options == nil {
+		options = &CopyOptions{}
+	}
+	storageClass := options.StorageClass
+	if storageClass == "" {
+		storageClass = "STANDARD"
+	}
+	head, err := manager.headObject(file, options.Encryption)
+	if err != nil {
+		return err
+	}
+	headers := getHeaders(head, options.Metadata)
+	tagging, err := manager.getTagging(file, options.Metadata)
+	if err != nil {
+		return err
+	}
+	size := aws.Int64Value(head.ContentLength)
+	partSize := int64(StreamPartSize)
+	if min := size/s3manager.MaxUploadParts + 1; min > partSize {
+		partSize = min
+	}
+	taken := streamBudget.acquire(partSize * (StreamConcurrency + 1))
+	defer streamBudget.release(taken)
+	get := &s3.GetObjectInput{
+		Bucket:    aws.String(file.BucketName),
+		Key:       aws.String(file.Name),
+		VersionId: versionId(file),
+		// fail rather than copy a mix of two versions if the file is overwritten meanwhile
+		IfMatch: head.ETag,
+	}
+	if options.Encryption != nil && options.Encryption.SourceCustomerKey != "" {
+		get.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		get.SSECustomerKey = aws.String(options.Encryption.SourceCustomerKey)
+	}
+	obj, err := manager.s3cli.GetObject(get)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	uploader := s3manager.NewUploaderWithClient(destManager.s3cli, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = StreamConcurrency
+	})
+	hash := md5.New()
+	var read byteCounter
+	input := &s3manager.UploadInput{
+		Bucket:             aws.String(destBucket),
+		Key:                aws.String(destFileName),
+		Body:               io.TeeReader(obj.Body, io.MultiWriter(hash, &read)),
+		StorageClass:       aws.String(storageClass),
+		CacheControl:       headers.headers["Cache-Control"],
+		ContentDisposition: headers.headers["Content-Disposition"],
+		ContentEncoding:    headers.headers["Content-Encoding"],
+		ContentLanguage:    headers.headers["Content-Language"],
+		ContentType:        headers.headers["Content-Type"],
+		Metadata:           headers.metadata,
+	}
+	if tagging != "" {
+		input.Tagging = aws.String(tagging)
+	}
+	options.Encryption.resolve(head).applyToUpload(input)
+	res, err := uploader.Upload(input)
+	if err != nil {
+		return err
+	}
+
+	etag, checkEtag := etagChecksum(head)
+	if int64(read) != size {
+		err = fmt.Errorf("streamed %v bytes of %v, expected %v", read, file.BucketName+"/"+file.Name, size)
+	} else if sum := hex.EncodeToString(hash.Sum(nil)); checkEtag && sum != etag {
+		err = fmt.Errorf("checksum %v of streamed %v doesn't match its ETag %v", sum, file.BucketName+"/"+file.Name, etag)
+	}
+	if err != nil {
+		if e := destManager.DeleteFile(destBucket, destFileName); e != nil {
+			GLogger.Warning("Exception in deleting corrupted copy %v, reason: %v", destBucket+"/"+destFileName, e)
+		}
+		return err
+	}
+	GLogger.Debug("streamed file %v to %v, res=%v", file.BucketName+"/"+file.Name, destBucket+"/"+destFileName, res)
+	return nil
+}
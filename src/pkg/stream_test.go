@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"testing"
+)
+
+func TestSameAccount(t *testing.T) {
+	// looked up with STS otherwise
+	accountIds.Store("key1", "111111111111")
+	accountIds.Store("key2", "111111111111")
+	accountIds.Store("key3", "222222222222")
+	manager := func(key string, endpoint *Endpoint) *S3Manager {
+		return &S3Manager{cred: credentials.NewStaticCredentials(key, "secret", ""), endpoint: endpoint}
+	}
+	minio := &Endpoint{URL: "http://minio:9000"}
+	tests := []struct {
+		name   string
+		m1, m2 *S3Manager
+		want   bool
+		err    bool
+	}{
+		{"same key", manager("key1", nil), manager("key1", nil), true, false},
+		{"keys of one account", manager("key1", nil), manager("key2", nil), true, false},
+		{"other account", manager("key1", nil), manager("key3", nil), false, false},
+		{"other endpoint", manager("key1", nil), manager("key1", minio), false, false},
+		{"compatible same key", manager("key1", minio), manager("key1", minio), true, false},
+		{"compatible other key", manager("key1", minio), manager("key2", minio), false, false},
+		{"no credentials", manager("", nil), manager("", nil), false, true},
+		{"no credentials of other", manager("key1", nil), manager("", nil), false, true},
+	}
+	for _, test := range tests {
+		got, err := test.m1.sameAccount(test.m2)
+		if (err != nil) != test.err {
+			t.Errorf("%v: got error %v", test.name, err)
+		}
+		if got != test.want {
+			t.Errorf("%v: sameAccount = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"strings"
+	"time"
+)
+
+// Iterate the version history of every key under prefix, including delete markers.
+// The handler receives all versions of one key at once, oldest first
+func (manager *S3Manager) HandleFileVersions(bucketName string, prefix string, handler func(versions []*S3File) error) error {
+	param := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	}
+	var (
+		Id      int64
+		history []*S3File
+		stopped bool
+	)
+	flush := func() {
+		if len(history) == 0 {
+			return
+		}
+		// listing returns the newest version first
+		for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+			history[i], history[j] = history[j], history[i]
+		}
+		e := handler(history)
+		if e == ErrStopHandling {
+			stopped = true
+		} else if e != nil {
+			GLogger.Warning("Exception in handling versions of file %v of bucket %v, reason: %v", history[0].Name, bucketName, e)
+		}
+		history = nil
+	}
+	err := manager.s3cli.ListObjectVersionsPages(param,
+		func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+			for _, file := range mergeVersions(bucketName, page) {
+				if len(history) > 0 && history[0].Name != file.Name {
+					flush()
+					if stopped {
+						return false
+					}
+				}
+				Id++
+				file.Id = Id
+				history = append(history, file)
+			}
+			return !lastPage
+		})
+	if err != nil || stopped {
+		return err
+	}
+	flush()
+	return nil
+}
+
+// Versions and delete markers of a page in listing order, sorted by key and newest first. They come in
+// separate lists which keep the listing order, so they are merged rather than sorted: timestamps have
+// a precision of one second. Of a version and a marker of the same second, the latest one is newer,
+// otherwise the order is ambiguous and the version is taken as newer
+func mergeVersions(bucketName string, page *s3.ListObjectVersionsOutput) []*S3File {
+	var versions, markers, res []*S3File
+	for _, v := range page.Versions {
+		versions = append(versions, &S3File{
+			BucketName:   bucketName,
+			Name:         aws.StringValue(v.Key),
+			Size:         aws.Int64Value(v.Size),
+			StorageClass: aws.StringValue(v.StorageClass),
+			ETag:         aws.StringValue(v.ETag),
+			LastModified: aws.TimeValue(v.LastModified),
+			VersionId:    aws.StringValue(v.VersionId),
+			IsLatest:     aws.BoolValue(v.IsLatest),
+		})
+	}
+	for _, m := range page.DeleteMarkers {
+		markers = append(markers, &S3File{
+			BucketName:     bucketName,
+			Name:           aws.StringValue(m.Key),
+			LastModified:   aws.TimeValue(m.LastModified),
+			VersionId:      aws.StringValue(m.VersionId),
+			IsLatest:       aws.BoolValue(m.IsLatest),
+			IsDeleteMarker: true,
+		})
+	}
+	// whether the marker comes before the version
+	markerFirst := func(m *S3File, v *S3File) bool {
+		if m.Name != v.Name {
+			return m.Name < v.Name
+		}
+		if !m.LastModified.Equal(v.LastModified) {
+			return m.LastModified.After(v.LastModified)
+		}
+		return m.IsLatest
+	}
+	i, j := 0, 0
+	for i < len(versions) || j < len(markers) {
+		if j < len(markers) && (i == len(versions) || markerFirst(markers[j], versions[i])) {
+			res = append(res, markers[j])
+			j++
+		} else {
+			res = append(res, versions[i])
+			i++
+		}
+	}
+	return res
+}
+
+// Replay the version history of a file onto destBucket/destFileName in chronological order, so the
+// latest version ends up current again. Delete markers are replayed by deleting the destination
+func (manager *S3Manager) CopyFileVersions(versions []*S3File, destBucket string, destFileName string, options *CopyOptions, destManager *S3Manager) error {
+	for _, version := range versions {
+		var err error
+		if version.IsDeleteMarker {
+			err = destManager.DeleteFile(destBucket, destFileName)
+		} else {
+			err = manager.CopyFile(version, destBucket, destFileName, options, destManager)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recover the archived versions of a file in place. versions is the history of the file from the first
+// version to recover on, oldest first. They are copied again in chronological order, so the latest version
+// stays current, archived versions with the storage class of options and others with their own class.
+// Every archived version must be restored, nothing is copied otherwise. If a copy fails, the latest
+// version is copied again so it stays current. deleteReplayed deletes the replayed versions once every
+// copy succeeded, so the history isn't doubled and archived versions aren't billed anymore, but every
+// version gets a new id
+func (manager *S3Manager) RecoverFileVersions(versions []*S3File, options *CopyOptions, deleteReplayed bool) error {
+	if options == nil {
+		options = &CopyOptions{}
+	}
+	for _, version := range versions {
+		if version.IsDeleteMarker || !IsArchived(version.StorageClass) {
+			continue
+		}
+		head, err := manager.GetFileVersion(version)
+		if err != nil {
+			return err
+		}
+		if head == nil {
+			return fmt.Errorf("version %v of %v/%v doesn't exist", version.VersionId, version.BucketName, version.Name)
+		}
+		if requested, ongoing, _ := ParseRestore(head.Restore); !requested || ongoing {
+			return fmt.Errorf("version %v of %v/%v isn't restored", version.VersionId, version.BucketName, version.Name)
+		}
+	}
+	replay := func(version *S3File) error {
+		if version.IsDeleteMarker {
+			return manager.DeleteFile(version.BucketName, version.Name)
+		}
+		if IsArchived(version.StorageClass) {
+			return manager.RecoverFile(version, options)
+		}
+		kept := *options
+		kept.StorageClass = version.StorageClass
+		return manager.RecoverFile(version, &kept)
+	}
+	for i, version := range versions {
+		if err := replay(version); err != nil {
+			// a replayed older version would stay current otherwise
+			if latest := versions[len(versions)-1]; i > 0 {
+				if e := replay(latest); e != nil {
+					GLogger.Error("Exception in copying the latest version %v of %v/%v again, an older version is current, reason: %v",
+						latest.VersionId, latest.BucketName, latest.Name, e)
+				}
+			}
+			return err
+		}
+	}
+	if !deleteReplayed {
+		return nil
+	}
+	for _, version := range versions {
+		if err := manager.DeleteFileVersion(version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete a specific version of a file, deleting a delete marker brings the file back
+func (manager *S3Manager) DeleteFileVersion(file *S3File) error {
+	if file.VersionId == "" {
+		return errors.New("no version id given for " + file.BucketName + "/" + file.Name)
+	}
+	input := &s3.DeleteObjectInput{
+		Bucket:    aws.String(file.BucketName),
+		Key:       aws.String(file.Name),
+		VersionId: aws.String(file.VersionId),
+	}
+	res, err := manager.s3cli.DeleteObject(input)
+	if err != nil {
+		return err
+	}
+	GLogger.Debug("deleted version %v of file %v, res=%v", file.VersionId, file.BucketName+"/"+file.Name, res)
+	return nil
+}
+
+// user metadata of rollback copies naming the version they were copied from
+const RollbackMetadata = "Rollback-Version"
+
+// Roll file back to target, a noncurrent version, by copying it in place. The copy is marked with
+// the target's version id, its etag doesn't tell multipart copies apart, see IsRolledBack
+func (manager *S3Manager) RollbackFile(target *S3File, storageClass string) error {
+	return manager.RecoverFile(target, &CopyOptions{
+		StorageClass: storageClass,
+		Metadata:     &MetadataOptions{Metadata: map[string]string{RollbackMetadata: target.VersionId}},
+	})
+}
+
+// whether the current version of the file is a rollback copy of target
+func (manager *S3Manager) IsRolledBack(target *S3File) (bool, error) {
+	head, err := manager.headObject(&S3File{BucketName: target.BucketName, Name: target.Name}, nil)
+	if err != nil {
+		return false, err
+	}
+	for key, value := range head.Metadata {
+		if strings.EqualFold(key, RollbackMetadata) {
+			return aws.StringValue(value) == target.VersionId, nil
+		}
+	}
+	return false, nil
+}
+
+// Delete markers on top of the history which hide the latest version, nil if the file isn't deleted,
+// has no version to bring back or any of those markers was not created in [after, before)
+func UndeleteMarkers(history []*S3File, after time.Time, before time.Time) []*S3File {
+	var markers []*S3File
+	i := len(history) - 1
+	for ; i >= 0 && history[i].IsDeleteMarker; i-- {
+		marker := history[i]
+		if marker.LastModified.Before(after) || (!before.IsZero() && !marker.LastModified.Before(before)) {
+			return nil
+		}
+		markers = append(markers, marker)
+	}
+	if i < 0 {
+		return nil
+	}
+	return markers
+}
+
+// The version which was current at the time, nil if the file didn't exist yet. The version is a
+// delete marker if the file was deleted at the time
+func VersionAt(history []*S3File, at time.Time) *S3File {
+	var res *S3File
+	for _, version := range history {
+		if version.LastModified.After(at) {
+			break
+		}
+		res = version
+	}
+	return res
+}
+
+// nil for the current version, the version id parameter must not be empty
+func versionId(file *S3File) *string {
+	if file.VersionId == "" {
+		return nil
+	}
+	return aws.String(file.VersionId)
+}
@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecoverFileVersions(t *testing.T) {
+	const restored = `ongoing-request="false", expiry-date="Fri, 03 Jan 2020 00:00:00 GMT"`
+	type version struct {
+		id, class, restore string
+		marker             bool
+	}
+	tests := []struct {
+		name           string
+		history        []version
+		failCopy       string
+		deleteReplayed bool
+		err            string
+		copied         []string
+		// body of the current object after the recovery, empty if deleted
+		current  string
+		versions int
+	}{
+		{"not restored", []version{{"v1", "GLACIER", restored, false}, {"v2", "STANDARD", "", false}, {"v3", "GLACIER", "", false}},
+			"", false, "version v3 of bucket/a.txt isn't restored", nil, "v3", 3},
+		{"restore ongoing", []version{{"v1", "GLACIER", `ongoing-request="true"`, false}, {"v2", "STANDARD", "", false}},
+			"", false, "version v1 of bucket/a.txt isn't restored", nil, "v2", 2},
+		{"recovered", []version{{"v1", "GLACIER", restored, false}, {"v2", "STANDARD", "", false}, {"v3", "GLACIER", restored, false}},
+			"", false, "", []string{"v1", "v2", "v3"}, "v3", 3},
+		{"replayed versions deleted", []version{{"v1", "GLACIER", restored, false}, {"v2", "STANDARD", "", false}},
+			"", true, "", []string{"v1", "v2"}, "v2", 0},
+		{"copy fails", []version{{"v1", "GLACIER", restored, false}, {"v2", "STANDARD", "", false}, {"v3", "GLACIER", restored, false}},
+			"v2", true, "500", []string{"v1", "v2", "v3"}, "v3", 3},
+		// nothing was copied, the latest version is still current
+		{"first copy fails", []version{{"v1", "GLACIER", restored, false}, {"v2", "STANDARD", "", false}},
+			"v1", false, "500", []string{"v1"}, "v2", 2},
+		{"latest copy fails", []version{{"v1", "GLACIER", restored, false}, {"v2", "STANDARD", "", false}},
+			"v2", false, "500", []string{"v1", "v2", "v2"}, "v1", 2},
+		{"deleted", []version{{"v1", "GLACIER", restored, false}, {"v2", "STANDARD", "", false}, {"m1", "", "", true}},
+			"v2", false, "500", []string{"v1", "v2"}, "", 2},
+	}
+	for _, test := range tests {
+		fake := newFakeS3()
+		var versions []*S3File
+		for _, v := range test.history {
+			versions = append(versions, &S3File{BucketName: "bucket", Name: "a.txt", VersionId: v.id, StorageClass: v.class, IsDeleteMarker: v.marker})
+			if !v.marker {
+				fake.putVersion("a.txt", v.id, v.id, v.class, v.restore)
+				fake.put("a.txt", v.id, time.Now())
+			} else {
+				delete(fake.objects, "a.txt")
+			}
+		}
+		fake.failCopy[test.failCopy] = true
+		err := fake.manager().RecoverFileVersions(versions, &CopyOptions{StorageClass: "STANDARD_IA"}, test.deleteReplayed)
+		fake.server.Close()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%v: got error %v, want %q", test.name, err, test.err)
+		}
+		if !reflect.DeepEqual(fake.copied, test.copied) {
+			t.Errorf("%v: copied %v, want %v", test.name, fake.copied, test.copied)
+		}
+		current := ""
+		if object := fake.objects["a.txt"]; object != nil {
+			current = string(object.body)
+		}
+		if current != test.current {
+			t.Errorf("%v: current version is %q, want %q", test.name, current, test.current)
+		}
+		if len(fake.versions) != test.versions {
+			t.Errorf("%v: %v versions left, want %v", test.name, len(fake.versions), test.versions)
+		}
+	}
+}
+
+func TestUndeleteMarkers(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	version := func(id string, d int) *S3File { return &S3File{VersionId: id, LastModified: day(d)} }
+	marker := func(id string, d int) *S3File {
+		return &S3File{VersionId: id, LastModified: day(d), IsDeleteMarker: true}
+	}
+	tests := []struct {
+		name    string
+		history []*S3File
+		after   time.Time
+		before  time.Time
+		want    []string
+	}{
+		{"not deleted", []*S3File{version("v1", 1), version("v2", 2)}, time.Time{}, time.Time{}, nil},
+		{"deleted", []*S3File{version("v1", 1), marker("m1", 3)}, time.Time{}, time.Time{}, []string{"m1"}},
+		// markers are returned newest first
+		{"deleted twice", []*S3File{version("v1", 1), marker("m1", 3), marker("m2", 4)}, time.Time{}, time.Time{}, []string{"m2", "m1"}},
+		{"only markers", []*S3File{marker("m1", 3)}, time.Time{}, time.Time{}, nil},
+		{"in window", []*S3File{version("v1", 1), marker("m1", 3)}, day(3), day(4), []string{"m1"}},
+		{"before window", []*S3File{version("v1", 1), marker("m1", 3)}, day(4), time.Time{}, nil},
+		{"at end of window", []*S3File{version("v1", 1), marker("m1", 3)}, time.Time{}, day(3), nil},
+		{"one marker outside", []*S3File{version("v1", 1), marker("m1", 2), marker("m2", 4)}, day(3), time.Time{}, nil},
+	}
+	for _, test := range tests {
+		got := UndeleteMarkers(test.history, test.after, test.before)
+		if len(got) != len(test.want) {
+			t.Errorf("%v: got %v markers, want %v", test.name, len(got), test.want)
+			continue
+		}
+		for i, m := range got {
+			if m.VersionId != test.want[i] {
+				t.Errorf("%v: marker %v is %v, want %v", test.name, i, m.VersionId, test.want[i])
+			}
+		}
+	}
+}
+
+func TestVersionAt(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	history := []*S3File{
+		{VersionId: "v1", LastModified: day(2)},
+		{VersionId: "m1", LastModified: day(4), IsDeleteMarker: true},
+		{VersionId: "v2", LastModified: day(6)},
+	}
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{day(1), ""},
+		{day(2), "v1"},
+		{day(3), "v1"},
+		{day(4), "m1"},
+		{day(5), "m1"},
+		{day(6), "v2"},
+		{day(9), "v2"},
+	}
+	for _, test := range tests {
+		got := VersionAt(history, test.at)
+		id := ""
+		if got != nil {
+			id = got.VersionId
+		}
+		if id != test.want {
+			t.Errorf("VersionAt(%v) = %q, want %q", test.at, id, test.want)
+		}
+	}
+	if VersionAt(nil, day(1)) != nil {
+		t.Errorf("VersionAt of no history isn't nil")
+	}
+}
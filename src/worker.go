@@ -2,20 +2,29 @@ package main
 
 import (
 	"crazys3/src/pkg"
+	"errors"
 	"net"
 	"net/rpc"
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func main() {
 	pkg.BootStrap()
 	handler := &RpcHandler{
-		migraChan:   make(chan *pkg.MigrationRequest, 10000),
-		restoreChan: make(chan *pkg.RestorationRequest, 10000),
-		recoverChan: make(chan *pkg.RecoveryRequest, 10000),
-		mutex:       &sync.Mutex{},
+		migraChan:    make(chan *pkg.MigrationRequest, 10000),
+		restoreChan:  make(chan *pkg.RestorationRequest, 10000),
+		recoverChan:  make(chan *pkg.RecoveryRequest, 10000),
+		verifyChan:   make(chan *pkg.VerificationRequest, 10000),
+		archiveChan:  make(chan *pkg.ArchiveRequest, 10000),
+		undeleteChan: make(chan *pkg.UndeleteRequest, 10000),
+		rollbackChan: make(chan *pkg.RollbackRequest, 10000),
+		scanChan:     make(chan *pkg.ScanRequest, 10000),
+		monitorChan:  make(chan *pkg.RestoreMonitorRequest, 10000),
+		mutex:        &sync.Mutex{},
 	}
 	err := rpcServe(handler)
 	if err != nil {
@@ -31,8 +40,22 @@ type RpcHandler struct {
 	migraChan       chan *pkg.MigrationRequest
 	restoreChan     chan *pkg.RestorationRequest
 	recoverChan     chan *pkg.RecoveryRequest
+	verifyChan      chan *pkg.VerificationRequest
+	discrepancies   []*pkg.Discrepancy
+	archiveChan     chan *pkg.ArchiveRequest
+	archived        []*pkg.ArchiveRequest
+	undeleteChan    chan *pkg.UndeleteRequest
+	rollbackChan    chan *pkg.RollbackRequest
+	scanChan        chan *pkg.ScanRequest
+	scanReport      *pkg.ScanReport
+	monitorChan     chan *pkg.RestoreMonitorRequest
+	monitorReport   *pkg.RestoreMonitorReport
+	summary         pkg.JobSummary
 	manager         *pkg.S3Manager
 	manager2        *pkg.S3Manager
+	credentials     *pkg.PushedCredentials
+	credentials2    *pkg.PushedCredentials
+	encryption      *pkg.EncryptionOptions
 	taskFinished    bool
 	finishedThreads int
 }
@@ -48,25 +71,59 @@ func (handler *RpcHandler) HandleTaskStatus(cmd string, ack *bool) error {
 	return nil
 }
 
-
 func (handler *RpcHandler) HandleS3Info(req *pkg.S3InfoRequest, ack *bool) error {
 	pkg.GLogger.Debug("RPC CMD [HandleS3Info] received")
-	manager, err := pkg.NewS3ManagerWithKey(req.Region1, req.AwsKey, req.AwsSecret)
+	handler.credentials = pkg.NewPushedCredentials(req.AwsKey, req.AwsSecret, req.AwsToken)
+	handler.credentials2 = handler.credentials
+	if req.AwsKey2 != "" && req.AwsKey2 != req.AwsKey {
+		handler.credentials2 = pkg.NewPushedCredentials(req.AwsKey2, req.AwsSecret2, req.AwsToken2)
+	}
+	manager, err := pkg.NewS3ManagerWithCredentials(req.Region1, handler.credentials, req.Endpoint1)
 	if err != nil {
 		return err
 	}
 	if req.Region2 != "" {
-		manager2, err := pkg.NewS3ManagerWithKey(req.Region2, req.AwsKey, req.AwsSecret)
+		manager2, err := pkg.NewS3ManagerWithCredentials(req.Region2, handler.credentials2, req.Endpoint2)
 		if err != nil {
 			return err
 		}
 		handler.manager2 = manager2
 	}
 	handler.manager = manager
+	handler.encryption = req.Encryption
+	*ack = true
+	return nil
+}
+
+func (handler *RpcHandler) HandleCredentials(req *pkg.CredentialsRequest, ack *bool) error {
+	pkg.GLogger.Debug("RPC CMD [HandleCredentials] received")
+	if handler.credentials == nil {
+		return errors.New("no job is running")
+	}
+	if req.AwsKey != "" {
+		handler.credentials.Update(req.AwsKey, req.AwsSecret, req.AwsToken)
+	}
+	if req.AwsKey2 != "" && handler.credentials2 != handler.credentials {
+		handler.credentials2.Update(req.AwsKey2, req.AwsSecret2, req.AwsToken2)
+	}
 	*ack = true
 	return nil
 }
 
+// list a page of a range of the job's source bucket for master
+func (handler *RpcHandler) HandleListRange(req *pkg.ListRangeRequest, res *pkg.ListRangeResponse) error {
+	pkg.GLogger.Debug("RPC CMD [HandleListRange] received")
+	if handler.manager == nil {
+		return errors.New("no job is running")
+	}
+	files, more, err := handler.manager.ListRangePage(req.Bucket, &req.Range)
+	if err != nil {
+		return err
+	}
+	res.Files, res.More = files, more
+	return nil
+}
+
 func (handler *RpcHandler) HandleMigration(reqs []*pkg.MigrationRequest, ack *bool) error {
 	pkg.GLogger.Debug("RPC CMD [HandleMigration] received")
 	for _, req := range reqs {
@@ -109,6 +166,161 @@ func (handler *RpcHandler) HandleRecovery(reqs []*pkg.RecoveryRequest, ack *bool
 	return nil
 }
 
+func (handler *RpcHandler) HandleVerification(reqs []*pkg.VerificationRequest, ack *bool) error {
+	pkg.GLogger.Debug("RPC CMD [HandleVerification] received")
+	for _, req := range reqs {
+		if req.Finished {
+			for i := 0; i < runtime.NumCPU(); i++ {
+				handler.verifyChan <- req
+			}
+		} else {
+			handler.verifyChan <- req
+		}
+	}
+	return nil
+}
+
+// discrepancies found by the last verification job since it was last asked, they are handed over once
+func (handler *RpcHandler) HandleVerificationReport(cmd string, report *[]*pkg.Discrepancy) error {
+	pkg.GLogger.Debug("RPC CMD [HandleVerificationReport] received")
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	*report = handler.discrepancies
+	handler.discrepancies = nil
+	return nil
+}
+
+func (handler *RpcHandler) HandleArchive(reqs []*pkg.ArchiveRequest, ack *bool) error {
+	pkg.GLogger.Debug("RPC CMD [HandleArchive] received")
+	for _, req := range reqs {
+		if req.Finished {
+			for i := 0; i < runtime.NumCPU(); i++ {
+				handler.archiveChan <- req
+			}
+		} else {
+			handler.archiveChan <- req
+		}
+	}
+	return nil
+}
+
+// files archived by the last archive job since it was last asked, they are handed over once
+func (handler *RpcHandler) HandleArchiveManifest(cmd string, manifest *[]*pkg.ArchiveRequest) error {
+	pkg.GLogger.Debug("RPC CMD [HandleArchiveManifest] received")
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	*manifest = handler.archived
+	handler.archived = nil
+	return nil
+}
+
+func (handler *RpcHandler) HandleScan(reqs []*pkg.ScanRequest, ack *bool) error {
+	pkg.GLogger.Debug("RPC CMD [HandleScan] received")
+	for _, req := range reqs {
+		if req.Finished {
+			for i := 0; i < runtime.NumCPU(); i++ {
+				handler.scanChan <- req
+			}
+		} else {
+			handler.scanChan <- req
+		}
+	}
+	return nil
+}
+
+// statistics of the files scanned by the last scan job
+func (handler *RpcHandler) HandleScanReport(cmd string, report *pkg.ScanReport) error {
+	pkg.GLogger.Debug("RPC CMD [HandleScanReport] received")
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	if handler.scanReport != nil {
+		*report = *handler.scanReport
+	}
+	return nil
+}
+
+func (handler *RpcHandler) HandleRestoreMonitor(reqs []*pkg.RestoreMonitorRequest, ack *bool) error {
+	pkg.GLogger.Debug("RPC CMD [HandleRestoreMonitor] received")
+	for _, req := range reqs {
+		if req.Finished {
+			for i := 0; i < runtime.NumCPU(); i++ {
+				handler.monitorChan <- req
+			}
+		} else {
+			handler.monitorChan <- req
+		}
+	}
+	return nil
+}
+
+// restore status of the files checked by the last restore monitor job
+func (handler *RpcHandler) HandleRestoreMonitorReport(cmd string, report *pkg.RestoreMonitorReport) error {
+	pkg.GLogger.Debug("RPC CMD [HandleRestoreMonitorReport] received")
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	if handler.monitorReport != nil {
+		*report = *handler.monitorReport
+	}
+	return nil
+}
+
+func (handler *RpcHandler) HandleUndelete(reqs []*pkg.UndeleteRequest, ack *bool) error {
+	pkg.GLogger.Debug("RPC CMD [HandleUndelete] received")
+	for _, req := range reqs {
+		if req.Finished {
+			for i := 0; i < runtime.NumCPU(); i++ {
+				handler.undeleteChan <- req
+			}
+		} else {
+			handler.undeleteChan <- req
+		}
+	}
+	return nil
+}
+
+func (handler *RpcHandler) HandleRollback(reqs []*pkg.RollbackRequest, ack *bool) error {
+	pkg.GLogger.Debug("RPC CMD [HandleRollback] received")
+	for _, req := range reqs {
+		if req.Finished {
+			for i := 0; i < runtime.NumCPU(); i++ {
+				handler.rollbackChan <- req
+			}
+		} else {
+			handler.rollbackChan <- req
+		}
+	}
+	return nil
+}
+
+// counters of the last job
+func (handler *RpcHandler) HandleJobSummary(cmd string, summary *pkg.JobSummary) error {
+	pkg.GLogger.Debug("RPC CMD [HandleJobSummary] received")
+	summary.Processed = atomic.LoadInt64(&handler.summary.Processed)
+	summary.Skipped = atomic.LoadInt64(&handler.summary.Skipped)
+	summary.Failed = atomic.LoadInt64(&handler.summary.Failed)
+	return nil
+}
+
+// clear the job summary, it's read and counted without the mutex
+func (handler *RpcHandler) resetSummary() {
+	atomic.StoreInt64(&handler.summary.Processed, 0)
+	atomic.StoreInt64(&handler.summary.Skipped, 0)
+	atomic.StoreInt64(&handler.summary.Failed, 0)
+}
+
+// count the result of a request in the job summary
+func (handler *RpcHandler) count(err error) {
+	if err == pkg.ErrUnchanged {
+		atomic.AddInt64(&handler.summary.Skipped, 1)
+		return
+	}
+	if err != nil {
+		atomic.AddInt64(&handler.summary.Failed, 1)
+		return
+	}
+	atomic.AddInt64(&handler.summary.Processed, 1)
+}
+
 /******* jobs ********/
 
 func (handler *RpcHandler) StartMigraJob(cmd string, acl *bool) error {
@@ -117,7 +329,12 @@ func (handler *RpcHandler) StartMigraJob(cmd string, acl *bool) error {
 	handler.mutex.Lock()
 	handler.taskFinished = false
 	handler.finishedThreads = 0
+	handler.resetSummary()
 	handler.mutex.Unlock()
+	destManager := handler.manager
+	if handler.manager2 != nil {
+		destManager = handler.manager2
+	}
 	for i := 0; i < runtime.NumCPU(); i++ {
 		go func(i int) {
 			for {
@@ -126,11 +343,39 @@ func (handler *RpcHandler) StartMigraJob(cmd string, acl *bool) error {
 					if req.Finished {
 						goto EXIT
 					}
-					pkg.GLogger.Info("[Migration Job] thread %v is processing %v, id=%v", i, req.DestBucket+"/"+req.DestFileName, req.File.Id)
-					err := handler.manager.CopyFile(req.SourceBucket, req.File.Name, req.DestBucket, req.DestFileName, handler.manager2)
+					if req.Delete {
+						pkg.GLogger.Info("[Migration Job] thread %v is deleting %v, id=%v", i, req.DestBucket+"/"+req.DestFileName, req.File.Id)
+						err := destManager.DeleteFile(req.DestBucket, req.DestFileName)
+						if err != nil {
+							pkg.GLogger.Warning("[Migration Job] Exception in deleting %v/%v, reason: %v", req.DestBucket, req.DestFileName, err)
+						}
+						handler.count(err)
+						continue
+					}
+					ok, err := destManager.ShouldCopy(req.ConflictPolicy, req.File, req.DestBucket, req.DestFileName)
 					if err != nil {
+						pkg.GLogger.Warning("[Migration Job] Exception in checking %v/%v, policy=%v, reason: %v", req.DestBucket, req.DestFileName, req.ConflictPolicy, err)
+						handler.count(err)
+						continue
+					}
+					if !ok {
+						pkg.GLogger.Info("[Migration Job] thread %v skipped %v, policy=%v, id=%v", i, req.DestBucket+"/"+req.DestFileName, req.ConflictPolicy, req.File.Id)
+						atomic.AddInt64(&handler.summary.Skipped, 1)
+						continue
+					}
+					pkg.GLogger.Info("[Migration Job] thread %v is processing %v, id=%v", i, req.DestBucket+"/"+req.DestFileName, req.File.Id)
+					options := &pkg.CopyOptions{StorageClass: req.StorageClass, Metadata: req.Metadata, Encryption: handler.encryption}
+					if len(req.Versions) > 0 {
+						err = handler.manager.CopyFileVersions(req.Versions, req.DestBucket, req.DestFileName, options, handler.manager2)
+					} else {
+						err = handler.manager.CopyFile(req.File, req.DestBucket, req.DestFileName, options, handler.manager2)
+					}
+					if err == pkg.ErrUnchanged {
+						pkg.GLogger.Info("[Migration Job] thread %v skipped %v, already up to date, id=%v", i, req.DestBucket+"/"+req.DestFileName, req.File.Id)
+					} else if err != nil {
 						pkg.GLogger.Warning("[Migration Job] Exception in copying %v/%v to %v/%v, reason: %v", req.SourceBucket, req.File.Name, req.DestBucket, req.DestFileName, err)
 					}
+					handler.count(err)
 				}
 			}
 		EXIT:
@@ -153,6 +398,7 @@ func (handler *RpcHandler) StartRestorationJob(cmd string, acl *bool) error {
 	handler.mutex.Lock()
 	handler.taskFinished = false
 	handler.finishedThreads = 0
+	handler.resetSummary()
 	handler.mutex.Unlock()
 	for i := 0; i < runtime.NumCPU(); i++ {
 		go func(i int) {
@@ -162,11 +408,12 @@ func (handler *RpcHandler) StartRestorationJob(cmd string, acl *bool) error {
 					if req.Finished {
 						goto EXIT
 					}
-					pkg.GLogger.Info("[Restoration Job] thread %v is processing %v, id=%v", i, req.Bucket+"/"+req.File.Name, req.File.Id)
-					err := handler.manager.RestoreFile(req.Bucket, req.File.Name, req.Days, req.Speed)
+					pkg.GLogger.Info("[Restoration Job] thread %v is processing %v, version=%v, id=%v", i, req.Bucket+"/"+req.File.Name, req.File.VersionId, req.File.Id)
+					err := handler.manager.RestoreFile(req.File, req.Days, req.Speed)
 					if err != nil {
 						pkg.GLogger.Warning("[Restoration Job] Exception in restoring %v/%v, reason: %v", req.Bucket, req.File.Name, err)
 					}
+					handler.count(err)
 				}
 			}
 		EXIT:
@@ -189,6 +436,7 @@ func (handler *RpcHandler) StartRecoveryJob(cmd string, acl *bool) error {
 	handler.mutex.Lock()
 	handler.taskFinished = false
 	handler.finishedThreads = 0
+	handler.resetSummary()
 	handler.mutex.Unlock()
 	for i := 0; i < runtime.NumCPU(); i++ {
 		go func(i int) {
@@ -198,11 +446,18 @@ func (handler *RpcHandler) StartRecoveryJob(cmd string, acl *bool) error {
 					if req.Finished {
 						goto EXIT
 					}
-					pkg.GLogger.Info("[Recovery Job] thread %v is processing %v, id=%v", i, req.Bucket+"/"+req.File.Name, req.File.Id)
-					err := handler.manager.RecoverFile(req.Bucket, req.File.Name)
+					pkg.GLogger.Info("[Recovery Job] thread %v is processing %v, class=%v, id=%v", i, req.Bucket+"/"+req.File.Name, req.StorageClass, req.File.Id)
+					options := &pkg.CopyOptions{StorageClass: req.StorageClass, Metadata: req.Metadata, Encryption: handler.encryption}
+					var err error
+					if len(req.Versions) > 0 {
+						err = handler.manager.RecoverFileVersions(req.Versions, options, req.DeleteReplayed)
+					} else {
+						err = handler.manager.RecoverFile(req.File, options)
+					}
 					if err != nil {
 						pkg.GLogger.Warning("[Recovery Job] Exception in recovering %v/%v, reason: %v", req.Bucket, req.File.Name, err)
 					}
+					handler.count(err)
 				}
 			}
 		EXIT:
@@ -219,6 +474,358 @@ func (handler *RpcHandler) StartRecoveryJob(cmd string, acl *bool) error {
 	return nil
 }
 
+func (handler *RpcHandler) StartVerificationJob(cmd string, acl *bool) error {
+	pkg.GLogger.Debug("RPC CMD [StartVerificationJob] received")
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data verification job %v threads are ready <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<", runtime.NumCPU())
+	handler.mutex.Lock()
+	handler.taskFinished = false
+	handler.finishedThreads = 0
+	handler.resetSummary()
+	handler.discrepancies = nil
+	handler.mutex.Unlock()
+	destManager := handler.manager
+	if handler.manager2 != nil {
+		destManager = handler.manager2
+	}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func(i int) {
+			for {
+				select {
+				case req := <-handler.verifyChan:
+					if req.Finished {
+						goto EXIT
+					}
+					pkg.GLogger.Info("[Verification Job] thread %v is processing %v, id=%v", i, req.DestBucket+"/"+req.DestFileName, req.File.Id)
+					sourceAcl, err := handler.manager.GetFileAcls(req.SourceBucket, req.File.Name)
+					if err != nil {
+						pkg.GLogger.Warning("[Verification Job] Exception in getting acls of %v/%v, reason: %v", req.SourceBucket, req.File.Name, err)
+						handler.count(err)
+						continue
+					}
+					destAcl, err := destManager.GetFileAcls(req.DestBucket, req.DestFileName)
+					if err != nil {
+						pkg.GLogger.Warning("[Verification Job] Exception in getting acls of %v/%v, reason: %v", req.DestBucket, req.DestFileName, err)
+						handler.count(err)
+						continue
+					}
+					if !pkg.AclEqual(sourceAcl, destAcl) {
+						handler.mutex.Lock()
+						handler.discrepancies = append(handler.discrepancies, &pkg.Discrepancy{
+							Key:    req.File.Name,
+							Kind:   pkg.DiscrepancyAcl,
+							Source: req.File,
+							Detail: "grants differ",
+						})
+						handler.mutex.Unlock()
+					}
+					handler.count(nil)
+				}
+			}
+		EXIT:
+			pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data verification thread %v closed <<<<<<<<<<<<<<<<<<<<<<<<<<", i)
+			handler.mutex.Lock()
+			handler.finishedThreads++
+			if handler.finishedThreads == runtime.NumCPU() {
+				handler.taskFinished = true
+			}
+			handler.mutex.Unlock()
+			return
+		}(i)
+	}
+	return nil
+}
+
+func (handler *RpcHandler) StartArchiveJob(cmd string, acl *bool) error {
+	pkg.GLogger.Debug("RPC CMD [StartArchiveJob] received")
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data archive job %v threads are ready <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<", runtime.NumCPU())
+	handler.mutex.Lock()
+	handler.taskFinished = false
+	handler.finishedThreads = 0
+	handler.resetSummary()
+	handler.archived = nil
+	handler.mutex.Unlock()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func(i int) {
+			for {
+				select {
+				case req := <-handler.archiveChan:
+					if req.Finished {
+						goto EXIT
+					}
+					pkg.GLogger.Info("[Archive Job] thread %v is processing %v, class=%v, id=%v", i, req.Bucket+"/"+req.File.Name, req.StorageClass, req.File.Id)
+					// archiving is a recovery into a cold storage class
+					err := handler.manager.RecoverFile(req.File, &pkg.CopyOptions{StorageClass: req.StorageClass})
+					if err != nil {
+						pkg.GLogger.Warning("[Archive Job] Exception in archiving %v/%v, reason: %v", req.Bucket, req.File.Name, err)
+					} else {
+						handler.mutex.Lock()
+						handler.archived = append(handler.archived, req)
+						handler.mutex.Unlock()
+					}
+					handler.count(err)
+				}
+			}
+		EXIT:
+			pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data archive thread %v closed <<<<<<<<<<<<<<<<<<<<<<<<<<", i)
+			handler.mutex.Lock()
+			handler.finishedThreads++
+			if handler.finishedThreads == runtime.NumCPU() {
+				handler.taskFinished = true
+			}
+			handler.mutex.Unlock()
+			return
+		}(i)
+	}
+	return nil
+}
+
+func (handler *RpcHandler) StartScanJob(cmd string, acl *bool) error {
+	pkg.GLogger.Debug("RPC CMD [StartScanJob] received")
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data scan job %v threads are ready <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<", runtime.NumCPU())
+	handler.mutex.Lock()
+	handler.taskFinished = false
+	handler.finishedThreads = 0
+	handler.resetSummary()
+	handler.scanReport = pkg.NewScanReport()
+	handler.mutex.Unlock()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func(i int) {
+			// merged into the worker's report when the thread is done
+			report := pkg.NewScanReport()
+			for {
+				select {
+				case req := <-handler.scanChan:
+					if req.Finished {
+						goto EXIT
+					}
+					pkg.GLogger.Debug("[Scan Job] thread %v is processing %v, id=%v", i, req.Bucket+"/"+req.File.Name, req.File.Id)
+					file := req.File
+					var err error
+					// listings don't tell whether archived files are restored
+					if pkg.IsArchived(file.StorageClass) {
+						var head *pkg.S3File
+						head, err = handler.manager.GetFileVersion(file)
+						if err != nil {
+							pkg.GLogger.Warning("[Scan Job] Exception in getting restore status of %v/%v, reason: %v", req.Bucket, file.Name, err)
+						} else if head != nil {
+							file.Restore = head.Restore
+						}
+					}
+					report.Add(file, req.Prefix)
+					handler.count(err)
+				}
+			}
+		EXIT:
+			pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data scan thread %v closed <<<<<<<<<<<<<<<<<<<<<<<<<<", i)
+			handler.mutex.Lock()
+			handler.scanReport.Merge(report)
+			handler.finishedThreads++
+			if handler.finishedThreads == runtime.NumCPU() {
+				handler.taskFinished = true
+			}
+			handler.mutex.Unlock()
+			return
+		}(i)
+	}
+	return nil
+}
+
+func (handler *RpcHandler) StartRestoreMonitorJob(cmd string, acl *bool) error {
+	pkg.GLogger.Debug("RPC CMD [StartRestoreMonitorJob] received")
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> restore monitor job %v threads are ready <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<", runtime.NumCPU())
+	handler.mutex.Lock()
+	handler.taskFinished = false
+	handler.finishedThreads = 0
+	handler.resetSummary()
+	handler.monitorReport = &pkg.RestoreMonitorReport{}
+	handler.mutex.Unlock()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func(i int) {
+			for {
+				select {
+				case req := <-handler.monitorChan:
+					if req.Finished {
+						goto EXIT
+					}
+					pkg.GLogger.Debug("[Restore Monitor Job] thread %v is processing %v, id=%v", i, req.Bucket+"/"+req.File.Name, req.File.Id)
+					head, err := handler.manager.GetFileVersion(req.File)
+					if err == nil && head == nil {
+						err = errors.New("file not found")
+					}
+					if err != nil {
+						pkg.GLogger.Warning("[Restore Monitor Job] Exception in getting restore status of %v/%v, reason: %v", req.Bucket, req.File.Name, err)
+						handler.count(err)
+						continue
+					}
+					// storage classes of key lists may be unknown
+					if !pkg.IsArchived(head.StorageClass) {
+						atomic.AddInt64(&handler.summary.Skipped, 1)
+						continue
+					}
+					status, expiry := pkg.RestoreStatus(head, time.Now().AddDate(0, 0, int(req.Threshold)))
+					done := ""
+					if status == pkg.RestoreExpiring {
+						switch req.Action {
+						case pkg.MonitorExtend:
+							// the copy is restored already, the tier doesn't matter
+							err = handler.manager.RestoreFile(req.File, req.Days, "Standard")
+						case pkg.MonitorRecover:
+							err = handler.manager.RecoverFile(req.File, &pkg.CopyOptions{StorageClass: req.StorageClass, Metadata: req.Metadata, Encryption: handler.encryption})
+						default:
+							pkg.GLogger.Warning("[Restore Monitor Job] restored copy of %v/%v expires at %v and isn't recovered", req.Bucket, req.File.Name, expiry)
+						}
+						if err != nil {
+							pkg.GLogger.Warning("[Restore Monitor Job] Exception in trying to %v %v/%v expiring at %v, reason: %v", req.Action, req.Bucket, req.File.Name, expiry, err)
+						} else if req.Action == pkg.MonitorExtend || req.Action == pkg.MonitorRecover {
+							pkg.GLogger.Info("[Restore Monitor Job] thread %v processed %v expiring at %v, action=%v, id=%v", i, req.Bucket+"/"+req.File.Name, expiry, req.Action, req.File.Id)
+							done = req.Action
+						}
+					}
+					handler.mutex.Lock()
+					handler.monitorReport.Add(head, status, expiry, done)
+					handler.mutex.Unlock()
+					handler.count(err)
+				}
+			}
+		EXIT:
+			pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> restore monitor thread %v closed <<<<<<<<<<<<<<<<<<<<<<<<<<", i)
+			handler.mutex.Lock()
+			handler.finishedThreads++
+			if handler.finishedThreads == runtime.NumCPU() {
+				handler.taskFinished = true
+			}
+			handler.mutex.Unlock()
+			return
+		}(i)
+	}
+	return nil
+}
+
+func (handler *RpcHandler) StartUndeleteJob(cmd string, acl *bool) error {
+	pkg.GLogger.Debug("RPC CMD [StartUndeleteJob] received")
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data undelete job %v threads are ready <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<", runtime.NumCPU())
+	handler.mutex.Lock()
+	handler.taskFinished = false
+	handler.finishedThreads = 0
+	handler.resetSummary()
+	handler.mutex.Unlock()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func(i int) {
+			for {
+				select {
+				case req := <-handler.undeleteChan:
+					if req.Finished {
+						goto EXIT
+					}
+					pkg.GLogger.Info("[Undelete Job] thread %v is removing delete marker %v of %v, id=%v", i, req.File.VersionId, req.File.BucketName+"/"+req.File.Name, req.File.Id)
+					err := handler.manager.DeleteFileVersion(req.File)
+					if err != nil {
+						pkg.GLogger.Warning("[Undelete Job] Exception in removing delete marker %v of %v/%v, reason: %v", req.File.VersionId, req.File.BucketName, req.File.Name, err)
+					}
+					handler.count(err)
+				}
+			}
+		EXIT:
+			pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data undelete thread %v closed <<<<<<<<<<<<<<<<<<<<<<<<<<", i)
+			handler.mutex.Lock()
+			handler.finishedThreads++
+			if handler.finishedThreads == runtime.NumCPU() {
+				handler.taskFinished = true
+			}
+			handler.mutex.Unlock()
+			return
+		}(i)
+	}
+	return nil
+}
+
+func (handler *RpcHandler) StartRollbackJob(cmd string, acl *bool) error {
+	pkg.GLogger.Debug("RPC CMD [StartRollbackJob] received")
+	pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data rollback job %v threads are ready <<<<<<<<<<<<<<<<<<<<<<<<<<<<<<", runtime.NumCPU())
+	handler.mutex.Lock()
+	handler.taskFinished = false
+	handler.finishedThreads = 0
+	handler.resetSummary()
+	handler.mutex.Unlock()
+	for i := 0; i < runtime.NumCPU(); i++ {
+		go func(i int) {
+			for {
+				select {
+				case req := <-handler.rollbackChan:
+					if req.Finished {
+						goto EXIT
+					}
+					if req.Delete {
+						pkg.GLogger.Info("[Rollback Job] thread %v is deleting %v, id=%v", i, req.File.BucketName+"/"+req.File.Name, req.File.Id)
+						err := handler.manager.DeleteFile(req.File.BucketName, req.File.Name)
+						if err != nil {
+							pkg.GLogger.Warning("[Rollback Job] Exception in deleting %v/%v, reason: %v", req.File.BucketName, req.File.Name, err)
+						}
+						handler.count(err)
+						continue
+					}
+					// multipart copies of an earlier run have another etag than their version
+					rolledBack, err := handler.manager.IsRolledBack(req.File)
+					if err != nil {
+						pkg.GLogger.Warning("[Rollback Job] Exception in checking %v/%v, reason: %v", req.File.BucketName, req.File.Name, err)
+						handler.count(err)
+						continue
+					}
+					if rolledBack {
+						pkg.GLogger.Info("[Rollback Job] thread %v skipped %v, already rolled back to version %v, id=%v", i, req.File.BucketName+"/"+req.File.Name, req.File.VersionId, req.File.Id)
+						atomic.AddInt64(&handler.summary.Skipped, 1)
+						continue
+					}
+					storageClass := req.File.StorageClass
+					if pkg.IsArchived(storageClass) {
+						// archived versions are restored first, the job has to be run again once they are
+						head, err := handler.manager.GetFileVersion(req.File)
+						if err == nil && head == nil {
+							err = errors.New("version not found")
+						}
+						if err != nil {
+							pkg.GLogger.Warning("[Rollback Job] Exception in checking version %v of %v/%v, reason: %v", req.File.VersionId, req.File.BucketName, req.File.Name, err)
+							handler.count(err)
+							continue
+						}
+						requested, ongoing, _ := pkg.ParseRestore(head.Restore)
+						if !requested {
+							err = handler.manager.RestoreFile(req.File, req.Days, req.Speed)
+							if err != nil {
+								pkg.GLogger.Warning("[Rollback Job] Exception in restoring version %v of %v/%v, reason: %v", req.File.VersionId, req.File.BucketName, req.File.Name, err)
+								handler.count(err)
+								continue
+							}
+						}
+						if !requested || ongoing {
+							pkg.GLogger.Info("[Rollback Job] thread %v is waiting for restoration of %v, version=%v, id=%v", i, req.File.BucketName+"/"+req.File.Name, req.File.VersionId, req.File.Id)
+							atomic.AddInt64(&handler.summary.Skipped, 1)
+							continue
+						}
+						storageClass = "STANDARD"
+					}
+					pkg.GLogger.Info("[Rollback Job] thread %v is processing %v, version=%v, id=%v", i, req.File.BucketName+"/"+req.File.Name, req.File.VersionId, req.File.Id)
+					err = handler.manager.RollbackFile(req.File, storageClass)
+					if err != nil {
+						pkg.GLogger.Warning("[Rollback Job] Exception in rolling back %v/%v to version %v, reason: %v", req.File.BucketName, req.File.Name, req.File.VersionId, err)
+					}
+					handler.count(err)
+				}
+			}
+		EXIT:
+			pkg.GLogger.Info(">>>>>>>>>>>>>>>>>>>>>>>>> data rollback thread %v closed <<<<<<<<<<<<<<<<<<<<<<<<<<", i)
+			handler.mutex.Lock()
+			handler.finishedThreads++
+			if handler.finishedThreads == runtime.NumCPU() {
+				handler.taskFinished = true
+			}
+			handler.mutex.Unlock()
+			return
+		}(i)
+	}
+	return nil
+}
+
 // blocking function
 func rpcServe(handler *RpcHandler) error {
 	addr, err := net.ResolveTCPAddr("tcp", pkg.GConfig.Worker+":"+strconv.Itoa(pkg.GConfig.WorkerPort))